@@ -114,6 +114,27 @@ func (r *DataReplacer) isSameType(schema *spec.Schema, requestValue interface{})
 			}
 		}
 
+	// `oneOf` is treated like `anyOf`: replacement is allowed if any branch
+	// applies.
+	case len(schema.OneOf) > 0:
+		for _, oneOfSchema := range schema.OneOf {
+			oneOfSchema, _ := r.maybeDereference(oneOfSchema, "")
+			if r.isSameType(oneOfSchema, requestValue) {
+				return true
+			}
+		}
+
+	// In the case of `allOf`, every branch must apply. Branches that only
+	// carry constraints (and no type) always do.
+	case len(schema.AllOf) > 0:
+		for _, allOfSchema := range schema.AllOf {
+			allOfSchema, _ := r.maybeDereference(allOfSchema, "")
+			if !r.isSameType(allOfSchema, requestValue) {
+				return false
+			}
+		}
+		return true
+
 	case schema.Type == spec.TypeArray:
 		valueSlice, ok := requestValue.([]interface{})
 
@@ -157,6 +178,11 @@ func (r *DataReplacer) isSameType(schema *spec.Schema, requestValue interface{})
 	case schema.Type == spec.TypeString:
 		return valueKind == reflect.String
 
+	// A schema without a type (like a bare `const`, or one that only
+	// constrains a value) allows any value.
+	case schema.Type == "":
+		return true
+
 	default:
 		panic(fmt.Sprintf("Data replacer doesn't know how to handle schema: %+v", schema))
 	}
@@ -55,6 +55,115 @@ func TestReplaceData_AnyOf(t *testing.T) {
 	}
 }
 
+func TestReplaceData_AllOf(t *testing.T) {
+	replacer := DataReplacer{Schema: &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"foo": {
+				AllOf: []*spec.Schema{
+					{Type: spec.TypeString},
+					{MaxLength: 20},
+				},
+			},
+		},
+	}}
+
+	// Incoming value matching every branch
+	{
+		responseData := map[string]interface{}{
+			"foo": "response",
+		}
+
+		replacer.ReplaceData(map[string]interface{}{
+			"foo": "request",
+		}, responseData)
+
+		assert.Equal(t, map[string]interface{}{
+			"foo": "request",
+		}, responseData)
+	}
+
+	// Incoming value not matching the typed branch
+	{
+		responseData := map[string]interface{}{
+			"foo": "response",
+		}
+
+		replacer.ReplaceData(map[string]interface{}{
+			"foo": 123,
+		}, responseData)
+
+		assert.Equal(t, map[string]interface{}{
+			"foo": "response",
+		}, responseData)
+	}
+}
+
+func TestReplaceData_OneOf(t *testing.T) {
+	replacer := DataReplacer{Schema: &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"foo": {
+				OneOf: []*spec.Schema{
+					{Type: spec.TypeString},
+					{Type: spec.TypeInteger},
+				},
+			},
+		},
+	}}
+
+	// Incoming values matching either branch
+	for _, value := range []interface{}{"request", 123} {
+		responseData := map[string]interface{}{
+			"foo": "response",
+		}
+
+		replacer.ReplaceData(map[string]interface{}{
+			"foo": value,
+		}, responseData)
+
+		assert.Equal(t, map[string]interface{}{
+			"foo": value,
+		}, responseData)
+	}
+
+	// Incoming non-matching value
+	{
+		responseData := map[string]interface{}{
+			"foo": "response",
+		}
+
+		replacer.ReplaceData(map[string]interface{}{
+			"foo": true,
+		}, responseData)
+
+		assert.Equal(t, map[string]interface{}{
+			"foo": "response",
+		}, responseData)
+	}
+}
+
+// Schemas without a type, like a bare `const`, accept anything.
+func TestReplaceData_Untyped(t *testing.T) {
+	replacer := DataReplacer{Schema: &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"foo": {
+				Const: "request",
+			},
+		},
+	}}
+
+	responseData := map[string]interface{}{
+		"foo": "response",
+	}
+
+	replacer.ReplaceData(map[string]interface{}{
+		"foo": "request",
+	}, responseData)
+
+	assert.Equal(t, map[string]interface{}{
+		"foo": "request",
+	}, responseData)
+}
+
 func TestReplaceData_Array(t *testing.T) {
 	replacer := DataReplacer{Schema: &spec.Schema{
 		Properties: map[string]*spec.Schema{
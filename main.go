@@ -9,7 +9,10 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/stripe/stripe-mock/server"
 )
@@ -43,6 +46,10 @@ func main() {
 	//
 	// Eventually, `-http` and `-https` could become shorthand synonyms for
 	// `-http-addr` and `-https-addr`.
+	flag.StringVar(&options.corsHeaders, "cors-headers", "", "Comma-separated request headers to allow from other origins in addition to Stripe's (requires -cors-origins)")
+	flag.StringVar(&options.corsOrigins, "cors-origins", "", "Comma-separated origins allowed to call stripe-mock from a browser, like 'http://localhost:3000'; '*' allows any origin")
+	flag.BoolVar(&options.har, "har", false, "Capture handled traffic so that it can be exported as HAR from /_stripe_mock/har")
+	flag.StringVar(&options.harOutPath, "har-out", "", "Path to write handled traffic to as HAR on shutdown; also enables -har")
 	flag.BoolVar(&options.http, "http", false, "Run with HTTP")
 	flag.StringVar(&options.httpAddr, "http-addr", "", fmt.Sprintf("Host and port to listen on for HTTP as `<ip>:<port>`; empty <ip> to bind all system IPs, empty <port> to have system choose; e.g. ':%v', '127.0.0.1:%v'", defaultPortHTTP, defaultPortHTTP))
 	flag.IntVar(&options.httpPort, "http-port", -1, "Port to listen on for HTTP; same as '-http-addr :<port>'")
@@ -53,11 +60,24 @@ func main() {
 	flag.IntVar(&options.httpsPort, "https-port", -1, "Port to listen on for HTTPS; same as '-https-addr :<port>'")
 	flag.StringVar(&options.httpsUnixSocket, "https-unix", "", "Unix socket to listen on for HTTPS")
 
+	flag.BoolVar(&options.populateOptionalFields, "populate-optional-fields", false, "Include optional properties in synthetic fixtures generated for objects that have no fixture")
 	flag.IntVar(&options.port, "port", -1, "Port to listen on; also respects PORT from environment")
+	flag.Int64Var(&options.faultSeed, "fault-seed", 0, "Seed for deciding which requests get faults so that a run can be reproduced; takes precedence over the seed in -faults")
+	flag.StringVar(&options.faultsPath, "faults", "", "Path to probabilities of injecting errors, timeouts, and dropped connections into responses (should be JSON)")
 	flag.StringVar(&options.fixturesPath, "fixtures", "", "Path to fixtures to use instead of bundled version (should be JSON)")
+	flag.StringVar(&options.latencyPath, "latency", "", "Path to latency profile with fixed, uniform, or normally distributed delays per operation (should be JSON)")
+	flag.Float64Var(&options.latencyMs, "latency-ms", 0, "Fixed delay in milliseconds added to every request; takes precedence over the default latency in -latency")
+	flag.IntVar(&options.rateLimitRead, "rate-limit-read", 0, "Maximum GET requests per second per API key; 0 for unlimited")
+	flag.IntVar(&options.rateLimitWrite, "rate-limit-write", 0, "Maximum non-GET requests per second per API key; 0 for unlimited")
+	flag.StringVar(&options.rateLimitsPath, "rate-limits", "", "Path to rate limits including per-operation limits (should be JSON); -rate-limit-read and -rate-limit-write take precedence")
+	flag.StringVar(&options.recordPath, "record", "", "Path to a cassette to record requests forwarded to -upstream and their responses to (should be JSON)")
+	flag.StringVar(&options.replayPath, "replay", "", "Path to a cassette of recorded responses to answer matching requests with (should be JSON)")
+	flag.StringVar(&options.restrictedKeysPath, "restricted-keys", "", "Path to permissions of restricted API keys (should be JSON)")
 	flag.StringVar(&options.specPath, "spec", "", "Path to OpenAPI spec to use instead of bundled version (should be JSON)")
+	flag.BoolVar(&options.stateful, "stateful", false, "Store objects created through the API and reflect them in subsequent requests")
 	flag.BoolVar(&options.strictVersionCheck, "strict-version-check", false, "Errors if version sent in Stripe-Version doesn't match the one in OpenAPI")
 	flag.StringVar(&options.unixSocket, "unix", "", "Unix socket to listen on")
+	flag.StringVar(&options.upstream, "upstream", "", "Base URL of a server to forward requests to while recording, like 'http://localhost:8080' (requires -record)")
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose mode")
 	flag.BoolVar(&options.showVersion, "version", false, "Show version and exit")
 	flag.BoolVar(&options.beta, "beta", false, "Run with beta OpenAPI spec and fixtures")
@@ -95,17 +115,114 @@ func main() {
 		abort(err.Error())
 	}
 
-	stub, err := server.NewStubServer(fixtures, stripeSpec, options.strictVersionCheck, verbose)
+	stub, err := server.NewStubServer(fixtures, stripeSpec, options.strictVersionCheck, options.stateful, verbose)
 	if err != nil {
 		abort(fmt.Sprintf("Error initializing router: %v\n", err))
 	}
 
+	rateLimits := &server.RateLimits{}
+	if options.rateLimitsPath != "" {
+		rateLimits, err = server.LoadRateLimits(options.rateLimitsPath)
+		if err != nil {
+			abort(err.Error())
+		}
+	}
+	if options.rateLimitRead != 0 {
+		rateLimits.Read = options.rateLimitRead
+	}
+	if options.rateLimitWrite != 0 {
+		rateLimits.Write = options.rateLimitWrite
+	}
+	err = stub.SetRateLimits(rateLimits)
+	if err != nil {
+		abort(fmt.Sprintf("Invalid rate limits: %v", err))
+	}
+
+	latencyProfile := &server.LatencyProfile{}
+	if options.latencyPath != "" {
+		latencyProfile, err = server.LoadLatencyProfile(options.latencyPath)
+		if err != nil {
+			abort(err.Error())
+		}
+	}
+	if options.latencyMs != 0 {
+		latencyProfile.Latency = server.Latency{Milliseconds: options.latencyMs}
+	}
+	err = stub.SetLatencyProfile(latencyProfile)
+	if err != nil {
+		abort(fmt.Sprintf("Invalid latency profile: %v", err))
+	}
+
+	if options.faultsPath != "" {
+		faultInjection, err := server.LoadFaultInjection(options.faultsPath)
+		if err != nil {
+			abort(err.Error())
+		}
+
+		if options.faultSeed != 0 {
+			faultInjection.Seed = options.faultSeed
+		}
+
+		err = stub.SetFaultInjection(faultInjection)
+		if err != nil {
+			abort(fmt.Sprintf("Invalid faults: %v", err))
+		}
+	}
+
+	if options.recordPath != "" {
+		err = stub.SetRecording(options.upstream, options.recordPath)
+		if err != nil {
+			abort(fmt.Sprintf("Couldn't start recording: %v", err))
+		}
+	}
+
+	if options.replayPath != "" {
+		cassette, err := server.LoadCassette(options.replayPath)
+		if err != nil {
+			abort(err.Error())
+		}
+		stub.SetReplay(cassette)
+	}
+
+	if options.restrictedKeysPath != "" {
+		restrictedKeys, err := server.LoadRestrictedKeys(options.restrictedKeysPath)
+		if err != nil {
+			abort(err.Error())
+		}
+
+		for key, permissions := range restrictedKeys {
+			err := stub.SetRestrictedKeyPermissions(key, permissions)
+			if err != nil {
+				abort(fmt.Sprintf("Invalid restricted keys: %v", err))
+			}
+		}
+	}
+
+	if options.har || options.harOutPath != "" {
+		stub.EnableHARCapture()
+	}
+
+	if options.populateOptionalFields {
+		stub.PopulateOptionalFields()
+	}
+
 	httpMux := http.NewServeMux()
 	httpMux.HandleFunc("/", stub.HandleRequest)
 
 	// Deduplicates doubled slashes in paths. e.g. `//v1/charges` becomes
 	// `/v1/charges`.
-	handler := &server.DoubleSlashFixHandler{Mux: httpMux}
+	var handler http.Handler = &server.DoubleSlashFixHandler{Mux: httpMux}
+
+	// Answers CORS preflight requests and adds CORS headers to responses so
+	// that stripe-mock can be called from browsers on other origins.
+	if options.corsOrigins != "" {
+		handler = &server.CORSHandler{
+			AllowedHeaders: append(append([]string{}, server.DefaultCORSAllowedHeaders...), splitList(options.corsHeaders)...),
+			AllowedOrigins: splitList(options.corsOrigins),
+			ExposedHeaders: server.DefaultCORSExposedHeaders,
+			Handler:        handler,
+		}
+	}
 
 	httpListener, err := options.getHTTPListener()
 	if err != nil {
@@ -171,6 +288,31 @@ func main() {
 		}()
 	}
 
+	// Captured traffic and recorded interactions that haven't been saved yet
+	// are written out when stripe-mock is asked to shut down.
+	if options.harOutPath != "" || options.recordPath != "" {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		if options.recordPath != "" {
+			err := stub.FlushCassette()
+			if err != nil {
+				abort(err.Error())
+			}
+			fmt.Printf("Wrote recorded interactions to: %s\n", options.recordPath)
+		}
+
+		if options.harOutPath != "" {
+			err := writeHARFile(stub, options.harOutPath)
+			if err != nil {
+				abort(err.Error())
+			}
+			fmt.Printf("Wrote captured traffic to: %s\n", options.harOutPath)
+		}
+		return
+	}
+
 	// Block forever. The serve Goroutines above will abort the program if
 	// either of them fails.
 	select {}
@@ -182,7 +324,15 @@ func main() {
 
 // options is a container for the command line options passed to stripe-mock.
 type options struct {
+	corsHeaders  string
+	corsOrigins  string
+	faultSeed    int64
+	faultsPath   string
 	fixturesPath string
+	har          bool
+	harOutPath   string
+	latencyMs    float64
+	latencyPath  string
 
 	http            bool
 	httpAddr        string
@@ -196,12 +346,21 @@ type options struct {
 	httpsPort        int
 	httpsUnixSocket  string
 
-	port               int
-	showVersion        bool
-	specPath           string
-	strictVersionCheck bool
-	unixSocket         string
-	beta               bool
+	populateOptionalFields bool
+	port                   int
+	rateLimitRead          int
+	rateLimitWrite         int
+	rateLimitsPath         string
+	recordPath             string
+	replayPath             string
+	restrictedKeysPath     string
+	showVersion            bool
+	specPath               string
+	stateful               bool
+	strictVersionCheck     bool
+	unixSocket             string
+	upstream               string
+	beta                   bool
 }
 
 func (o *options) checkConflictingOptions() error {
@@ -209,6 +368,22 @@ func (o *options) checkConflictingOptions() error {
 		return fmt.Errorf("Please specify only one of -port or -unix")
 	}
 
+	if o.corsHeaders != "" && o.corsOrigins == "" {
+		return fmt.Errorf("Please specify -cors-origins when using -cors-headers")
+	}
+
+	if o.faultSeed != 0 && o.faultsPath == "" {
+		return fmt.Errorf("Please specify -faults when using -fault-seed")
+	}
+
+	if o.recordPath != "" && o.replayPath != "" {
+		return fmt.Errorf("Please specify only one of -record or -replay")
+	}
+
+	if (o.recordPath != "") != (o.upstream != "") {
+		return fmt.Errorf("Please specify -record and -upstream together")
+	}
+
 	//
 	// HTTP
 	//
@@ -387,3 +562,31 @@ func getUnixSocketListener(unixSocket, protocol string) (net.Listener, error) {
 	fmt.Printf("Listening for %s on Unix socket: %s\n", protocol, unixSocket)
 	return listener, nil
 }
+
+// splitList splits a comma-separated command line option into its values,
+// ignoring surrounding whitespace and empty values.
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// writeHARFile writes the traffic captured by a stub server to a HAR file.
+func writeHARFile(stub *server.StubServer, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Error creating HAR file: %v", err)
+	}
+	defer file.Close()
+
+	err = stub.WriteHAR(file)
+	if err != nil {
+		return fmt.Errorf("Error writing HAR file: %v", err)
+	}
+	return nil
+}
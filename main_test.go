@@ -191,6 +191,40 @@ func TestCheckConflictingOptions(t *testing.T) {
 		err := options.checkConflictingOptions()
 		assert.Equal(t, fmt.Errorf("Please specify only one of -https-addr, -https-port, or -https-unix"), err)
 	}
+
+	{
+		options := getDefaultOptions()
+		options.corsHeaders = "X-Test-Run"
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -cors-origins when using -cors-headers"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.faultSeed = 42
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -faults when using -fault-seed"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.recordPath = "cassette.json"
+		options.replayPath = "cassette.json"
+		options.upstream = "http://localhost:8080"
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify only one of -record or -replay"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.upstream = "http://localhost:8080"
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -record and -upstream together"), err)
+	}
 }
 
 // Specify :0 to ask the OS for a free port.
@@ -299,3 +333,9 @@ func TestOptionsGetNonSecureHTTPSListener(t *testing.T) {
 		listener.Close()
 	}
 }
+
+func TestSplitList(t *testing.T) {
+	assert.Equal(t, []string{"http://localhost:3000", "https://example.com"},
+		splitList("http://localhost:3000, https://example.com,"))
+	assert.Nil(t, splitList(""))
+}
@@ -14,6 +14,14 @@ import (
 // we'd like to work with a slightly wider variety of types like booleans and
 // integers.
 func CoerceParams(schema *spec.Schema, data map[string]interface{}) error {
+	// Properties may be spread across the branches of an `allOf`.
+	for _, subSchema := range schema.AllOf {
+		err := CoerceParams(subSchema, data)
+		if err != nil {
+			return err
+		}
+	}
+
 	for key, subSchema := range schema.Properties {
 		val, ok := data[key]
 		if !ok {
@@ -34,10 +42,10 @@ func CoerceParams(schema *spec.Schema, data map[string]interface{}) error {
 // coerceSubSchema coerces a sub-param value according to an arbitrary JSON sub-schema.
 // It is named with "sub-schema" because it can be array, primitive, or object, unlike the main
 // `CoerceParams` only expecting object type with properties. This is also used in coercing each
-// sub-schema of anyOf or array.
+// sub-schema of anyOf, oneOf, or array.
 func coerceSubSchema(val interface{}, subSchema *spec.Schema) (interface{}, bool, error) {
 	if len(subSchema.Properties) == 0 {
-		// Non-object schemas are anyOf, array, and primitive schemas.
+		// Non-object schemas are allOf, anyOf, oneOf, array, and primitive schemas.
 		// Implicitly treats actual object schema with empty properties as non-object.
 		return coerceNonObjectSchema(val, subSchema)
 	}
@@ -113,12 +121,43 @@ func coercePrimitiveType(val interface{}, primitiveType string) (interface{}, bo
 	return nil, false
 }
 
+// coerceBranches coerces a value according to the first of the branches of an
+// anyOf or oneOf schema that it can be coerced to. Decimal branches are tried
+// first so that a decimal string keeps its precision instead of being parsed
+// as a number by an earlier branch.
+func coerceBranches(val interface{}, branches []*spec.Schema) (interface{}, bool, error) {
+	for _, decimal := range []bool{true, false} {
+		for _, subSchema := range branches {
+			if (subSchema.Format == spec.FormatDecimal) != decimal {
+				continue
+			}
+
+			val, ok, err := coerceSubSchema(val, subSchema)
+			if ok {
+				return val, ok, err
+			}
+		}
+	}
+	return nil, false, nil
+}
+
 // coerceNonObjectSchema tries to coerce a non-object schema given generic interface{} value.
 //
 // It's similar to coercePrimitiveType above (and indeed calls into it), but
-// also handles array and anyOf schema (supporting a number of different primitive types)
+// also handles array, allOf, anyOf, and oneOf schema (supporting a number of different
+// primitive types)
 func coerceNonObjectSchema(val interface{}, schema *spec.Schema) (interface{}, bool, error) {
 	if isSchemaPrimitiveType(schema) {
+		if schema.Format == spec.FormatDecimal {
+			// Decimals are left as strings, but only valid ones are
+			// considered to be coerced.
+			valStr, ok := val.(string)
+			if !ok || !spec.IsDecimal(valStr) {
+				return nil, false, nil
+			}
+			return valStr, true, nil
+		}
+
 		if schema.Enum != nil {
 			// assuming enum value isn't numeric string. when given anyOf schema with enum and
 			// number, the numeric string won't falsely be taken as enum and miss its coercion
@@ -153,13 +192,37 @@ func coerceNonObjectSchema(val interface{}, schema *spec.Schema) (interface{}, b
 		}
 	}
 
-	if schema.AnyOf != nil {
-		for _, subSchema := range schema.AnyOf {
-			val, ok, err := coerceSubSchema(val, subSchema)
+	if schema.AllOf != nil {
+		// Each branch coerces what it knows about, so a value is coerced if
+		// any of them could coerce it.
+		allOfOk := false
+		for _, subSchema := range schema.AllOf {
+			coercedVal, ok, err := coerceSubSchema(val, subSchema)
+			if err != nil {
+				return nil, false, err
+			}
 			if ok {
-				return val, ok, err
+				val = coercedVal
+				allOfOk = true
 			}
 		}
+		if allOfOk {
+			return val, true, nil
+		}
+	}
+
+	if schema.AnyOf != nil {
+		val, ok, err := coerceBranches(val, schema.AnyOf)
+		if ok {
+			return val, ok, err
+		}
+	}
+
+	if schema.OneOf != nil {
+		val, ok, err := coerceBranches(val, schema.OneOf)
+		if ok {
+			return val, ok, err
+		}
 	}
 
 	if schema.Type == arrayType {
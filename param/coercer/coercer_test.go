@@ -189,6 +189,85 @@ func TestCoerceParams_AnyOfCoercion(t *testing.T) {
 	}
 }
 
+func TestCoerceParams_AllOfCoercion(t *testing.T) {
+	// `allOf` at the top level
+	{
+		schema := &spec.Schema{AllOf: []*spec.Schema{
+			{Properties: map[string]*spec.Schema{
+				"intkey": {Type: integerType},
+			}},
+			{Properties: map[string]*spec.Schema{
+				"boolkey": {Type: booleanType},
+			}},
+		}}
+		data := map[string]interface{}{
+			"boolkey": "true",
+			"intkey":  "123",
+		}
+
+		err := CoerceParams(schema, data)
+		assert.NoError(t, err)
+		assert.Equal(t, true, data["boolkey"])
+		assert.Equal(t, 123, data["intkey"])
+	}
+
+	// `allOf` in a property
+	{
+		schema := &spec.Schema{Properties: map[string]*spec.Schema{
+			"object_key": {
+				AllOf: []*spec.Schema{
+					{Properties: map[string]*spec.Schema{
+						"intkey": {Type: integerType},
+					}},
+					{Properties: map[string]*spec.Schema{
+						"numberkey": {Type: numberType},
+					}},
+				},
+			},
+		}}
+		data := map[string]interface{}{
+			"object_key": map[string]interface{}{
+				"intkey":    "123",
+				"numberkey": "1.5",
+			},
+		}
+
+		err := CoerceParams(schema, data)
+		assert.NoError(t, err)
+		assert.Equal(t, 123, data["object_key"].(map[string]interface{})["intkey"])
+		assert.Equal(t, 1.5, data["object_key"].(map[string]interface{})["numberkey"])
+	}
+}
+
+func TestCoerceParams_OneOfCoercion(t *testing.T) {
+	schema := &spec.Schema{Properties: map[string]*spec.Schema{
+		"object_or_int_key": {
+			OneOf: []*spec.Schema{
+				{Properties: map[string]*spec.Schema{
+					"intkey": {Type: integerType},
+				}},
+				{Type: integerType},
+			},
+		},
+	}}
+
+	data := map[string]interface{}{
+		"object_or_int_key": map[string]interface{}{
+			"intkey": "123",
+		},
+	}
+	err := CoerceParams(schema, data)
+	assert.NoError(t, err)
+	assert.Equal(t, 123, data["object_or_int_key"].(map[string]interface{})["intkey"])
+
+	data = map[string]interface{}{
+		"object_or_int_key": "123",
+	}
+	err = CoerceParams(schema, data)
+	assert.NoError(t, err)
+	assert.Equal(t, 123, data["object_or_int_key"])
+}
+
 func TestCoerceParams_ArrayCoercion(t *testing.T) {
 	// Array of primitive values
 	{
@@ -325,6 +404,36 @@ func TestCoerceParams_BooleanCoercion(t *testing.T) {
 	assert.Equal(t, true, data["boolkey"])
 }
 
+func TestCoerceParams_DecimalCoercion(t *testing.T) {
+	// Decimals stay strings, even when a number would also do
+	schema := &spec.Schema{Properties: map[string]*spec.Schema{
+		"decimal_key": {Format: spec.FormatDecimal, Type: stringType},
+		"number_or_decimal_key": {
+			AnyOf: []*spec.Schema{
+				{Type: numberType},
+				{Format: spec.FormatDecimal, Type: stringType},
+			},
+		},
+	}}
+	data := map[string]interface{}{
+		"decimal_key":           "12.3456789012345678",
+		"number_or_decimal_key": "0.10000000000000000001",
+	}
+
+	err := CoerceParams(schema, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "12.3456789012345678", data["decimal_key"])
+	assert.Equal(t, "0.10000000000000000001", data["number_or_decimal_key"])
+
+	// Values that aren't decimals are coerced by other branches
+	data = map[string]interface{}{
+		"number_or_decimal_key": "1e5",
+	}
+	err = CoerceParams(schema, data)
+	assert.NoError(t, err)
+	assert.Equal(t, 100000.0, data["number_or_decimal_key"])
+}
+
 func TestCoerceParams_IntegerCoercion(t *testing.T) {
 	schema := &spec.Schema{Properties: map[string]*spec.Schema{
 		"intkey": {Type: integerType},
@@ -1,6 +1,8 @@
 package param
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -21,6 +23,10 @@ import (
 // the query string, a form-encoded body, or a multipart form-encoded body (the
 // latter being specific to only a very small number of endpoints).
 //
+// A JSON-encoded body is decoded straight into parameters, which keep the
+// types they have in JSON. They take precedence over parameters in the query
+// string.
+//
 // Regardless of origin, parameters are assumed to follow "Rack-style"
 // conventions for encoding complex types like arrays and maps, which is how
 // the Stripe API decodes data. These complex types are what makes the param
@@ -81,6 +87,16 @@ func ParseParams(r *http.Request) (map[string]interface{}, error) {
 				values = append(values, form.Pair{key, string(keyFileBytes)})
 			}
 		}
+	} else if contentType == JSONMediaType && r.Method != "GET" {
+		params, bodyParams, err := ParseJSONParams(r)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range bodyParams {
+			params[key] = value
+		}
+		return params, nil
 	} else if r.Method != "GET" {
 		formBytes, err := ioutil.ReadAll(r.Body)
 		if err != nil {
@@ -101,6 +117,37 @@ func ParseParams(r *http.Request) (map[string]interface{}, error) {
 	return nestedtypeassembler.AssembleParams(values)
 }
 
+// ParseJSONParams extracts parameters from a request with a JSON-encoded body
+// like ParseParams does, but keeps the parameters from the query string apart
+// from those decoded from the body. Parameters from the query string are all
+// strings, like those of a form-encoded request, while those from the body
+// keep the types they have in JSON.
+func ParseJSONParams(r *http.Request) (queryParams map[string]interface{}, bodyParams map[string]interface{}, err error) {
+	values, err := parser.ParseFormString(r.URL.RawQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	queryParams, err = nestedtypeassembler.AssembleParams(values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bodyParams, err = parseJSONBody(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return queryParams, bodyParams, nil
+}
+
+//
+// Public constants
+//
+
+// JSONMediaType is the `Content-Type` for a JSON-encoded request.
+const JSONMediaType = "application/json"
+
 //
 // Private constants
 //
@@ -113,3 +160,28 @@ const maxMemory = 1 * 1024 * 1024
 
 // multipartMediaType is the `Content-Type` for a multipart request.
 const multipartMediaType = "multipart/form-data"
+
+//
+// Private functions
+//
+
+// parseJSONBody decodes a JSON-encoded request body into parameters. An empty
+// body has no parameters.
+func parseJSONBody(r *http.Request) (map[string]interface{}, error) {
+	jsonBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+
+	params := make(map[string]interface{})
+	if len(strings.TrimSpace(string(jsonBytes))) == 0 {
+		return params, nil
+	}
+
+	err = json.Unmarshal(jsonBytes, &params)
+	if err != nil {
+		return nil, fmt.Errorf("JSON body should be an object: %v", err)
+	}
+	return params, nil
+}
@@ -45,6 +45,69 @@ func TestParseParams_Form(t *testing.T) {
 	}
 }
 
+func TestParseParams_JSON(t *testing.T) {
+	// JSON values keep their types.
+	{
+		req := httptest.NewRequest(http.MethodPost, "/",
+			bytes.NewBufferString(`{"amount": 123, "capture": false, "metadata": {"key": "val"}}`))
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		params, err := ParseParams(req)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"amount":   123.0,
+			"capture":  false,
+			"metadata": map[string]interface{}{"key": "val"},
+		}, params)
+	}
+
+	// Values in the body take precedence over those in the query string.
+	{
+		req := httptest.NewRequest(http.MethodPost, "/?a=query_val&b=query_val",
+			bytes.NewBufferString(`{"b": "body_val"}`))
+		req.Header.Set("Content-Type", "application/json")
+		params, err := ParseParams(req)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"a": "query_val",
+			"b": "body_val",
+		}, params)
+	}
+
+	// An empty body has no parameters.
+	{
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(""))
+		req.Header.Set("Content-Type", "application/json")
+		params, err := ParseParams(req)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{}, params)
+	}
+
+	// Bodies that aren't objects are errors.
+	{
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`["amount"]`))
+		req.Header.Set("Content-Type", "application/json")
+		_, err := ParseParams(req)
+		assert.Error(t, err)
+	}
+}
+
+func TestParseJSONParams(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/?expand[]=customer&limit=3",
+		bytes.NewBufferString(`{"amount": 123, "limit": 5}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	queryParams, bodyParams, err := ParseJSONParams(req)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"expand": []interface{}{"customer"},
+		"limit":  "3",
+	}, queryParams)
+	assert.Equal(t, map[string]interface{}{
+		"amount": 123.0,
+		"limit":  5.0,
+	}, bodyParams)
+}
+
 func TestParseParams_MultipartForm(t *testing.T) {
 	var b bytes.Buffer
 	w := multipart.NewWriter(&b)
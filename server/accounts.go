@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+)
+
+//
+// Private constants
+//
+
+const (
+	codeAccountInvalid = "account_invalid"
+
+	accountInvalid = "The provided key does not have access to account " +
+		"'%s' (or that account does not exist). Application access may have " +
+		"been revoked."
+
+	invalidStripeAccount = "The `Stripe-Account` header must be the ID of a " +
+		"connected account like `acct_123`, but was '%s'."
+)
+
+//
+// Private values
+//
+
+// accountIDPattern matches the IDs of accounts that may be sent in
+// `Stripe-Account`.
+var accountIDPattern = regexp.MustCompile(`\Aacct_[A-Za-z0-9]+\z`)
+
+//
+// Private functions
+//
+
+// checkStripeAccountHeader makes sure that a `Stripe-Account` header looks
+// like the ID of an account. An empty header is allowed because it means the
+// request is made on behalf of the platform itself.
+func checkStripeAccountHeader(account string) *ResponseError {
+	if account == "" || accountIDPattern.MatchString(account) {
+		return nil
+	}
+
+	return createStripeError(typeInvalidRequestError,
+		fmt.Sprintf(invalidStripeAccount, account))
+}
+
+// requestStore gets the store that a request made on behalf of the given
+// connected account acts on. Each connected account has its own namespace in
+// the platform's store so that its objects are isolated from the platform's
+// and from other accounts'. Requests without an account act on the platform's
+// store directly.
+//
+// The account must have been created on the platform first, just like with
+// the real API. Returns an error suitable for sending back to the client (with
+// a status of 403) otherwise.
+func requestStore(platform *ObjectStore, account string) (*ObjectStore, *ResponseError) {
+	if account == "" {
+		return platform, nil
+	}
+
+	stored, ok := platform.Get(account)
+	if !ok || stored["object"] != "account" {
+		stripeError := createStripeError(typeInvalidRequestError,
+			fmt.Sprintf(accountInvalid, account))
+		stripeError.ErrorInfo.Code = codeAccountInvalid
+		return nil, stripeError
+	}
+
+	return platform.Namespace(account), nil
+}
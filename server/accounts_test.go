@@ -0,0 +1,194 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCheckStripeAccountHeader(t *testing.T) {
+	assert.Nil(t, checkStripeAccountHeader(""))
+	assert.Nil(t, checkStripeAccountHeader("acct_123"))
+	assert.Nil(t, checkStripeAccountHeader("acct_1Abc2Def"))
+
+	assert.NotNil(t, checkStripeAccountHeader("acct_"))
+	assert.NotNil(t, checkStripeAccountHeader("cus_123"))
+	assert.NotNil(t, checkStripeAccountHeader("acct_123 "))
+}
+
+func TestStripeAccount_Invalid(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Stripe-Account"] = "not-an-account"
+
+	resp, body := sendRequest(t, "GET", "/v1/charges", "", headers, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(body), "not-an-account")
+}
+
+func TestStripeAccount_Reflected(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Stripe-Account"] = "acct_123"
+
+	resp, _ := sendRequest(t, "GET", "/v1/charges", "", headers, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "acct_123", resp.Header.Get("Stripe-Account"))
+
+	resp, _ = sendRequest(t, "GET", "/v1/charges", "", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Stripe-Account"))
+}
+
+func TestStripeAccount_StatefulMissingAccount(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	headers := getDefaultHeaders()
+	headers["Stripe-Account"] = "acct_missing"
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/customers", "", headers)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, codeAccountInvalid, errorInfo["code"])
+	assert.Contains(t, errorInfo["message"], "acct_missing")
+
+	// Other objects don't count as accounts
+	testStore(server).Put(map[string]interface{}{
+		"id":     "acct_fake",
+		"object": "customer",
+	})
+
+	headers["Stripe-Account"] = "acct_fake"
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/customers", "", headers)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestStripeAccount_Isolation(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	accountA := createTestAccount(t, server)
+	accountB := createTestAccount(t, server)
+
+	headersA := getDefaultHeaders()
+	headersA["Stripe-Account"] = accountA
+	headersB := getDefaultHeaders()
+	headersB["Stripe-Account"] = accountB
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500", headersA)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, accountA, resp.Header.Get("Stripe-Account"))
+	id := decodeObject(t, body)["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id, "", headersA)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Neither the platform nor another connected account can see it
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id, "", headersB)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Lists only include the account's own objects
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/payment_intents", "", headersB)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, decodeObject(t, body)["data"])
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/payment_intents", "", headersA)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, decodeObject(t, body)["data"], 1)
+
+	// References are checked against the account's objects
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/customers", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	platformCustomer := decodeObject(t, body)["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&customer="+platformCustomer, headersA)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStripeAccount_DeletedAccount(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	account := createTestAccount(t, server)
+	testStore(server).Namespace(account).Put(map[string]interface{}{
+		"id":     "cus_123",
+		"object": "customer",
+	})
+
+	testStore(server).Delete(account)
+
+	headers := getDefaultHeaders()
+	headers["Stripe-Account"] = account
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/customers", "", headers)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Recreating a namespace with the same ID starts from scratch
+	_, ok := testStore(server).Namespace(account).Get("cus_123")
+	assert.False(t, ok)
+}
+
+func TestStripeAccount_Pages(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	account := createTestAccount(t, server)
+	headers := getDefaultHeaders()
+	headers["Stripe-Account"] = account
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/checkout/sessions",
+		"mode=payment&success_url=https://example.com/success"+
+			"&line_items[0][price_data][currency]=usd"+
+			"&line_items[0][price_data][product_data][name]=T-shirt"+
+			"&line_items[0][price_data][unit_amount]=1500",
+		headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	session := decodeObject(t, body)
+	id := session["id"].(string)
+
+	sessionURL, err := url.Parse(session["url"].(string))
+	assert.NoError(t, err)
+	assert.Equal(t, checkoutPath+id, sessionURL.Path)
+	assert.Equal(t, account, sessionURL.Query().Get(pageAccountParam))
+
+	// Without the account, the page can't find the session
+	resp, _ = sendRequestToServer(t, server, "GET", testPageURL(checkoutPath+id), "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "GET", testPageURL(checkoutPath+id)+"&stripe_account=acct_missing", "", nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, body = sendRequestToServer(t, server, "GET", sessionURL.RequestURI(), "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "T-shirt")
+
+	resp, _ = sendRequestToServer(t, server, "POST", sessionURL.RequestURI(),
+		"result=pay", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/checkout/sessions/"+id, "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	session = decodeObject(t, body)
+	assert.Equal(t, "complete", session["status"])
+
+	// The objects created by paying the session belong to the account too
+	_, ok := testStore(server).Namespace(account).Get(session["payment_intent"].(string))
+	assert.True(t, ok)
+	_, ok = testStore(server).Get(session["payment_intent"].(string))
+	assert.False(t, ok)
+
+	events := testStore(server).Namespace(account).List("event")
+	assert.Len(t, events, 1)
+	assert.Equal(t, account, events[0]["account"])
+}
+
+func createTestAccount(t *testing.T, server *StubServer) string {
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/accounts", "type=express",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	account := decodeObject(t, body)
+	assert.Equal(t, "account", account["object"])
+	return account["id"].(string)
+}
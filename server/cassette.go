@@ -0,0 +1,415 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// Cassette holds request and response pairs recorded from an upstream server
+// so that they can be replayed later.
+type Cassette struct {
+	Interactions []*CassetteInteraction `json:"interactions"`
+}
+
+// CassetteInteraction is a single recorded request and its response.
+type CassetteInteraction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+// CassetteRequest is a recorded request. Requests are matched on their
+// method, path, and parameters, the last of which are compared after they've
+// been validated and coerced so that their order and encoding don't matter.
+type CassetteRequest struct {
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+	Path   string                 `json:"path"`
+}
+
+// CassetteResponse is a recorded response.
+type CassetteResponse struct {
+	Body        string `json:"body"`
+	ContentType string `json:"content_type"`
+	RequestID   string `json:"request_id"`
+	Status      int    `json:"status"`
+}
+
+//
+// Public functions
+//
+
+// LoadCassette loads a cassette from a JSON file.
+func LoadCassette(path string) (*Cassette, error) {
+	if !isJSONFile(path) {
+		return nil, fmt.Errorf("Cassette should be a JSON file")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading cassette: %v", err)
+	}
+
+	var cassette Cassette
+	err = json.Unmarshal(data, &cassette)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding cassette: %v", err)
+	}
+
+	return &cassette, nil
+}
+
+// SetRecording puts stripe-mock in record mode: requests that pass validation
+// are forwarded to an upstream server (like `http://localhost:8080`), and the
+// upstream's responses are sent back and recorded to a cassette file. New
+// interactions are added to the file if it already exists.
+func (s *StubServer) SetRecording(upstream string, cassettePath string) error {
+	upstreamURL, err := url.Parse(upstream)
+	if err != nil || (upstreamURL.Scheme != "http" && upstreamURL.Scheme != "https") || upstreamURL.Host == "" {
+		return fmt.Errorf("upstream should be a URL like `http://localhost:8080`, but was '%s'", upstream)
+	}
+
+	if !isJSONFile(cassettePath) {
+		return fmt.Errorf("Cassette should be a JSON file")
+	}
+
+	cassette := &Cassette{}
+	if _, err := ioutil.ReadFile(cassettePath); err == nil {
+		cassette, err = LoadCassette(cassettePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.cassette.mu.Lock()
+	defer s.cassette.mu.Unlock()
+
+	s.cassette.stopFlushing()
+	s.cassette.cassette = cassette
+	s.cassette.dirty = false
+	s.cassette.path = cassettePath
+	s.cassette.replays = nil
+	s.cassette.upstream = strings.TrimSuffix(upstreamURL.String(), "/")
+
+	stop := make(chan struct{})
+	s.cassette.stop = stop
+	go s.flushCassettePeriodically(stop)
+
+	return nil
+}
+
+// FlushCassette saves the interactions recorded since the cassette was last
+// saved to its file. They're saved periodically while recording, so this
+// only needs to be called before shutting down. It does nothing when not
+// recording.
+func (s *StubServer) FlushCassette() error {
+	s.cassette.flushMu.Lock()
+	defer s.cassette.flushMu.Unlock()
+
+	s.cassette.mu.Lock()
+	if s.cassette.path == "" || !s.cassette.dirty {
+		s.cassette.mu.Unlock()
+		return nil
+	}
+	path := s.cassette.path
+	data, err := json.MarshalIndent(s.cassette.cassette, "", "  ")
+	s.cassette.dirty = false
+	s.cassette.mu.Unlock()
+
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0o644)
+	}
+	if err != nil {
+		// Try again next time.
+		s.cassette.mu.Lock()
+		s.cassette.dirty = true
+		s.cassette.mu.Unlock()
+		return fmt.Errorf("error saving cassette: %v", err)
+	}
+	return nil
+}
+
+// SetReplay puts stripe-mock in replay mode: requests that pass validation and
+// match an interaction in a cassette are answered with its recorded response.
+// Requests that don't match are answered with generated responses as usual.
+func (s *StubServer) SetReplay(cassette *Cassette) {
+	s.cassette.mu.Lock()
+	defer s.cassette.mu.Unlock()
+
+	s.cassette.stopFlushing()
+	s.cassette.cassette = cassette
+	s.cassette.dirty = false
+	s.cassette.path = ""
+	s.cassette.replays = make(map[*CassetteInteraction]int)
+	s.cassette.upstream = ""
+}
+
+//
+// Private constants
+//
+
+// cassetteFlushInterval is how often interactions recorded to a cassette are
+// saved to its file.
+const cassetteFlushInterval = 1 * time.Second
+
+// upstreamTimeout is how long to wait for an upstream server while recording.
+const upstreamTimeout = 80 * time.Second
+
+const upstreamUnavailable = "Couldn't get a response from the upstream " +
+	"server being recorded: %v"
+
+//
+// Private values
+//
+
+// forwardedHeaders are the request headers that are forwarded to an upstream
+// server while recording.
+var forwardedHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	"Idempotency-Key",
+	"Stripe-Account",
+	"Stripe-Version",
+}
+
+//
+// Private types
+//
+
+// cassettePlayer records interactions to a cassette or replays them from it.
+// It's only active in one of the two modes at a time.
+type cassettePlayer struct {
+	cassette *Cassette
+
+	// dirty is set when interactions have been recorded since the cassette
+	// was last saved to its file.
+	dirty bool
+
+	// flushMu is held while saving the cassette to its file so that saves
+	// don't overlap. It's separate from mu so that requests can keep being
+	// recorded in the meantime.
+	flushMu sync.Mutex
+
+	// mu guards everything else. It's never held while waiting on the
+	// upstream server.
+	mu sync.Mutex
+
+	// path is the file that recorded interactions are saved to. It's only set
+	// in record mode.
+	path string
+
+	// stop is closed to stop periodically saving the cassette. It's only set
+	// in record mode.
+	stop chan struct{}
+
+	// replays counts how many times each interaction has been replayed so
+	// that matching interactions are replayed in the order they were
+	// recorded. It's only set in replay mode.
+	replays map[*CassetteInteraction]int
+
+	// upstream is the base URL that requests are forwarded to. It's only set
+	// in record mode.
+	upstream string
+}
+
+//
+// Private functions
+//
+
+// active checks whether stripe-mock is recording or replaying.
+func (p *cassettePlayer) active() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cassette != nil
+}
+
+// match finds the interaction to replay for a request. Interactions that
+// match are replayed in the order they were recorded, after which the last
+// one is repeated.
+func (p *cassettePlayer) match(request *CassetteRequest) *CassetteInteraction {
+	var last *CassetteInteraction
+	for _, interaction := range p.cassette.Interactions {
+		if !interaction.Request.matches(request) {
+			continue
+		}
+
+		last = interaction
+		if p.replays[interaction] == 0 {
+			break
+		}
+	}
+
+	if last != nil {
+		p.replays[last]++
+	}
+	return last
+}
+
+// stopFlushing stops periodically saving the cassette, if it was being. The
+// player must be locked.
+func (p *cassettePlayer) stopFlushing() {
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// flushCassettePeriodically saves recorded interactions to the cassette's
+// file every cassetteFlushInterval until stop is closed.
+func (s *StubServer) flushCassettePeriodically(stop chan struct{}) {
+	ticker := time.NewTicker(cassetteFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+
+		case <-ticker.C:
+			err := s.FlushCassette()
+			if err != nil {
+				fmt.Printf("Couldn't save cassette: %v\n", err)
+			}
+		}
+	}
+}
+
+// record forwards a request to an upstream server (like
+// `http://localhost:8080`) and returns the interaction to add to the
+// cassette. The player shouldn't be locked, since the upstream may be slow.
+func record(upstream string, r *http.Request, body []byte, request *CassetteRequest) (*CassetteInteraction, error) {
+	upstreamURL := upstream + r.URL.Path
+	if r.URL.RawQuery != "" {
+		upstreamURL += "?" + r.URL.RawQuery
+	}
+
+	upstreamRequest, err := http.NewRequest(r.Method, upstreamURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range forwardedHeaders {
+		if value := r.Header.Get(header); value != "" {
+			upstreamRequest.Header.Set(header, value)
+		}
+	}
+
+	client := &http.Client{Timeout: upstreamTimeout}
+	upstreamResponse, err := client.Do(upstreamRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer upstreamResponse.Body.Close()
+
+	upstreamBody, err := ioutil.ReadAll(upstreamResponse.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := &CassetteInteraction{
+		Request: *request,
+		Response: CassetteResponse{
+			Body:        string(upstreamBody),
+			ContentType: upstreamResponse.Header.Get("Content-Type"),
+			RequestID:   upstreamResponse.Header.Get("Request-Id"),
+			Status:      upstreamResponse.StatusCode,
+		},
+	}
+
+	return interaction, nil
+}
+
+// serveFromCassette answers a request that has passed validation from the
+// cassette: by forwarding it upstream and recording the response in record
+// mode, or with a recorded response in replay mode. Returns false if the
+// request wasn't answered and a response should be generated instead.
+func (s *StubServer) serveFromCassette(w http.ResponseWriter, r *http.Request, start time.Time,
+	body []byte, requestData map[string]interface{}) bool {
+
+	if requestData == nil {
+		requestData = make(map[string]interface{})
+	}
+	request := &CassetteRequest{
+		Method: r.Method,
+		Params: requestData,
+		Path:   r.URL.Path,
+	}
+
+	s.cassette.mu.Lock()
+	upstream := s.cassette.upstream
+	var interaction *CassetteInteraction
+	if upstream == "" {
+		interaction = s.cassette.match(request)
+	}
+	s.cassette.mu.Unlock()
+
+	if upstream != "" {
+		var err error
+		interaction, err = record(upstream, r, body, request)
+		if err != nil {
+			fmt.Printf("Couldn't record from upstream: %v\n", err)
+			writeResponse(w, r, start, http.StatusBadGateway,
+				createStripeError(typeAPIError, fmt.Sprintf(upstreamUnavailable, err)))
+			return true
+		}
+
+		// The interaction is saved to the cassette's file by FlushCassette,
+		// unless recording was stopped in the meantime.
+		s.cassette.mu.Lock()
+		if s.cassette.upstream == upstream {
+			s.cassette.cassette.Interactions = append(s.cassette.cassette.Interactions, interaction)
+			s.cassette.dirty = true
+		}
+		s.cassette.mu.Unlock()
+	}
+
+	if interaction == nil {
+		return false
+	}
+	response := &interaction.Response
+
+	if response.ContentType != "" {
+		w.Header().Set("Content-Type", response.ContentType)
+	}
+	if response.RequestID != "" {
+		w.Header().Set("Request-Id", response.RequestID)
+	}
+	writeResponse(w, r, start, response.Status, response.Body)
+	return true
+}
+
+// matches checks whether a recorded request matches another request.
+func (r *CassetteRequest) matches(other *CassetteRequest) bool {
+	if r.Method != other.Method || r.Path != other.Path {
+		return false
+	}
+
+	// Maps are encoded with sorted keys, and recorded numbers are encoded the
+	// same way whether they were decoded as integers or floats.
+	params, err := json.Marshal(r.Params)
+	if err != nil {
+		return false
+	}
+	otherParams, err := json.Marshal(other.Params)
+	if err != nil {
+		return false
+	}
+
+	if r.Params == nil {
+		params = []byte("{}")
+	}
+	if other.Params == nil {
+		otherParams = []byte("{}")
+	}
+
+	return bytes.Equal(params, otherParams)
+}
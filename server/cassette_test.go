@@ -0,0 +1,161 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestSetRecording(t *testing.T) {
+	server := getStubServer(t, nil)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	assert.NoError(t, server.SetRecording("http://localhost:8080", path))
+	assert.Error(t, server.SetRecording("localhost:8080", path))
+	assert.Error(t, server.SetRecording("http://localhost:8080",
+		filepath.Join(t.TempDir(), "cassette.yaml")))
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	var upstreamRequests int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests++
+
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "amount=100", string(body))
+		assert.Equal(t, "Bearer sk_test_123", r.Header.Get("Authorization"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Request-Id", "req_upstream")
+		w.WriteHeader(http.StatusPaymentRequired)
+		w.Write([]byte(`{"error": {"type": "card_error"}}`))
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	// Record
+	{
+		server := getStubServer(t, nil)
+		assert.NoError(t, server.SetRecording(upstream.URL, path))
+
+		resp, body := sendRequestToServer(t, server, "POST", "/v1/charges", "amount=100", getDefaultHeaders())
+		assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+		assert.Equal(t, "req_upstream", resp.Header.Get("Request-Id"))
+		assert.Equal(t, "card_error", decodeObject(t, body)["error"].(map[string]interface{})["type"])
+		assert.Equal(t, 1, upstreamRequests)
+
+		// Requests that fail validation aren't forwarded
+		resp, _ = sendRequestToServer(t, server, "POST", "/v1/charges", "", getDefaultHeaders())
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, 1, upstreamRequests)
+
+		assert.NoError(t, server.FlushCassette())
+	}
+
+	cassette, err := LoadCassette(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(cassette.Interactions))
+	assert.Equal(t, "/v1/charges", cassette.Interactions[0].Request.Path)
+
+	// Replay
+	{
+		server := getStubServer(t, nil)
+		server.SetReplay(cassette)
+
+		// Parameters match after they've been coerced
+		resp, body := sendRequestToServer(t, server, "POST", "/v1/charges", "amount=0100", getDefaultHeaders())
+		assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+		assert.Equal(t, "req_upstream", resp.Header.Get("Request-Id"))
+		assert.Equal(t, "card_error", decodeObject(t, body)["error"].(map[string]interface{})["type"])
+
+		// Requests that weren't recorded get generated responses
+		resp, _ = sendRequestToServer(t, server, "POST", "/v1/charges", "amount=200", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 1, upstreamRequests)
+	}
+}
+
+// Requests being recorded don't wait on each other's upstream requests.
+func TestRecord_Concurrent(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "amount=1" {
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object": "charge"}`))
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	server := getStubServer(t, nil)
+	assert.NoError(t, server.SetRecording(upstream.URL, path))
+
+	// Stop saving the cassette before its directory is removed.
+	t.Cleanup(func() {
+		server.cassette.mu.Lock()
+		server.cassette.stopFlushing()
+		server.cassette.mu.Unlock()
+
+		server.cassette.flushMu.Lock()
+		server.cassette.flushMu.Unlock()
+	})
+
+	go sendRequestToServer(t, server, "POST", "/v1/charges", "amount=1", getDefaultHeaders())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, _ := sendRequestToServer(t, server, "POST", "/v1/charges", "amount=2", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request was blocked by another request's upstream request")
+	}
+
+	// The recorded interaction is saved once flushed
+	assert.NoError(t, server.FlushCassette())
+	cassette, err := LoadCassette(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(cassette.Interactions))
+	assert.Equal(t, 2.0, cassette.Interactions[0].Request.Params["amount"])
+}
+
+func TestCassettePlayerMatch(t *testing.T) {
+	first := &CassetteInteraction{
+		Request:  CassetteRequest{Method: "GET", Path: "/v1/charges/ch_123"},
+		Response: CassetteResponse{Status: http.StatusOK},
+	}
+	second := &CassetteInteraction{
+		Request:  CassetteRequest{Method: "GET", Path: "/v1/charges/ch_123"},
+		Response: CassetteResponse{Status: http.StatusNotFound},
+	}
+	player := &cassettePlayer{
+		cassette: &Cassette{Interactions: []*CassetteInteraction{first, second}},
+		replays:  make(map[*CassetteInteraction]int),
+	}
+
+	request := &CassetteRequest{Method: "GET", Path: "/v1/charges/ch_123",
+		Params: map[string]interface{}{}}
+
+	// Matching interactions are replayed in order and the last one repeats
+	assert.Equal(t, first, player.match(request))
+	assert.Equal(t, second, player.match(request))
+	assert.Equal(t, second, player.match(request))
+
+	assert.Nil(t, player.match(&CassetteRequest{Method: "GET", Path: "/v1/charges/ch_456"}))
+	assert.Nil(t, player.match(&CassetteRequest{Method: "GET", Path: "/v1/charges/ch_123",
+		Params: map[string]interface{}{"expand": []interface{}{"customer"}}}))
+}
@@ -0,0 +1,453 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Private constants
+//
+
+// checkoutPath is the path of the simulated hosted Checkout page. It's
+// followed by the ID of the Checkout Session being paid.
+const checkoutPath = pagePathPrefix + "checkout/"
+
+const (
+	checkoutResultCancel = "cancel"
+	checkoutResultPay    = "pay"
+)
+
+// Statuses of a Checkout Session.
+const (
+	checkoutStatusComplete = "complete"
+	checkoutStatusOpen     = "open"
+)
+
+// checkoutSessionIDTemplate is the template variable that can be included in
+// a Checkout Session's `success_url` to have it replaced with the session's
+// ID on redirect.
+const checkoutSessionIDTemplate = "{CHECKOUT_SESSION_ID}"
+
+// checkoutPaymentMethod is the test payment method that's attached to objects
+// created when a Checkout Session is paid.
+const checkoutPaymentMethod = "pm_card_visa"
+
+//
+// Private values
+//
+
+var checkoutPageTemplate = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>stripe-mock Checkout</title>
+</head>
+<body>
+<h1>Checkout</h1>
+<p>This is a simulated Checkout page served by stripe-mock for
+<code id="session">{{.ID}}</code>.</p>
+<table id="line_items">
+<tr><th>Item</th><th>Quantity</th><th>Amount</th></tr>
+{{range .LineItems}}<tr><td>{{.Description}}</td><td>{{.Quantity}}</td><td>{{.Amount}}</td></tr>
+{{end}}</table>
+<p>Total: <span id="total">{{.Total}}</span></p>
+<form method="post">
+<button type="submit" name="result" value="pay" id="pay">Pay</button>
+<button type="submit" name="result" value="cancel" id="cancel">Cancel</button>
+</form>
+</body>
+</html>
+`))
+
+// zeroDecimalCurrencies are currencies whose amounts aren't expressed in
+// hundredths of a unit. They're used to display amounts on pages.
+var zeroDecimalCurrencies = map[string]bool{
+	"bif": true,
+	"clp": true,
+	"djf": true,
+	"gnf": true,
+	"jpy": true,
+	"kmf": true,
+	"krw": true,
+	"mga": true,
+	"pyg": true,
+	"rwf": true,
+	"ugx": true,
+	"vnd": true,
+	"vuv": true,
+	"xaf": true,
+	"xof": true,
+	"xpf": true,
+}
+
+//
+// Private types
+//
+
+// checkoutPageLineItem is a line item as it's displayed on the Checkout page.
+type checkoutPageLineItem struct {
+	Amount      string
+	Description interface{}
+	Quantity    interface{}
+}
+
+//
+// Private functions
+//
+
+// transitionCheckoutSession is the state machine for Checkout Sessions. On
+// creation, the session's line items are recorded and its `url` is pointed at
+// the Checkout page served by stripe-mock (see handleCheckoutPage), which is
+// where the session moves from `open` to `complete`.
+func transitionCheckoutSession(s *StubServer, t *transition) *ResponseError {
+	if t.action != actionCreate {
+		return nil
+	}
+
+	id := t.object["id"].(string)
+
+	lineItems, total, currency := buildCheckoutLineItems(t.store, t.requestData)
+	t.store.PutList(id, "line_items", lineItems)
+
+	if requestCurrency, ok := t.requestData["currency"].(string); ok {
+		currency = requestCurrency
+	}
+	if currency != "" {
+		t.object["currency"] = currency
+	}
+
+	t.object["amount_subtotal"] = total
+	t.object["amount_total"] = total
+	t.object["payment_intent"] = nil
+	t.object["payment_status"] = "unpaid"
+	t.object["setup_intent"] = nil
+	t.object["status"] = checkoutStatusOpen
+	t.object["subscription"] = nil
+	t.object["url"] = pageURL(t, checkoutPath+id)
+
+	return nil
+}
+
+// buildCheckoutLineItems builds the line items of a Checkout Session from the
+// `line_items` sent to create it. Prices are looked up in the store if they
+// were referenced by ID, and built inline if sent as `price_data`.
+//
+// Returns the line items along with their total and currency.
+func buildCheckoutLineItems(store *ObjectStore, requestData map[string]interface{}) ([]interface{}, int64, string) {
+	lineItemsParams, _ := requestData["line_items"].([]interface{})
+
+	var currency string
+	var total int64
+	lineItems := make([]interface{}, 0, len(lineItemsParams))
+
+	for _, lineItemParams := range lineItemsParams {
+		params, ok := lineItemParams.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		quantity := int64(1)
+		if requestQuantity, ok := toInt64(params["quantity"]); ok {
+			quantity = requestQuantity
+		}
+
+		price := buildCheckoutPrice(store, params)
+		unitAmount, _ := toInt64(price["unit_amount"])
+		priceCurrency, _ := price["currency"].(string)
+		amount := unitAmount * quantity
+
+		if currency == "" {
+			currency = priceCurrency
+		}
+		total += amount
+
+		var description interface{} = price["id"]
+		if productID, ok := price["product"].(string); ok {
+			if product, ok := store.Get(productID); ok && product["name"] != nil {
+				description = product["name"]
+			}
+		}
+		if productData, ok := price["product_data"].(map[string]interface{}); ok {
+			description = productData["name"]
+			delete(price, "product_data")
+		}
+
+		lineItems = append(lineItems, map[string]interface{}{
+			"amount_discount": 0,
+			"amount_subtotal": amount,
+			"amount_tax":      0,
+			"amount_total":    amount,
+			"currency":        priceCurrency,
+			"description":     description,
+			"id":              randomID("li"),
+			"object":          "item",
+			"price":           price,
+			"quantity":        quantity,
+		})
+	}
+
+	return lineItems, total, currency
+}
+
+// buildCheckoutPrice builds the price of a single Checkout line item, either
+// from a stored price or from `price_data`. Product data from `price_data` is
+// left under `product_data` for the caller to pick a description from.
+func buildCheckoutPrice(store *ObjectStore, params map[string]interface{}) map[string]interface{} {
+	if priceID, ok := params["price"].(string); ok {
+		if price, ok := store.Get(priceID); ok {
+			return price
+		}
+		return map[string]interface{}{"id": priceID, "object": "price"}
+	}
+
+	priceData, _ := params["price_data"].(map[string]interface{})
+	return map[string]interface{}{
+		"currency":     priceData["currency"],
+		"id":           randomID("price"),
+		"object":       "price",
+		"product":      priceData["product"],
+		"product_data": priceData["product_data"],
+		"unit_amount":  priceData["unit_amount"],
+	}
+}
+
+// completeCheckoutSession pays an open Checkout Session, marks it `complete`,
+// and creates a `checkout.session.completed` event. Everything is saved to
+// the given store, which belongs to the connected account that owns the
+// session, if any.
+//
+// The session is checked and completed atomically, and the objects created
+// for it are only saved once it has been, so that paying a session several
+// times at once only pays it once.
+//
+// Returns the completed session. The second return value is false if there
+// was no such session, and an error is returned if it's no longer open.
+func (s *StubServer) completeCheckoutSession(store *ObjectStore, account, id string) (map[string]interface{}, bool, error) {
+	var created []map[string]interface{}
+	session, ok, err := store.Update(id, func(session map[string]interface{}) (map[string]interface{}, error) {
+		if session["status"] != checkoutStatusOpen {
+			return nil, fmt.Errorf("%s is no longer open (status: %v).", id, session["status"])
+		}
+
+		created = s.payCheckoutSession(session)
+		session["status"] = checkoutStatusComplete
+		session["url"] = nil
+		return session, nil
+	})
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	for _, object := range created {
+		store.Put(object)
+	}
+	s.createEvent(store, account, "checkout.session.completed", session)
+
+	return session, true, nil
+}
+
+// payCheckoutSession generates the PaymentIntent, Subscription, or SetupIntent
+// (and customer, if necessary) that the real API would create to pay a
+// Checkout Session, depending on its mode, and links them to the session.
+// They're returned rather than saved so that nothing is saved until the
+// session has been completed.
+func (s *StubServer) payCheckoutSession(session map[string]interface{}) []map[string]interface{} {
+	var created []map[string]interface{}
+	customerID, _ := session["customer"].(string)
+
+	switch session["mode"] {
+	case "setup":
+		if setupIntent, ok := s.generateObject("setup_intent"); ok {
+			setupIntent["customer"] = nilIfEmpty(customerID)
+			setupIntent["next_action"] = nil
+			setupIntent["payment_method"] = checkoutPaymentMethod
+			setupIntent["status"] = intentStatusSucceeded
+			created = append(created, setupIntent)
+			session["setup_intent"] = setupIntent["id"]
+		}
+		session["payment_status"] = "no_payment_required"
+
+	case "subscription":
+		if customerID == "" {
+			if customer, ok := s.generateObject("customer"); ok {
+				customer["email"] = session["customer_email"]
+				created = append(created, customer)
+				customerID, _ = customer["id"].(string)
+				session["customer"] = nilIfEmpty(customerID)
+			}
+		}
+		if subscription, ok := s.generateObject("subscription"); ok {
+			subscription["currency"] = session["currency"]
+			subscription["customer"] = nilIfEmpty(customerID)
+			subscription["status"] = "active"
+			created = append(created, subscription)
+			session["subscription"] = subscription["id"]
+		}
+		session["payment_status"] = "paid"
+
+	default:
+		if paymentIntent, ok := s.generateObject("payment_intent"); ok {
+			paymentIntent["amount"] = session["amount_total"]
+			paymentIntent["amount_received"] = session["amount_total"]
+			paymentIntent["currency"] = session["currency"]
+			paymentIntent["customer"] = nilIfEmpty(customerID)
+			paymentIntent["next_action"] = nil
+			paymentIntent["payment_method"] = checkoutPaymentMethod
+			paymentIntent["status"] = intentStatusSucceeded
+			created = append(created, paymentIntent)
+			session["payment_intent"] = paymentIntent["id"]
+		}
+		session["payment_status"] = "paid"
+	}
+
+	return created
+}
+
+// formatAmount formats an amount in a currency's smallest unit for display,
+// like `10.99 USD`.
+func formatAmount(amount interface{}, currency interface{}) string {
+	value, _ := toInt64(amount)
+	currencyCode, _ := currency.(string)
+
+	if zeroDecimalCurrencies[strings.ToLower(currencyCode)] {
+		return fmt.Sprintf("%d %s", value, strings.ToUpper(currencyCode))
+	}
+
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, value/100, value%100,
+		strings.ToUpper(currencyCode))
+}
+
+// handleCheckoutPage serves the simulated Checkout page for an open Checkout
+// Session. A `GET` shows the session's line items and a `POST` (from one of
+// its buttons) either pays the session and redirects to its `success_url`,
+// or redirects to its `cancel_url` leaving the session open.
+func (s *StubServer) handleCheckoutPage(w http.ResponseWriter, r *http.Request, start time.Time, store *ObjectStore, id string) {
+	session, ok := store.Get(id)
+	if !ok || session["object"] != "checkout.session" {
+		writePage(w, r, start, http.StatusNotFound, messagePageTemplate,
+			fmt.Sprintf("No such checkout.session: '%s'", id))
+		return
+	}
+
+	if session["status"] != checkoutStatusOpen {
+		writePage(w, r, start, http.StatusBadRequest, messagePageTemplate,
+			fmt.Sprintf("%s is no longer open (status: %v).", id, session["status"]))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		lineItems, _ := store.GetList(id, "line_items")
+		pageLineItems := make([]checkoutPageLineItem, 0, len(lineItems))
+		for _, lineItem := range lineItems {
+			item := lineItem.(map[string]interface{})
+			pageLineItems = append(pageLineItems, checkoutPageLineItem{
+				Amount:      formatAmount(item["amount_total"], item["currency"]),
+				Description: item["description"],
+				Quantity:    item["quantity"],
+			})
+		}
+
+		writePage(w, r, start, http.StatusOK, checkoutPageTemplate, map[string]interface{}{
+			"ID":        id,
+			"LineItems": pageLineItems,
+			"Total":     formatAmount(session["amount_total"], session["currency"]),
+		})
+		return
+
+	case http.MethodPost:
+		// Handled below
+
+	default:
+		writePage(w, r, start, http.StatusMethodNotAllowed, messagePageTemplate,
+			http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writePage(w, r, start, http.StatusBadRequest, messagePageTemplate,
+			fmt.Sprintf("Couldn't parse form: %v", err))
+		return
+	}
+
+	var redirectURL string
+	switch r.PostForm.Get("result") {
+	case checkoutResultCancel:
+		redirectURL, _ = session["cancel_url"].(string)
+
+	case checkoutResultPay:
+		session, ok, err = s.completeCheckoutSession(store, r.URL.Query().Get(pageAccountParam), id)
+		if err != nil {
+			writePage(w, r, start, http.StatusBadRequest, messagePageTemplate, err.Error())
+			return
+		}
+		if !ok {
+			writePage(w, r, start, http.StatusNotFound, messagePageTemplate,
+				fmt.Sprintf("No such checkout.session: '%s'", id))
+			return
+		}
+
+		redirectURL, _ = session["success_url"].(string)
+		redirectURL = strings.Replace(redirectURL, checkoutSessionIDTemplate, id, -1)
+
+	default:
+		writePage(w, r, start, http.StatusBadRequest, messagePageTemplate,
+			fmt.Sprintf("Unrecognized result: '%s'", r.PostForm.Get("result")))
+		return
+	}
+
+	if redirectURL == "" {
+		writePage(w, r, start, http.StatusOK, messagePageTemplate,
+			fmt.Sprintf("Checkout Session %s is %v. You can close this window.",
+				id, session["status"]))
+		return
+	}
+
+	_, err = url.Parse(redirectURL)
+	if err != nil {
+		writePage(w, r, start, http.StatusBadRequest, messagePageTemplate,
+			fmt.Sprintf("Invalid redirect URL: %v", err))
+		return
+	}
+
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	fmt.Printf("Response: elapsed=%v status=%v\n", time.Now().Sub(start), http.StatusSeeOther)
+}
+
+// nilIfEmpty returns nil for an empty string so that unset IDs are encoded as
+// `null` like they are in the real API.
+func nilIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// toInt64 converts an integer decoded from a request (an int, or a string if
+// it wasn't coerced) or from JSON fixtures (a float64) to an int64.
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCheckoutSession_Create(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/checkout/sessions",
+		"mode=payment&success_url=https://example.com/success&cancel_url=https://example.com/cancel"+
+			"&line_items[0][price_data][currency]=usd"+
+			"&line_items[0][price_data][product_data][name]=T-shirt"+
+			"&line_items[0][price_data][unit_amount]=1500"+
+			"&line_items[0][quantity]=2",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	session := decodeObject(t, body)
+	id := session["id"].(string)
+
+	assert.Equal(t, "open", session["status"])
+	assert.Equal(t, "unpaid", session["payment_status"])
+	assert.Equal(t, 3000.0, session["amount_total"])
+	assert.Equal(t, "https://stripe.com"+testPageURL(checkoutPath+id), session["url"])
+
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/checkout/sessions/"+id+"/line_items", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	lineItems := decodeObject(t, body)
+	data := lineItems["data"].([]interface{})
+	assert.Equal(t, 1, len(data))
+
+	lineItem := data[0].(map[string]interface{})
+	assert.Equal(t, "T-shirt", lineItem["description"])
+	assert.Equal(t, 2.0, lineItem["quantity"])
+	assert.Equal(t, 3000.0, lineItem["amount_total"])
+}
+
+func TestCheckoutPage_Pay(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/checkout/sessions",
+		"mode=payment&success_url=https://example.com/success?session_id={CHECKOUT_SESSION_ID}"+
+			"&line_items[0][price_data][currency]=usd"+
+			"&line_items[0][price_data][product_data][name]=T-shirt"+
+			"&line_items[0][price_data][unit_amount]=1500",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	session := decodeObject(t, body)
+	id := session["id"].(string)
+
+	resp, body = sendRequestToServer(t, server, "GET", testPageURL(checkoutPath+id), "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "T-shirt")
+	assert.Contains(t, string(body), "15.00 USD")
+	assert.Contains(t, string(body), `value="pay"`)
+	assert.Contains(t, string(body), `value="cancel"`)
+
+	resp, _ = sendRequestToServer(t, server, "POST", testPageURL(checkoutPath+id),
+		"result=pay", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", location.Host)
+	assert.Equal(t, id, location.Query().Get("session_id"))
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/checkout/sessions/"+id,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	session = decodeObject(t, body)
+	assert.Equal(t, "complete", session["status"])
+	assert.Equal(t, "paid", session["payment_status"])
+	assert.Nil(t, session["url"])
+
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/payment_intents/"+session["payment_intent"].(string), "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent := decodeObject(t, body)
+	assert.Equal(t, "succeeded", intent["status"])
+	assert.Equal(t, 1500.0, intent["amount"])
+
+	// The session can't be paid twice
+	resp, _ = sendRequestToServer(t, server, "GET", testPageURL(checkoutPath+id), "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// Paying a session several times at once only pays it once, creating a
+// single PaymentIntent and event.
+func TestCheckoutPage_PayConcurrent(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/checkout/sessions",
+		"mode=payment&success_url=https://example.com/success", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	statuses := make([]int, 10)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, _ := sendRequestToServer(t, server, "POST", testPageURL(checkoutPath+id),
+				"result=pay", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+			statuses[i] = resp.StatusCode
+		}(i)
+	}
+	wg.Wait()
+
+	redirected := 0
+	for _, status := range statuses {
+		if status == http.StatusSeeOther {
+			redirected++
+		} else {
+			assert.Equal(t, http.StatusBadRequest, status)
+		}
+	}
+	assert.Equal(t, 1, redirected)
+
+	assert.Equal(t, 1, len(testStore(server).List("payment_intent")))
+	assert.Equal(t, 1, len(testStore(server).List("event")))
+}
+
+func TestCheckoutPage_PaySubscription(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/checkout/sessions",
+		"mode=subscription&customer_email=jenny@example.com&success_url=https://example.com/success",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "POST", testPageURL(checkoutPath+id),
+		"result=pay", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	session, ok := testStore(server).Get(id)
+	assert.True(t, ok)
+	assert.NotNil(t, session["customer"])
+
+	subscription, ok := testStore(server).Get(session["subscription"].(string))
+	assert.True(t, ok)
+	assert.Equal(t, "active", subscription["status"])
+	assert.Equal(t, session["customer"], subscription["customer"])
+}
+
+func TestCheckoutPage_Cancel(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/checkout/sessions",
+		"mode=payment&cancel_url=https://example.com/cancel", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "POST", testPageURL(checkoutPath+id),
+		"result=cancel", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "https://example.com/cancel", resp.Header.Get("Location"))
+
+	session, ok := testStore(server).Get(id)
+	assert.True(t, ok)
+	assert.Equal(t, "open", session["status"])
+}
+
+func TestCheckoutPage_NotFound(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, _ := sendRequestToServer(t, server, "GET", checkoutPath+"cs_test_missing", "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestFormatAmount(t *testing.T) {
+	assert.Equal(t, "10.99 USD", formatAmount(1099, "usd"))
+	assert.Equal(t, "0.05 EUR", formatAmount(5.0, "eur"))
+	assert.Equal(t, "500 JPY", formatAmount(500, "jpy"))
+}
+
+// A nested list without a stored parent keeps its stateless behavior.
+func TestCheckoutSession_LineItemsWithoutSession(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "GET",
+		"/v1/checkout/sessions/cs_test_missing/line_items", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "list", decodeObject(t, body)["object"])
+}
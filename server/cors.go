@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//
+// Public values
+//
+
+// DefaultCORSAllowedHeaders are the request headers that browsers are allowed
+// to send to stripe-mock from another origin by default. They're the headers
+// that Stripe's API and its client libraries use.
+var DefaultCORSAllowedHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	"Idempotency-Key",
+	"Stripe-Account",
+	"Stripe-Mock-Delay",
+	"Stripe-Version",
+}
+
+// DefaultCORSExposedHeaders are the response headers that scripts on another
+// origin are allowed to read by default.
+var DefaultCORSExposedHeaders = []string{
+	"Idempotency-Key",
+	"Request-Id",
+	"Stripe-Account",
+	"Stripe-Mock-Version",
+	"Stripe-Should-Retry",
+}
+
+//
+// Public types
+//
+
+// CORSHandler is a handler that wraps another handler (normally a
+// DoubleSlashFixHandler) so that browser-based test harnesses can call
+// stripe-mock from a page served on another origin. It answers `OPTIONS`
+// preflight requests itself and adds CORS headers to the responses of
+// requests from allowed origins.
+type CORSHandler struct {
+	// AllowedHeaders are the request headers that may be sent from another
+	// origin. See DefaultCORSAllowedHeaders.
+	AllowedHeaders []string
+
+	// AllowedOrigins are the origins (like `http://localhost:3000`) that may
+	// call stripe-mock. `*` allows any origin.
+	AllowedOrigins []string
+
+	// ExposedHeaders are the response headers that may be read from another
+	// origin. See DefaultCORSExposedHeaders.
+	ExposedHeaders []string
+
+	Handler http.Handler
+}
+
+// ServeHTTP serves an HTTP request.
+func (h *CORSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	// Responses differ by origin, so caches need to know about it.
+	w.Header().Add("Vary", "Origin")
+
+	allowed := h.isAllowedOrigin(origin)
+	preflight := r.Method == http.MethodOptions &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+
+	if preflight {
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		if !allowed {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, corsOriginNotAllowed, origin)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsAllowedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(h.AllowedHeaders, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if allowed {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if len(h.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(h.ExposedHeaders, ", "))
+		}
+	}
+
+	h.Handler.ServeHTTP(w, r)
+}
+
+//
+// Private constants
+//
+
+// corsMaxAge is how long in seconds that browsers may cache the result of a
+// preflight request.
+const corsMaxAge = 600
+
+const corsOriginNotAllowed = "Origin '%s' isn't allowed to call stripe-mock. " +
+	"Start stripe-mock with `-cors-origins` to allow it."
+
+//
+// Private values
+//
+
+// corsAllowedMethods are the methods that may be used from another origin,
+// which are all the methods used by Stripe's API.
+var corsAllowedMethods = []string{
+	http.MethodDelete,
+	http.MethodGet,
+	http.MethodPost,
+}
+
+//
+// Private functions
+//
+
+// isAllowedOrigin checks whether an origin may call stripe-mock.
+func (h *CORSHandler) isAllowedOrigin(origin string) bool {
+	for _, allowedOrigin := range h.AllowedOrigins {
+		if allowedOrigin == "*" || strings.EqualFold(allowedOrigin, origin) {
+			return true
+		}
+	}
+	return false
+}
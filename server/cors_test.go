@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCORSHandler_Preflight(t *testing.T) {
+	handler := getTestCORSHandler(t, []string{"http://localhost:3000"})
+
+	req := httptest.NewRequest(http.MethodOptions, "http://example.com/v1/charges", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "authorization, stripe-version")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "DELETE, GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Stripe-Version")
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Idempotency-Key")
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Stripe-Account")
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestCORSHandler_PreflightDisallowedOrigin(t *testing.T) {
+	handler := getTestCORSHandler(t, []string{"http://localhost:3000"})
+
+	req := httptest.NewRequest(http.MethodOptions, "http://example.com/v1/charges", nil)
+	req.Header.Set("Origin", "http://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSHandler_Request(t *testing.T) {
+	handler := getTestCORSHandler(t, []string{"*"})
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com//v1/charges", nil)
+	req.Header.Set("Authorization", "Bearer sk_test_123")
+	req.Header.Set("Origin", "http://localhost:3000")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "Request-Id")
+	assert.Equal(t, "req_123", w.Header().Get("Request-Id"))
+	assert.Contains(t, w.Header().Values("Vary"), "Origin")
+}
+
+func TestCORSHandler_NoOrigin(t *testing.T) {
+	handler := getTestCORSHandler(t, []string{"*"})
+
+	// Requests that aren't from a browser on another origin are passed
+	// through untouched, including `OPTIONS` requests
+	req := httptest.NewRequest(http.MethodOptions, "http://example.com/v1/charges", nil)
+	req.Header.Set("Authorization", "Bearer sk_test_123")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func getTestCORSHandler(t *testing.T, allowedOrigins []string) *CORSHandler {
+	stub := getStubServer(t, nil)
+
+	httpMux := http.NewServeMux()
+	httpMux.HandleFunc("/", stub.HandleRequest)
+
+	return &CORSHandler{
+		AllowedHeaders: DefaultCORSAllowedHeaders,
+		AllowedOrigins: allowedOrigins,
+		ExposedHeaders: DefaultCORSExposedHeaders,
+		Handler:        &DoubleSlashFixHandler{httpMux},
+	}
+}
@@ -0,0 +1,345 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Private constants
+//
+
+const (
+	eventDestinationEnabled = "enabled"
+
+	// eventDestinationWebhookEndpoint is the type of event destination that
+	// events are delivered to with an HTTP request.
+	eventDestinationWebhookEndpoint = "webhook_endpoint"
+
+	// Event destinations receive either full v1 events (snapshots) or thin
+	// events that only refer to the object that they're about.
+	eventPayloadSnapshot = "snapshot"
+	eventPayloadThin     = "thin"
+)
+
+// eventDeliveryAttempts is how many times delivering an event to an event
+// destination is attempted before giving up.
+const eventDeliveryAttempts = 3
+
+// eventDeliveryTimeout is how long to wait for an event destination to
+// respond to a delivery.
+const eventDeliveryTimeout = 10 * time.Second
+
+// eventDeliveryRetryDelay is how long to wait before retrying a failed
+// delivery, multiplied by the number of attempts made so far.
+var eventDeliveryRetryDelay = 1 * time.Second
+
+//
+// Private functions
+//
+
+// createEvent creates an event of the given type about an object, like the
+// `checkout.session.completed` event created when a Checkout Session is paid.
+// Events are stored so that they can be retrieved from `/v1/events`. Events
+// about a connected account's objects carry the account's ID in `account`.
+//
+// A thin event (see createThinEvent) of the same type prefixed with `v1.` is
+// created alongside it, and both are delivered to any event destinations
+// that are listening for them.
+func (s *StubServer) createEvent(store *ObjectStore, account, eventType string, object map[string]interface{}) map[string]interface{} {
+	event, ok := s.generateObject("event")
+	if !ok {
+		event = map[string]interface{}{
+			"id":     randomID("evt"),
+			"object": "event",
+		}
+	}
+
+	var apiVersion interface{}
+	if s.spec.Info != nil {
+		apiVersion = s.spec.Info.Version
+	}
+
+	if account != "" {
+		event["account"] = account
+	}
+	event["api_version"] = apiVersion
+	event["created"] = time.Now().Unix()
+	event["data"] = map[string]interface{}{
+		"object": deepCopyMap(object),
+	}
+	event["livemode"] = false
+	event["pending_webhooks"] = 0
+	event["type"] = eventType
+
+	store.Put(event)
+	deliverEvent(store, eventPayloadSnapshot, eventType, event)
+
+	s.createThinEvent(store, account, "v1."+eventType, object)
+	return event
+}
+
+// createObjectEvents creates the events for a change made to an object
+// through the API, given the object before the change (nil if it was just
+// created) and after it (nil if it was deleted).
+//
+// PaymentIntents and SetupIntents get a `.created` event and one for each
+// status they move to (see intentKind), like `payment_intent.succeeded`.
+// Other objects get `.created`, `.updated`, and `.deleted` events, except
+// for those whose events are created elsewhere (see hasLifecycleEvents).
+func (s *StubServer) createObjectEvents(store *ObjectStore, account string, previous, object map[string]interface{}) {
+	current := object
+	if current == nil {
+		current = previous
+	}
+	objectType, _ := current["object"].(string)
+
+	if kind, ok := intentKinds[objectType]; ok {
+		if object == nil {
+			return
+		}
+
+		if previous == nil {
+			s.createEvent(store, account, objectType+".created", object)
+		}
+
+		status, _ := object["status"].(string)
+		if previous == nil || previous["status"] != status {
+			if eventType, ok := kind.statusEventTypes[status]; ok {
+				s.createEvent(store, account, eventType, object)
+			}
+		}
+
+		lastError := object[kind.lastErrorField]
+		if lastError != nil && (previous == nil || !reflect.DeepEqual(previous[kind.lastErrorField], lastError)) {
+			s.createEvent(store, account, kind.failureEventType, object)
+		}
+		return
+	}
+
+	if !hasLifecycleEvents(objectType) {
+		return
+	}
+
+	switch {
+	case previous == nil:
+		s.createEvent(store, account, objectType+".created", object)
+	case object == nil:
+		s.createEvent(store, account, objectType+".deleted", previous)
+	default:
+		s.createEvent(store, account, objectType+".updated", object)
+	}
+}
+
+// createThinEvent creates a thin event of the given type about an object. Thin
+// events don't carry the object, only a reference to it in `related_object`
+// that consumers use to fetch its current state. They're stored so that they
+// can be retrieved from `/v2/core/events/{id}`.
+func (s *StubServer) createThinEvent(store *ObjectStore, account, eventType string, object map[string]interface{}) map[string]interface{} {
+	objectType, _ := object["object"].(string)
+	id, _ := object["id"].(string)
+
+	event := map[string]interface{}{
+		"context":  nilIfEmpty(account),
+		"created":  time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		"id":       randomID("evt"),
+		"livemode": false,
+		"object":   "v2.core.event",
+		"reason":   nil,
+		"related_object": map[string]interface{}{
+			"id":   id,
+			"type": objectType,
+			"url":  s.objectURL(objectType, id),
+		},
+		"type": eventType,
+	}
+
+	store.Put(event)
+	deliverEvent(store, eventPayloadThin, eventType, event)
+	return event
+}
+
+// deliverEvent sends an event to each enabled webhook endpoint event
+// destination in a store that receives the given payload and has the event's
+// type (or `*`) in its `enabled_events`. Deliveries are made in the
+// background and signed like the real API's so that consumers can verify
+// them.
+func deliverEvent(store *ObjectStore, payload, eventType string, event map[string]interface{}) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("Couldn't serialize event: %v\n", err)
+		return
+	}
+
+	for _, destination := range store.List("v2.core.event_destination") {
+		if destination["status"] != eventDestinationEnabled ||
+			destination["type"] != eventDestinationWebhookEndpoint {
+			continue
+		}
+
+		destinationPayload, _ := destination["event_payload"].(string)
+		if destinationPayload == "" {
+			destinationPayload = eventPayloadThin
+		}
+		if destinationPayload != payload {
+			continue
+		}
+
+		if !isEventEnabled(destination["enabled_events"], eventType) {
+			continue
+		}
+
+		endpoint, _ := destination["webhook_endpoint"].(map[string]interface{})
+		url, _ := endpoint["url"].(string)
+		secret, _ := endpoint["signing_secret"].(string)
+		if url == "" {
+			continue
+		}
+
+		go postEvent(url, secret, body)
+	}
+}
+
+// hasLifecycleEvents checks whether objects of a type get `.created`,
+// `.updated`, and `.deleted` events from createObjectEvents. Events and v2
+// objects don't, and Checkout Sessions only get an event when they're
+// completed (see completeCheckoutSession).
+func hasLifecycleEvents(objectType string) bool {
+	return objectType != "" &&
+		objectType != "checkout.session" &&
+		objectType != "event" &&
+		!strings.HasPrefix(objectType, "v2.")
+}
+
+// isEventEnabled checks whether an event destination's `enabled_events`
+// include an event type.
+func isEventEnabled(enabledEvents interface{}, eventType string) bool {
+	events, _ := enabledEvents.([]interface{})
+	for _, event := range events {
+		if event == "*" || event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// objectURL finds the path that an object can be retrieved from, like
+// `/v1/checkout/sessions/cs_123`. Returns nil if it can't be retrieved.
+func (s *StubServer) objectURL(objectType, id string) interface{} {
+	var path string
+	for _, route := range s.routes[http.MethodGet] {
+		if len(route.pathParamNames) != 1 || !strings.HasSuffix(string(route.path), "}") {
+			continue
+		}
+
+		response, ok := route.operation.Responses["200"]
+		if !ok {
+			continue
+		}
+
+		content, ok := response.Content["application/json"]
+		if !ok || s.resolveResourceID(content.Schema) != objectType {
+			continue
+		}
+
+		// Prefer the shortest path so that the choice is stable.
+		if path == "" || len(route.path) < len(path) {
+			path = string(route.path)
+		}
+	}
+
+	if path == "" {
+		return nil
+	}
+	return path[:strings.LastIndex(path, "{")] + id
+}
+
+// postEvent delivers a serialized event to a URL with a `Stripe-Signature`
+// header made with the given signing secret. Deliveries that fail (including
+// those that get a non-2xx response) are retried up to eventDeliveryAttempts
+// times, and each failure is logged.
+func postEvent(url, secret string, body []byte) {
+	for attempt := 1; attempt <= eventDeliveryAttempts; attempt++ {
+		err := postEventOnce(url, secret, body)
+		if err == nil {
+			fmt.Printf("Delivered event to %s\n", url)
+			return
+		}
+
+		fmt.Printf("Couldn't deliver event to %s (attempt %d of %d): %v\n",
+			url, attempt, eventDeliveryAttempts, err)
+		if attempt < eventDeliveryAttempts {
+			time.Sleep(time.Duration(attempt) * eventDeliveryRetryDelay)
+		}
+	}
+}
+
+// postEventOnce makes a single attempt at delivering a serialized event. See
+// postEvent.
+func postEventOnce(url, secret string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Stripe-Signature", signEvent(secret, time.Now(), body))
+
+	client := &http.Client{Timeout: eventDeliveryTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("status=%v", response.StatusCode)
+	}
+	return nil
+}
+
+// signEvent builds the `Stripe-Signature` header for a delivery of a
+// serialized event, which is an HMAC-SHA256 of its timestamp and body.
+func signEvent(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// transitionEventDestination is the state machine for event destinations.
+// They're enabled when they're created, and webhook endpoints are given a
+// signing secret that deliveries to them are signed with.
+func transitionEventDestination(s *StubServer, t *transition) *ResponseError {
+	if t.action != actionCreate {
+		return nil
+	}
+
+	for _, field := range []string{"enabled_events", "event_payload", "type"} {
+		if value, ok := t.requestData[field]; ok {
+			t.object[field] = value
+		}
+	}
+	t.object["status"] = eventDestinationEnabled
+
+	if endpoint, ok := t.requestData["webhook_endpoint"].(map[string]interface{}); ok {
+		t.object["webhook_endpoint"] = map[string]interface{}{
+			"signing_secret": "whsec_" + randomIDRandomPart(),
+			"url":            endpoint["url"],
+		}
+	}
+
+	return nil
+}
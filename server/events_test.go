@@ -0,0 +1,238 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCreateEvent(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	event := server.createEvent(testStore(server), "", "checkout.session.completed", map[string]interface{}{
+		"id":     "cs_test_123",
+		"object": "checkout.session",
+	})
+	assert.Equal(t, "checkout.session.completed", event["type"])
+	assert.Equal(t, testSpecAPIVersion, event["api_version"])
+	assert.NotEqual(t, "evt_123", event["id"])
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/events/"+event["id"].(string),
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	stored := decodeObject(t, body)
+	assert.Equal(t, "checkout.session.completed", stored["type"])
+
+	data := stored["data"].(map[string]interface{})
+	assert.Equal(t, "cs_test_123", data["object"].(map[string]interface{})["id"])
+}
+
+func TestCreateThinEvent(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	event := server.createThinEvent(testStore(server), "", "v1.checkout.session.completed", map[string]interface{}{
+		"id":     "cs_test_123",
+		"object": "checkout.session",
+	})
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v2/core/events/"+event["id"].(string),
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	stored := decodeObject(t, body)
+	assert.Equal(t, "v2.core.event", stored["object"])
+	assert.Equal(t, "v1.checkout.session.completed", stored["type"])
+	assert.Nil(t, stored["data"])
+	assert.Equal(t, map[string]interface{}{
+		"id":   "cs_test_123",
+		"type": "checkout.session",
+		"url":  "/v1/checkout/sessions/cs_test_123",
+	}, stored["related_object"])
+
+	// v1 events come with a thin event
+	server.createEvent(testStore(server), "", "checkout.session.completed", map[string]interface{}{
+		"id":     "cs_test_123",
+		"object": "checkout.session",
+	})
+	assert.Equal(t, 2, len(testStore(server).List("v2.core.event")))
+}
+
+func TestEventDestinations(t *testing.T) {
+	deliveries := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	destination := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		deliveries <- r
+		bodies <- body
+	}))
+	defer destination.Close()
+
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	createDestination := func(payload, eventType string) map[string]interface{} {
+		resp, body := sendRequestToServer(t, server, "POST", "/v2/core/event_destinations",
+			`{"enabled_events": ["`+eventType+`"], "event_payload": "`+payload+`", "name": "Test", "type": "webhook_endpoint", "webhook_endpoint": {"url": "`+destination.URL+`"}}`,
+			getJSONHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		return decodeObject(t, body)
+	}
+
+	thinDestination := createDestination("thin", "v1.checkout.session.completed")
+	assert.Equal(t, "enabled", thinDestination["status"])
+	secret := thinDestination["webhook_endpoint"].(map[string]interface{})["signing_secret"].(string)
+	assert.True(t, strings.HasPrefix(secret, "whsec_"))
+
+	// Not listening for this type
+	createDestination("thin", "v1.billing.meter.error_report_triggered")
+
+	server.createEvent(testStore(server), "", "checkout.session.completed", map[string]interface{}{
+		"id":     "cs_test_123",
+		"object": "checkout.session",
+	})
+
+	select {
+	case r := <-deliveries:
+		body := <-bodies
+		event := decodeObject(t, body)
+		assert.Equal(t, "v1.checkout.session.completed", event["type"])
+		assert.Equal(t, "cs_test_123", event["related_object"].(map[string]interface{})["id"])
+
+		// The signature can be verified with the destination's secret
+		signature := r.Header.Get("Stripe-Signature")
+		timestamp, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+		assert.NoError(t, err)
+		assert.Equal(t, signEvent(secret, time.Unix(timestamp, 0), body), signature)
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("event wasn't delivered")
+	}
+
+	select {
+	case <-deliveries:
+		t.Fatal("event was delivered to a destination that isn't listening for it")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCreateObjectEvents(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	eventTypes := func() []string {
+		var types []string
+		for _, event := range testStore(server).List("event") {
+			types = append([]string{event["type"].(string)}, types...)
+		}
+		return types
+	}
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&capture_method=manual&confirm=true&payment_method=pm_card_visa",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+	assert.Equal(t, []string{
+		"payment_intent.created",
+		"payment_intent.amount_capturable_updated",
+	}, eventTypes())
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id+"/capture",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "payment_intent.succeeded", eventTypes()[2])
+
+	// Failed actions don't create events
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id+"/capture",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, 3, len(eventTypes()))
+
+	// Each v1 event comes with a thin event
+	assert.Equal(t, 3, len(testStore(server).List("v2.core.event")))
+}
+
+// A failed 3-D Secure authentication creates a `payment_failed` event.
+func TestCreateObjectEvents_PaymentFailed(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&confirm=true&payment_method=pm_card_threeDSecure2Required",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "POST", testPageURL(authenticatePath+id),
+		"result=fail", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	event := testStore(server).List("event")[0]
+	assert.Equal(t, "payment_intent.payment_failed", event["type"])
+	assert.Equal(t, id, event["data"].(map[string]interface{})["object"].(map[string]interface{})["id"])
+}
+
+// Events about objects other than Checkout Sessions are delivered to event
+// destinations too, and failed deliveries are retried.
+func TestEventDestinations_ObjectEvents(t *testing.T) {
+	defer func(delay time.Duration) { eventDeliveryRetryDelay = delay }(eventDeliveryRetryDelay)
+	eventDeliveryRetryDelay = time.Millisecond
+
+	var attempts int32
+	deliveries := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	destination := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		deliveries <- r
+		bodies <- body
+	}))
+	defer destination.Close()
+
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v2/core/event_destinations",
+		`{"enabled_events": ["v1.payment_intent.succeeded"], "event_payload": "thin", "name": "Test", "type": "webhook_endpoint", "webhook_endpoint": {"url": "`+destination.URL+`"}}`,
+		getJSONHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	secret := decodeObject(t, body)["webhook_endpoint"].(map[string]interface{})["signing_secret"].(string)
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&confirm=true&payment_method=pm_card_visa", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	select {
+	case r := <-deliveries:
+		body := <-bodies
+		event := decodeObject(t, body)
+		assert.Equal(t, "v1.payment_intent.succeeded", event["type"])
+		assert.Equal(t, id, event["related_object"].(map[string]interface{})["id"])
+
+		signature := r.Header.Get("Stripe-Signature")
+		timestamp, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+		assert.NoError(t, err)
+		assert.Equal(t, signEvent(secret, time.Unix(timestamp, 0), body), signature)
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("event wasn't delivered")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestSignEvent(t *testing.T) {
+	signature := signEvent("whsec_123", time.Unix(1600000000, 0), []byte(`{"id":"evt_123"}`))
+	assert.True(t, strings.HasPrefix(signature, "t=1600000000,v1="))
+	assert.Equal(t, 64, len(strings.TrimPrefix(signature, "t=1600000000,v1=")))
+	assert.NotEqual(t, signature, signEvent("whsec_456", time.Unix(1600000000, 0), []byte(`{"id":"evt_123"}`)))
+}
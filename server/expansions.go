@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+//
+// Private constants
+//
+
+// maxExpansionDepth is the maximum number of levels of a property that can be
+// expanded, like `data.customer.default_source.owner`.
+const maxExpansionDepth = 4
+
+const expansionTooDeep = "You cannot expand more than %d levels of a " +
+	"property. Property: %s"
+
+//
+// Private functions
+//
+
+// checkExpansionDepth makes sure that none of the raw expansions sent with a
+// request go deeper than the API allows.
+func checkExpansionDepth(rawExpansions []string) *ResponseError {
+	for _, expansion := range rawExpansions {
+		if len(strings.Split(expansion, ".")) <= maxExpansionDepth {
+			continue
+		}
+
+		stripeError := createStripeError(typeInvalidRequestError,
+			fmt.Sprintf(expansionTooDeep, maxExpansionDepth, expansion))
+		stripeError.ErrorInfo.Param = "expand"
+		return stripeError
+	}
+
+	return nil
+}
+
+// collapseExpansions replaces expanded objects in some data with their IDs so
+// that it can be stored the way the real API stores it. Stored objects are
+// expanded again as they're returned (see expandFromStore).
+func collapseExpansions(data interface{}, level *ExpansionLevel) {
+	if level == nil {
+		return
+	}
+
+	switch v := data.(type) {
+	case []interface{}:
+		for _, item := range v {
+			collapseExpansions(item, level)
+		}
+
+	case map[string]interface{}:
+		for key, subLevel := range level.expansions {
+			value, ok := v[key].(map[string]interface{})
+			if !ok {
+				collapseExpansions(v[key], subLevel)
+				continue
+			}
+
+			if id, ok := value["id"].(string); ok {
+				v[key] = id
+				continue
+			}
+
+			// Objects without an ID (like a list) may contain expanded
+			// objects themselves.
+			collapseExpansions(value, subLevel)
+		}
+	}
+}
+
+// expandFromStore expands the fields of some data named by an expansion level
+// with the objects that they reference from a store, recursively. Fields
+// that were already expanded by the generator are replaced with the stored
+// object of the same ID. References to objects that aren't in the store are
+// left as they are.
+//
+// The data is modified in place and returned.
+func expandFromStore(store *ObjectStore, data interface{}, level *ExpansionLevel) interface{} {
+	if level == nil {
+		return data
+	}
+
+	switch v := data.(type) {
+	case []interface{}:
+		for i, item := range v {
+			v[i] = expandFromStore(store, item, level)
+		}
+
+	case map[string]interface{}:
+		for key, subLevel := range level.expansions {
+			value, ok := v[key]
+			if !ok {
+				continue
+			}
+			v[key] = expandValueFromStore(store, value, subLevel)
+		}
+	}
+
+	return data
+}
+
+// expandValueFromStore expands a single value that's been named by an
+// expansion, which is either an ID, an already expanded object, or something
+// that contains either (like a list).
+func expandValueFromStore(store *ObjectStore, value interface{}, level *ExpansionLevel) interface{} {
+	var id string
+	switch v := value.(type) {
+	case map[string]interface{}:
+		id, _ = v["id"].(string)
+	case string:
+		id = v
+	}
+
+	if id != "" {
+		if stored, ok := store.Get(id); ok {
+			return expandFromStore(store, stored, level)
+		}
+	}
+
+	return expandFromStore(store, value, level)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCheckExpansionDepth(t *testing.T) {
+	assert.Nil(t, checkExpansionDepth(nil))
+	assert.Nil(t, checkExpansionDepth([]string{"customer", "data.customer.default_source.owner"}))
+
+	stripeError := checkExpansionDepth([]string{"customer", "a.b.c.d.e"})
+	assert.NotNil(t, stripeError)
+	assert.Equal(t, "expand", stripeError.ErrorInfo.Param)
+	assert.Equal(t,
+		"You cannot expand more than 4 levels of a property. Property: a.b.c.d.e",
+		stripeError.ErrorInfo.Message)
+}
+
+func TestCheckExpansionDepth_Request(t *testing.T) {
+	resp, body := sendRequest(t, "GET", "/v1/payment_intents/pi_123?expand[]=a.b.c.d.e",
+		"", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "expand", errorInfo["param"])
+}
+
+func TestCollapseExpansions(t *testing.T) {
+	data := map[string]interface{}{
+		"customer": map[string]interface{}{"id": "cus_123", "object": "customer"},
+		"list": map[string]interface{}{
+			"data": []interface{}{
+				map[string]interface{}{
+					"charge": map[string]interface{}{"id": "ch_123"},
+				},
+			},
+			"object": "list",
+		},
+		"other": map[string]interface{}{"id": "other_123"},
+	}
+
+	collapseExpansions(data, parseExpansionLevel([]string{"customer", "list.data.charge"}))
+
+	assert.Equal(t, "cus_123", data["customer"])
+	list := data["list"].(map[string]interface{})
+	item := list["data"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "ch_123", item["charge"])
+	assert.Equal(t, map[string]interface{}{"id": "other_123"}, data["other"])
+}
+
+func TestExpandFromStore(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/customers",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	customerID := decodeObject(t, body)["id"].(string)
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&customer="+customerID, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intentID := decodeObject(t, body)["id"].(string)
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+intentID,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, customerID, decodeObject(t, body)["customer"])
+
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/payment_intents/"+intentID+"?expand[]=customer", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	customer := decodeObject(t, body)["customer"].(map[string]interface{})
+	assert.Equal(t, customerID, customer["id"])
+	assert.Equal(t, "customer", customer["object"])
+
+	// The expansion isn't saved back to the store
+	intent, ok := testStore(server).Get(intentID)
+	assert.True(t, ok)
+	assert.Equal(t, customerID, intent["customer"])
+}
+
+func TestExpandFromStore_Nested(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+	testStore(server).Put(map[string]interface{}{
+		"id":     "cus_123",
+		"object": "customer",
+		"source": "card_123",
+	})
+	testStore(server).Put(map[string]interface{}{
+		"id":     "card_123",
+		"object": "card",
+	})
+
+	data := map[string]interface{}{
+		"data": []interface{}{
+			map[string]interface{}{"customer": "cus_123"},
+			map[string]interface{}{"customer": "cus_missing"},
+		},
+	}
+	expandFromStore(testStore(server), data, parseExpansionLevel([]string{"data.customer.source"}))
+
+	items := data["data"].([]interface{})
+	customer := items[0].(map[string]interface{})["customer"].(map[string]interface{})
+	assert.Equal(t, "cus_123", customer["id"])
+	assert.Equal(t, "card", customer["source"].(map[string]interface{})["object"])
+
+	// References to objects that aren't stored are left alone
+	assert.Equal(t, "cus_missing", items[1].(map[string]interface{})["customer"])
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// FaultInjection configures the faults that stripe-mock injects into its
+// responses to exercise clients' retry and idempotency handling.
+type FaultInjection struct {
+	// Faults are the faults injected into every operation that isn't listed
+	// in Operations.
+	Faults
+
+	// Operations overrides the faults injected into individual operations,
+	// keyed by method and path like `POST /v1/payment_intents`.
+	Operations map[string]Faults `json:"operations"`
+
+	// Seed seeds the decisions about which requests get faults so that a run
+	// can be reproduced. A random seed is chosen (and printed) if it's zero.
+	Seed int64 `json:"seed"`
+}
+
+// Faults are the probabilities (from 0 to 1) of each kind of fault being
+// injected into a response. At most one fault is injected per request, so
+// they may not add up to more than 1.
+type Faults struct {
+	// Disconnect is the probability that the connection is closed partway
+	// through sending the response. The request is still carried out.
+	Disconnect float64 `json:"disconnect"`
+
+	// Error is the probability that the request fails with a 500 `api_error`
+	// without being carried out.
+	Error float64 `json:"error"`
+
+	// Timeout is the probability that the response is delayed for
+	// TimeoutSeconds after the request has been carried out.
+	Timeout float64 `json:"timeout"`
+
+	// TimeoutSeconds is how long a response is delayed for a timeout. It
+	// defaults to 90 seconds, which is past the default timeout of Stripe's
+	// libraries.
+	TimeoutSeconds float64 `json:"timeout_seconds"`
+}
+
+//
+// Public functions
+//
+
+// LoadFaultInjection loads fault injection settings from a JSON file. For
+// example:
+//
+//	{"error": 0.05, "seed": 42, "operations": {"POST /v1/payment_intents": {"disconnect": 0.2}}}
+func LoadFaultInjection(path string) (*FaultInjection, error) {
+	if !isJSONFile(path) {
+		return nil, fmt.Errorf("Faults should come from a JSON file")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading faults: %v", err)
+	}
+
+	var faultInjection FaultInjection
+	err = json.Unmarshal(data, &faultInjection)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding faults: %v", err)
+	}
+
+	return &faultInjection, nil
+}
+
+// SetFaultInjection configures the faults that stripe-mock injects into its
+// responses.
+func (s *StubServer) SetFaultInjection(faultInjection *FaultInjection) error {
+	err := faultInjection.Faults.validate()
+	if err != nil {
+		return err
+	}
+
+	for operation, faults := range faultInjection.Operations {
+		if !s.isOperation(operation) {
+			return fmt.Errorf("'%s' isn't an operation like `POST /v1/payment_intents`", operation)
+		}
+
+		err := faults.validate()
+		if err != nil {
+			return fmt.Errorf("faults for '%s': %v", operation, err)
+		}
+	}
+
+	seed := faultInjection.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fmt.Printf("Injecting faults with seed: %v\n", seed)
+
+	s.faultInjector.mu.Lock()
+	defer s.faultInjector.mu.Unlock()
+
+	s.faultInjector.config = faultInjection
+	s.faultInjector.rand = rand.New(rand.NewSource(seed))
+	return nil
+}
+
+//
+// Private constants
+//
+
+// defaultFaultTimeout is how long a response is delayed for a timeout unless
+// configured otherwise.
+const defaultFaultTimeout = 90 * time.Second
+
+const (
+	faultDisconnect = "disconnect"
+	faultError      = "error"
+	faultTimeout    = "timeout"
+)
+
+const (
+	injectedError = "An unknown error occurred. This error was injected " +
+		"by stripe-mock."
+
+	typeAPIError = "api_error"
+)
+
+//
+// Private types
+//
+
+// bufferedResponseWriter is a ResponseWriter that holds on to a response so
+// that it can be delivered late or cut short (see deliverFault).
+type bufferedResponseWriter struct {
+	body   bytes.Buffer
+	header http.Header
+	status int
+}
+
+// faultInjector decides which requests get faults.
+type faultInjector struct {
+	config *FaultInjection
+	mu     sync.Mutex
+	rand   *rand.Rand
+}
+
+//
+// Private functions
+//
+
+// chooseFault decides whether to inject a fault into the response to a
+// request to a route, and which. Returns an empty string for no fault.
+//
+// Decisions come from a random source seeded by configuration, so they can be
+// reproduced by sending the same requests in the same order.
+func (s *StubServer) chooseFault(r *http.Request, route *stubServerRoute) (string, time.Duration) {
+	s.faultInjector.mu.Lock()
+	defer s.faultInjector.mu.Unlock()
+
+	if s.faultInjector.config == nil {
+		return "", 0
+	}
+
+	faults := s.faultInjector.config.Faults
+	if operationFaults, ok := s.faultInjector.config.Operations[r.Method+" "+string(route.path)]; ok {
+		faults = operationFaults
+	}
+
+	if faults.Disconnect == 0 && faults.Error == 0 && faults.Timeout == 0 {
+		return "", 0
+	}
+
+	timeout := defaultFaultTimeout
+	if faults.TimeoutSeconds > 0 {
+		timeout = time.Duration(faults.TimeoutSeconds * float64(time.Second))
+	}
+
+	roll := s.faultInjector.rand.Float64()
+	switch {
+	case roll < faults.Error:
+		return faultError, 0
+	case roll < faults.Error+faults.Timeout:
+		return faultTimeout, timeout
+	case roll < faults.Error+faults.Timeout+faults.Disconnect:
+		return faultDisconnect, 0
+	}
+	return "", 0
+}
+
+// createInjectedError creates the error returned for an injected `error`
+// fault.
+func createInjectedError() *ResponseError {
+	return createStripeError(typeAPIError, injectedError)
+}
+
+// deliverFault delivers a response that was buffered so that a fault could be
+// injected into it. For a timeout, the response is sent once the timeout has
+// passed (unless the client gives up first). For a disconnect, the connection
+// is closed after sending only half of the response.
+func deliverFault(fault string, timeout time.Duration, w http.ResponseWriter, r *http.Request, buffered *bufferedResponseWriter) {
+	switch fault {
+	case faultDisconnect:
+		disconnect(w, buffered)
+
+	case faultTimeout:
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+		}
+
+		for key, values := range buffered.header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(buffered.status)
+		_, err := w.Write(buffered.body.Bytes())
+		if err != nil {
+			fmt.Printf("Error writing to client: %v\n", err)
+		}
+	}
+}
+
+// disconnect takes over a response's connection, writes the first half of a
+// buffered response to it, and closes it. Connections that can't be taken
+// over (like HTTP/2 streams) are aborted instead.
+func disconnect(w http.ResponseWriter, buffered *bufferedResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+
+	conn, bufrw, err := hijacker.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	defer conn.Close()
+
+	body := buffered.body.Bytes()
+	buffered.header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	fmt.Fprintf(bufrw, "HTTP/1.1 %d %s\r\n", buffered.status, http.StatusText(buffered.status))
+	buffered.header.Write(bufrw)
+	bufrw.WriteString("\r\n")
+	bufrw.Write(body[:len(body)/2])
+	bufrw.Flush()
+}
+
+// newBufferedResponseWriter creates a bufferedResponseWriter starting with a
+// copy of the headers already set on another ResponseWriter.
+func newBufferedResponseWriter(w http.ResponseWriter) *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		header: w.Header().Clone(),
+		status: http.StatusOK,
+	}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+// validate makes sure that fault probabilities make sense.
+func (f Faults) validate() error {
+	for name, probability := range map[string]float64{
+		faultDisconnect: f.Disconnect,
+		faultError:      f.Error,
+		faultTimeout:    f.Timeout,
+	} {
+		if probability < 0 || probability > 1 {
+			return fmt.Errorf("probability of %s should be between 0 and 1, but was %v", name, probability)
+		}
+	}
+
+	if f.Disconnect+f.Error+f.Timeout > 1 {
+		return fmt.Errorf("probabilities of faults add up to more than 1")
+	}
+
+	if f.TimeoutSeconds < 0 {
+		return fmt.Errorf("timeout_seconds can't be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestLoadFaultInjection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faults.json")
+	err := os.WriteFile(path,
+		[]byte(`{"error": 0.1, "seed": 42, "operations": {"POST /v1/customers": {"timeout": 0.5, "timeout_seconds": 5}}}`), 0o600)
+	assert.NoError(t, err)
+
+	faultInjection, err := LoadFaultInjection(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.1, faultInjection.Error)
+	assert.Equal(t, int64(42), faultInjection.Seed)
+	assert.Equal(t, 0.5, faultInjection.Operations["POST /v1/customers"].Timeout)
+	assert.Equal(t, 5.0, faultInjection.Operations["POST /v1/customers"].TimeoutSeconds)
+
+	_, err = LoadFaultInjection(filepath.Join(t.TempDir(), "faults.yaml"))
+	assert.Error(t, err)
+}
+
+func TestSetFaultInjection(t *testing.T) {
+	server := getStubServer(t, nil)
+
+	assert.NoError(t, server.SetFaultInjection(&FaultInjection{
+		Faults:     Faults{Error: 0.5, Timeout: 0.5},
+		Operations: map[string]Faults{"POST /v1/customers": {Disconnect: 1}},
+	}))
+
+	assert.Error(t, server.SetFaultInjection(&FaultInjection{
+		Faults: Faults{Error: 1.5},
+	}))
+	assert.Error(t, server.SetFaultInjection(&FaultInjection{
+		Faults: Faults{Error: 0.5, Disconnect: 0.6},
+	}))
+	assert.Error(t, server.SetFaultInjection(&FaultInjection{
+		Faults: Faults{Timeout: 0.5, TimeoutSeconds: -1},
+	}))
+	assert.Error(t, server.SetFaultInjection(&FaultInjection{
+		Operations: map[string]Faults{"POST /v1/nonexistent": {Error: 1}},
+	}))
+	assert.Error(t, server.SetFaultInjection(&FaultInjection{
+		Operations: map[string]Faults{"POST /v1/customers": {Error: -0.1}},
+	}))
+}
+
+func TestFaultInjection_Error(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+	assert.NoError(t, server.SetFaultInjection(&FaultInjection{
+		Operations: map[string]Faults{"POST /v1/customers": {Error: 1}},
+		Seed:       1,
+	}))
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/customers", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Stripe-Should-Retry"))
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, typeAPIError, errorInfo["type"])
+
+	// The request wasn't carried out
+	assert.Equal(t, 0, len(testStore(server).List("customer")))
+
+	// Other operations aren't affected
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestFaultInjection_Timeout(t *testing.T) {
+	server := getStubServer(t, nil)
+	assert.NoError(t, server.SetFaultInjection(&FaultInjection{
+		Faults: Faults{Timeout: 1, TimeoutSeconds: 0.05},
+		Seed:   1,
+	}))
+
+	start := time.Now()
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/events/evt_123", "", getDefaultHeaders())
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Request-Id"))
+	assert.Equal(t, "event", decodeObject(t, body)["object"])
+}
+
+func TestFaultInjection_Disconnect(t *testing.T) {
+	server := getStubServer(t, nil)
+	assert.NoError(t, server.SetFaultInjection(&FaultInjection{
+		Faults: Faults{Disconnect: 1},
+		Seed:   1,
+	}))
+
+	httpServer := httptest.NewServer(http.HandlerFunc(server.HandleRequest))
+	defer httpServer.Close()
+
+	req, err := http.NewRequest("GET", httpServer.URL+"/v1/events/evt_123", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer sk_test_123")
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The connection closes before all of the promised body arrives
+	_, err = ioutil.ReadAll(resp.Body)
+	assert.Error(t, err)
+}
+
+func TestFaultInjection_Seed(t *testing.T) {
+	statuses := func(seed int64) []int {
+		server := getStubServer(t, nil)
+		assert.NoError(t, server.SetFaultInjection(&FaultInjection{
+			Faults: Faults{Error: 0.5},
+			Seed:   seed,
+		}))
+
+		var statuses []int
+		for i := 0; i < 20; i++ {
+			resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges", "", getDefaultHeaders())
+			statuses = append(statuses, resp.StatusCode)
+		}
+		return statuses
+	}
+
+	first := statuses(42)
+	assert.Equal(t, first, statuses(42))
+	assert.Contains(t, first, http.StatusOK)
+	assert.Contains(t, first, http.StatusInternalServerError)
+}
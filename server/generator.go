@@ -90,7 +90,12 @@ type GenerateParams struct {
 type DataGenerator struct {
 	definitions map[string]*spec.Schema
 	fixtures    *spec.Fixtures
-	verbose     bool
+
+	// populateOptionalFields makes synthetic fixtures include optional
+	// properties instead of only the required ones.
+	populateOptionalFields bool
+
+	verbose bool
 }
 
 // Generate generates a fixture response.
@@ -228,6 +233,71 @@ func (g *DataGenerator) generateInternal(params *GenerateParams) (interface{}, e
 		})
 	}
 
+	if len(schema.AllOf) != 0 {
+		// An object described by several schemas at once is generated from
+		// their combination, which keeps the example since it's an example of
+		// all of them.
+		merged, err := g.mergeAllOf(schema)
+		if err != nil {
+			return nil, err
+		}
+
+		return g.generateInternal(&GenerateParams{
+			Expansions:    params.Expansions,
+			PathParams:    nil,
+			RequestMethod: params.RequestMethod,
+			RequestPath:   params.RequestPath,
+			Schema:        merged,
+
+			context: fmt.Sprintf("%sMerging branches of allOf:\n", context),
+			example: example,
+		})
+	}
+
+	if len(schema.OneOf) != 0 {
+		if schema.Nullable && example != nil && example.value == nil && params.Expansions == nil {
+			return nil, nil
+		}
+
+		// A discriminator tells us which branch the example belongs to, so
+		// that it can be kept. Otherwise we fall back to the same choice as
+		// for anyOf and don't pass the example.
+		oneOfSchema, err := g.findDiscriminatedBranch(schema, example)
+		if err != nil {
+			return nil, err
+		}
+
+		var context string
+		if oneOfSchema != nil {
+			context = fmt.Sprintf("%sChoosing branch of oneOf based on discriminator:\n", context)
+		} else {
+			example = nil
+
+			oneOfSchema, err = g.findBranch(schema.OneOf, params.RequestMethod == http.MethodDelete)
+			if err != nil {
+				return nil, err
+			}
+
+			if oneOfSchema != nil {
+				context = fmt.Sprintf("%sChoosing branch of oneOf based on request method:\n", context)
+			} else {
+				context = fmt.Sprintf("%sChoosing first branch of oneOf:\n", context)
+				oneOfSchema = schema.OneOf[0]
+			}
+		}
+
+		return g.generateInternal(&GenerateParams{
+			Expansions:    params.Expansions,
+			PathParams:    nil,
+			RequestMethod: params.RequestMethod,
+			RequestPath:   params.RequestPath,
+			Schema:        oneOfSchema,
+
+			context: context,
+			example: example,
+		})
+	}
+
 	if len(schema.AnyOf) == 1 && schema.Nullable {
 		if example != nil && example.value == nil {
 			if params.Expansions == nil {
@@ -310,6 +380,22 @@ func (g *DataGenerator) generateInternal(params *GenerateParams) (interface{}, e
 		return searchResultData, err
 	}
 
+	if isV2ListResource(schema) {
+		// v2 lists don't have an `object` and are paginated with URLs, but
+		// are otherwise special-cased just like v1 lists
+		listData, err := g.generateV2ListResource(&GenerateParams{
+			Expansions:    params.Expansions,
+			PathParams:    nil,
+			RequestMethod: params.RequestMethod,
+			RequestPath:   params.RequestPath,
+			Schema:        schema,
+
+			context: context,
+			example: example,
+		})
+		return listData, err
+	}
+
 	if isBinaryResource(schema) {
 		return "Stripe binary response", nil
 	}
@@ -347,19 +433,24 @@ func (g *DataGenerator) generateInternal(params *GenerateParams) (interface{}, e
 
 	if schema.Type == "boolean" || schema.Type == "integer" ||
 		schema.Type == "number" || schema.Type == "string" {
+		if schema.Const != nil {
+			return schema.Const, nil
+		}
 		return example.value, nil
 	}
 
 	if schema.Type == "object" && schema.Properties == nil {
 		// For a generic object type with no particular properties specified, we
-		// assume it must not contain any expandable fields or list resources
-		return example.value, nil
+		// assume it must not contain any expandable fields or list resources.
+		// It's copied so that changes to the response (like a replaced ID)
+		// don't leak back into the fixtures.
+		return deepCopy(example.value), nil
 	}
 
 	if schema.Type == "array" {
 		// For lists that aren't contained in a list-object, we assume they do not
 		// contain any expandable fields or list resources
-		return example.value, nil
+		return deepCopy(example.value), nil
 	}
 
 	if schema.Type == "object" && schema.Properties != nil {
@@ -427,20 +518,112 @@ func (g *DataGenerator) generateInternal(params *GenerateParams) (interface{}, e
 // findAnyOfBranch finds a branch of a schema containing `anyOf` that's either
 // a deleted resource or not based off of the value of the deleted argument.
 func (g *DataGenerator) findAnyOfBranch(schema *spec.Schema, deleted bool) (*spec.Schema, error) {
-	for _, anyOfSchema := range schema.AnyOf {
-		anyOfSchema, _, err := g.maybeDereference(anyOfSchema, "")
+	return g.findBranch(schema.AnyOf, deleted)
+}
+
+// findBranch finds a branch of a schema's `anyOf` or `oneOf` that's either a
+// deleted resource or not based off of the value of the deleted argument.
+func (g *DataGenerator) findBranch(branches []*spec.Schema, deleted bool) (*spec.Schema, error) {
+	for _, branch := range branches {
+		branch, _, err := g.maybeDereference(branch, "")
 		if err != nil {
 			return nil, err
 		}
 
-		deletedResource := isDeletedResource(anyOfSchema)
+		deletedResource := isDeletedResource(branch)
 		if deleted == deletedResource {
-			return anyOfSchema, nil
+			return branch, nil
 		}
 	}
 	return nil, nil
 }
 
+// findDiscriminatedBranch finds the branch of a schema's `oneOf` that an
+// example belongs to according to the schema's discriminator. Returns nil if
+// the schema has no discriminator or the example doesn't say.
+func (g *DataGenerator) findDiscriminatedBranch(schema *spec.Schema, example *valueWrapper) (*spec.Schema, error) {
+	if schema.Discriminator == nil || example == nil {
+		return nil, nil
+	}
+
+	exampleMap, ok := example.value.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	value, ok := exampleMap[schema.Discriminator.PropertyName].(string)
+	if !ok {
+		return nil, nil
+	}
+
+	ref, ok := schema.Discriminator.Mapping[value]
+	if !ok {
+		ref = "#/components/schemas/" + value
+	}
+
+	for _, branch := range schema.OneOf {
+		if branch.Ref == ref {
+			branch, _, err := g.maybeDereference(branch, "")
+			return branch, err
+		}
+	}
+	return nil, nil
+}
+
+// mergeAllOf combines the branches of a schema's `allOf` (along with anything
+// that the schema itself specifies) into a single schema.
+func (g *DataGenerator) mergeAllOf(schema *spec.Schema) (*spec.Schema, error) {
+	merged := *schema
+	merged.AllOf = nil
+
+	for _, branch := range schema.AllOf {
+		branch, _, err := g.maybeDereference(branch, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if len(branch.AllOf) != 0 {
+			branch, err = g.mergeAllOf(branch)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if merged.Type == "" {
+			merged.Type = branch.Type
+		}
+		if merged.XResourceID == "" {
+			merged.XResourceID = branch.XResourceID
+		}
+		if merged.XExpandableFields == nil {
+			merged.XExpandableFields = branch.XExpandableFields
+		}
+		if len(merged.Enum) == 0 {
+			merged.Enum = branch.Enum
+		}
+		if merged.Const == nil {
+			merged.Const = branch.Const
+		}
+		if merged.Items == nil {
+			merged.Items = branch.Items
+		}
+
+		if len(branch.Properties) != 0 {
+			properties := make(map[string]*spec.Schema)
+			for key, property := range merged.Properties {
+				properties[key] = property
+			}
+			for key, property := range branch.Properties {
+				properties[key] = property
+			}
+			merged.Properties = properties
+		}
+		merged.Required = append(append([]string{}, merged.Required...), branch.Required...)
+	}
+
+	return &merged, nil
+}
+
 func (g *DataGenerator) maybeDereference(schema *spec.Schema, context string) (*spec.Schema, string, error) {
 	if schema.Ref != "" {
 		definition := definitionFromJSONPointer(schema.Ref)
@@ -568,6 +751,36 @@ func (g *DataGenerator) generateSearchResultResource(params *GenerateParams) (in
 	return searchResultData, nil
 }
 
+func (g *DataGenerator) generateV2ListResource(params *GenerateParams) (interface{}, error) {
+	itemData, err := g.generateInternal(&GenerateParams{
+		Expansions:    nil,
+		PathParams:    nil,
+		RequestMethod: params.RequestMethod,
+		RequestPath:   params.RequestPath,
+		Schema:        params.Schema.Properties["data"].Items,
+
+		context: fmt.Sprintf("%sPopulating v2 list resource:\n", params.context),
+		example: nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// There's only ever one page, so there are no page URLs.
+	listData := make(map[string]interface{})
+	for key := range params.Schema.Properties {
+		var val interface{}
+		switch key {
+		case "data":
+			val = []interface{}{itemData}
+		default:
+			val = nil
+		}
+		listData[key] = val
+	}
+	return listData, nil
+}
+
 //
 // Private constants
 //
@@ -734,32 +947,83 @@ func distributeReplacedIDsInValue(pathParams *PathParamsMap, value interface{})
 // been requested for an embedded object that doesn't occur at the top level of
 // the API.
 //
+// Values are picked to satisfy the schema's constraints and to look like what
+// the API would return: strings match their `pattern`, IDs have the prefix of
+// their resource, and currencies, emails, and URLs look real (see
+// syntheticString).
+func (g *DataGenerator) generateSyntheticFixture(schema *spec.Schema, context string, expansions *ExpansionLevel) interface{} {
+	return g.generateSyntheticValue(schema, syntheticField{}, context, expansions)
+}
+
+// generateSyntheticValue is generateSyntheticFixture for a value found at
+// field, which is used to pick realistic values for its name.
+//
 // This function calls itself recursively by initially iterating through every
 // property in an object schema, then recursing and returning values for
 // embedded objects and scalars.
-func (g *DataGenerator) generateSyntheticFixture(schema *spec.Schema, context string, expansions *ExpansionLevel) interface{} {
+func (g *DataGenerator) generateSyntheticValue(schema *spec.Schema, field syntheticField, context string, expansions *ExpansionLevel) interface{} {
 	context = fmt.Sprintf("%sGenerating synthetic fixture: %+v\n", context, schema)
 
 	// Return the minimum viable object by returning nil/null for a nullable
-	// property, if that property does not need to be expanded.
-	if schema.Nullable && expansions == nil {
+	// property, if that property does not need to be expanded (or optional
+	// fields are being populated and there's room to).
+	if schema.Nullable && expansions == nil && !g.populatesOptionalFieldsAt(field) {
 		return nil
 	}
 
+	if schema.Const != nil {
+		return schema.Const
+	}
+
+	// An example from the spec is the most realistic value there is.
+	if len(schema.Examples) > 0 {
+		return deepCopy(schema.Examples[0])
+	}
+
 	// Return a member of an enum if one is available because it's probably
 	// going to be a more realistic value.
 	if len(schema.Enum) > 0 {
 		return schema.Enum[0]
 	}
 
+	if schema.Ref != "" {
+		dereferencedSchema, context, err := g.maybeDereference(schema, context)
+		if err != nil {
+			panic(err)
+		}
+		return g.generateSyntheticValue(dereferencedSchema, field, context, expansions)
+	}
+
+	if len(schema.AllOf) > 0 {
+		merged, err := g.mergeAllOf(schema)
+		if err != nil {
+			panic(err)
+		}
+		return g.generateSyntheticValue(merged, field, context, expansions)
+	}
+
+	if len(schema.OneOf) > 0 {
+		dereferencedSchema, context, err := g.maybeDereference(schema.OneOf[0], context)
+		if err != nil {
+			panic(err)
+		}
+		return g.generateSyntheticValue(dereferencedSchema, field, context, expansions)
+	}
+
 	if len(schema.AnyOf) > 0 {
+		// The non-reference branch of an expandable field is the ID of the
+		// object that it can be expanded to.
+		if schema.XExpansionResources != nil {
+			field.resourceID = g.expandableResourceID(schema)
+		}
+
 		// Try the non-references first.
 		for _, subSchema := range schema.AnyOf {
 			if subSchema.Ref != "" {
 				continue
 			}
 
-			return g.generateSyntheticFixture(subSchema, context, expansions)
+			return g.generateSyntheticValue(subSchema, field, context, expansions)
 		}
 
 		// If no viable non-references, attempt to dereference the references
@@ -772,30 +1036,43 @@ func (g *DataGenerator) generateSyntheticFixture(schema *spec.Schema, context st
 			if err != nil {
 				panic(err)
 			}
-			return g.generateSyntheticFixture(dereferencedSchema, context, expansions)
+			return g.generateSyntheticValue(dereferencedSchema, field, context, expansions)
 		}
 		panic("Unexpected: anyOf with length > 0 should have contained a ref or non ref")
 	}
 
 	switch schema.Type {
 	case spec.TypeArray:
-		return []string{}
+		if schema.MinItems == 0 || schema.Items == nil {
+			return []string{}
+		}
+
+		// Fill in as few items as are allowed.
+		items := make([]interface{}, schema.MinItems)
+		for i := range items {
+			items[i] = g.generateSyntheticValue(schema.Items,
+				syntheticField{depth: field.depth}, context, nil)
+		}
+		return items
 
 	case spec.TypeBoolean:
 		return true
 
 	case spec.TypeInteger:
-		return 0
+		if schema.Format == spec.FormatUnixTime && schema.Minimum == nil && schema.Maximum == nil {
+			return int(time.Now().Unix())
+		}
+		return int(syntheticNumber(schema, 1))
 
 	case spec.TypeNumber:
-		return 0.0
+		return syntheticNumber(schema, 0.01)
 
 	case spec.TypeObject:
 		fixture := make(map[string]interface{})
 		for property, subSchema := range schema.Properties {
 			// Return the minimum viable object by not including properties
 			// that are not necessary for a valid object.
-			if !isRequiredProperty(schema, property) {
+			if !isRequiredProperty(schema, property) && !g.populatesOptionalFieldsAt(field) {
 				continue
 			}
 
@@ -804,17 +1081,47 @@ func (g *DataGenerator) generateSyntheticFixture(schema *spec.Schema, context st
 				propertyExpansions = expansions.expansions[property]
 			}
 
-			fixture[property] = g.generateSyntheticFixture(subSchema, context, propertyExpansions)
+			propertyField := syntheticField{depth: field.depth + 1, name: property}
+			if property == "id" {
+				propertyField.resourceID = schema.XResourceID
+			}
+
+			fixture[property] = g.generateSyntheticValue(subSchema, propertyField, context, propertyExpansions)
 		}
 		return fixture
 
 	case spec.TypeString:
-		return ""
+		return g.syntheticString(schema, field)
 	}
 
 	panic(fmt.Sprintf("%sUnhandled type: %s", context, stringOrEmpty(schema.Type)))
 }
 
+// syntheticNumber picks a number for a synthetic fixture, which is zero unless
+// the schema's bounds don't allow it. step is the smallest amount that a
+// number can go past an exclusive bound by.
+func syntheticNumber(schema *spec.Schema, step float64) float64 {
+	number := 0.0
+
+	if schema.Minimum != nil && (number < *schema.Minimum ||
+		(number == *schema.Minimum && schema.ExclusiveMinimum)) {
+		number = *schema.Minimum
+		if schema.ExclusiveMinimum {
+			number += step
+		}
+	}
+
+	if schema.Maximum != nil && (number > *schema.Maximum ||
+		(number == *schema.Maximum && schema.ExclusiveMaximum)) {
+		number = *schema.Maximum
+		if schema.ExclusiveMaximum {
+			number -= step
+		}
+	}
+
+	return number
+}
+
 func isDeletedResource(schema *spec.Schema) bool {
 	_, ok := schema.Properties["deleted"]
 	return ok
@@ -838,6 +1145,25 @@ func isListResource(schema *spec.Schema) bool {
 	return true
 }
 
+// isV2ListResource checks whether a schema is a list in the v2 API's shape,
+// which has page URLs instead of an `object` of `list`.
+func isV2ListResource(schema *spec.Schema) bool {
+	if schema.Type != "object" || schema.Properties == nil {
+		return false
+	}
+
+	if _, ok := schema.Properties["next_page_url"]; !ok {
+		return false
+	}
+
+	data, ok := schema.Properties["data"]
+	if !ok || data.Items == nil {
+		return false
+	}
+
+	return true
+}
+
 func isSearchResultResource(schema *spec.Schema) bool {
 	if schema.Type != "object" || schema.Properties == nil {
 		return false
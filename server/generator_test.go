@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	assert "github.com/stretchr/testify/require"
 	"github.com/stripe/stripe-mock/spec"
@@ -100,7 +102,7 @@ func TestConcurrentAccess(t *testing.T) {
 
 	// We use the real spec here because when there was a concurrency problem,
 	// it wasn't revealed due to the test spec being oversimplistic.
-	generator = DataGenerator{realSpec.Components.Schemas, &realFixtures, verbose}
+	generator = DataGenerator{definitions: realSpec.Components.Schemas, fixtures: &realFixtures, verbose: verbose}
 
 	var wg sync.WaitGroup
 
@@ -121,7 +123,7 @@ func TestConcurrentAccess(t *testing.T) {
 func TestGenerateResponseData(t *testing.T) {
 	// basic reference
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			Schema: &spec.Schema{Ref: "#/components/schemas/charge"},
 		})
@@ -138,7 +140,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// expansion
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			Expansions: &ExpansionLevel{
 				expansions: map[string]*ExpansionLevel{"customer": {
@@ -156,7 +158,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// bad expansion
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		_, err := generator.Generate(&GenerateParams{
 			Expansions: &ExpansionLevel{
 				expansions: map[string]*ExpansionLevel{"id": {
@@ -171,7 +173,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// bad nested expansion
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		_, err := generator.Generate(&GenerateParams{
 			Expansions: &ExpansionLevel{
 				expansions: map[string]*ExpansionLevel{"customer.id": {
@@ -185,7 +187,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// wildcard expansion
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			Expansions: &ExpansionLevel{wildcard: true},
 			Schema:     &spec.Schema{Ref: "#/components/schemas/charge"},
@@ -198,7 +200,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// list
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			RequestPath: "/v1/charges",
 			Schema:      listSchema,
@@ -214,8 +216,8 @@ func TestGenerateResponseData(t *testing.T) {
 	// nested list
 	{
 		generator := DataGenerator{
-			testSpec.Components.Schemas,
-			&spec.Fixtures{
+			definitions: testSpec.Components.Schemas,
+			fixtures: &spec.Fixtures{
 				Resources: map[spec.ResourceID]interface{}{
 					spec.ResourceID("charge"): map[string]interface{}{"id": "ch_123"},
 					spec.ResourceID("with_charges_list"): map[string]interface{}{
@@ -225,7 +227,7 @@ func TestGenerateResponseData(t *testing.T) {
 					},
 				},
 			},
-			verbose,
+			verbose: verbose,
 		}
 		data, err := generator.Generate(&GenerateParams{
 			Schema: &spec.Schema{
@@ -247,7 +249,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// search result
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			RequestPath: "/v1/search/charges",
 			Schema:      searchResultSchema,
@@ -262,13 +264,13 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// generated primary ID
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &spec.Fixtures{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &spec.Fixtures{
 			Resources: map[spec.ResourceID]interface{}{
 				spec.ResourceID("charge"): map[string]interface{}{
 					"id": "ch_123",
 				},
 			},
-		}, verbose}
+		}, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			PathParams: &PathParamsMap{},
 			Schema:     &spec.Schema{Ref: "#/components/schemas/charge"},
@@ -287,13 +289,13 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// generated primary ID (double prefix)
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &spec.Fixtures{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &spec.Fixtures{
 			Resources: map[spec.ResourceID]interface{}{
 				spec.ResourceID("charge"): map[string]interface{}{
 					"id": "ch_sub_123",
 				},
 			},
-		}, verbose}
+		}, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			PathParams: &PathParamsMap{},
 			Schema:     &spec.Schema{Ref: "#/components/schemas/charge"},
@@ -312,13 +314,13 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// generated primary ID (nil PathParamsMap)
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &spec.Fixtures{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &spec.Fixtures{
 			Resources: map[spec.ResourceID]interface{}{
 				spec.ResourceID("charge"): map[string]interface{}{
 					"id": "ch_123",
 				},
 			},
-		}, verbose}
+		}, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			PathParams: nil,
 			Schema:     &spec.Schema{Ref: "#/components/schemas/charge"},
@@ -337,7 +339,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// injected ID
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &spec.Fixtures{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &spec.Fixtures{
 			Resources: map[spec.ResourceID]interface{}{
 				spec.ResourceID("charge"): map[string]interface{}{
 					// This is contrived, but we inject the value we expect to be
@@ -349,7 +351,7 @@ func TestGenerateResponseData(t *testing.T) {
 					"id": "ch_123",
 				},
 			},
-		}, verbose}
+		}, verbose: verbose}
 		newID := "ch_123_InjectedFromURL"
 		data, err := generator.Generate(&GenerateParams{
 			PathParams: &PathParamsMap{PrimaryID: &newID},
@@ -367,8 +369,8 @@ func TestGenerateResponseData(t *testing.T) {
 	// injected ID in list url
 	{
 		generator := DataGenerator{
-			testSpec.Components.Schemas,
-			&spec.Fixtures{
+			definitions: testSpec.Components.Schemas,
+			fixtures: &spec.Fixtures{
 				Resources: map[spec.ResourceID]interface{}{
 					spec.ResourceID("charge"): map[string]interface{}{"id": "ch_123"},
 					spec.ResourceID("with_charges_list"): map[string]interface{}{
@@ -378,7 +380,7 @@ func TestGenerateResponseData(t *testing.T) {
 					},
 				},
 			},
-			verbose,
+			verbose: verbose,
 		}
 		data, err := generator.Generate(&GenerateParams{
 			Schema: &spec.Schema{
@@ -400,7 +402,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// injected secondary ID
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &spec.Fixtures{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &spec.Fixtures{
 			Resources: map[spec.ResourceID]interface{}{
 				spec.ResourceID("charge"): map[string]interface{}{
 					"id": "ch_123",
@@ -410,7 +412,7 @@ func TestGenerateResponseData(t *testing.T) {
 					"object": "customer",
 				},
 			},
-		}, verbose}
+		}, verbose: verbose}
 		newCustomerID := "cus_123_InjectedFromURL"
 		data, err := generator.Generate(&GenerateParams{
 			Expansions: &ExpansionLevel{
@@ -443,7 +445,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// data replacement on `POST`
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			RequestData: map[string]interface{}{
 				"customer": "cus_9999",
@@ -459,7 +461,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// *no* data replacement on non-`POST`
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			RequestData: map[string]interface{}{
 				"customer": "cus_9999",
@@ -475,7 +477,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// synthetic schema
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			Schema: &spec.Schema{
 				Properties: map[string]*spec.Schema{
@@ -499,7 +501,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// pick non-deleted anyOf branch
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			// Just needs to be any HTTP method that's not DELETE
 			RequestMethod: http.MethodPost,
@@ -519,7 +521,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// pick deleted anyOf branch
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			RequestMethod: http.MethodDelete,
 			Schema: &spec.Schema{AnyOf: []*spec.Schema{
@@ -538,7 +540,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// binary schema
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, verbose}
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures, verbose: verbose}
 		data, err := generator.Generate(&GenerateParams{
 			RequestMethod: http.MethodGet,
 			Schema: &spec.Schema{
@@ -786,7 +788,7 @@ func TestFindAnyOfBranch(t *testing.T) {
 		},
 	}
 
-	generator := DataGenerator{nil, nil, verbose}
+	generator := DataGenerator{verbose: verbose}
 
 	// Finds a deleted schema branch
 	{
@@ -812,7 +814,7 @@ func TestFindAnyOfBranch(t *testing.T) {
 
 func TestGenerateSyntheticFixture(t *testing.T) {
 	// Scalars (and an array, which is easy)
-	g := DataGenerator{nil, nil, verbose}
+	g := DataGenerator{verbose: verbose}
 	assert.Equal(t, []string{}, g.generateSyntheticFixture(&spec.Schema{Type: spec.TypeArray}, "", nil))
 	assert.Equal(t, true, g.generateSyntheticFixture(&spec.Schema{Type: spec.TypeBoolean}, "", nil))
 	assert.Equal(t, 0, g.generateSyntheticFixture(&spec.Schema{Type: spec.TypeInteger}, "", nil))
@@ -844,7 +846,7 @@ func TestGenerateSyntheticFixture(t *testing.T) {
 		map[string]interface{}{
 			"has_more": true,
 			"object":   "list",
-			"url":      "",
+			"url":      "https://example.com",
 		},
 		g.generateSyntheticFixture(&spec.Schema{
 			Type: "object",
@@ -870,6 +872,71 @@ func TestGenerateSyntheticFixture(t *testing.T) {
 		}, "", nil),
 	)
 
+	// Constants and bounds
+	minimum := 1.0
+	maximum := 0.5
+	assert.Equal(t, "card", g.generateSyntheticFixture(&spec.Schema{
+		Const: "card",
+		Type:  spec.TypeString,
+	}, "", nil))
+	assert.Equal(t, 1, g.generateSyntheticFixture(&spec.Schema{
+		Minimum: &minimum,
+		Type:    spec.TypeInteger,
+	}, "", nil))
+	assert.Equal(t, 2, g.generateSyntheticFixture(&spec.Schema{
+		ExclusiveMinimum: true,
+		Minimum:          &minimum,
+		Type:             spec.TypeInteger,
+	}, "", nil))
+	assert.Equal(t, 0.0, g.generateSyntheticFixture(&spec.Schema{
+		Maximum: &maximum,
+		Type:    spec.TypeNumber,
+	}, "", nil))
+	assert.Equal(t, "xxx", g.generateSyntheticFixture(&spec.Schema{
+		MinLength: 3,
+		Type:      spec.TypeString,
+	}, "", nil))
+	assert.Equal(t, []interface{}{"x", "x"}, g.generateSyntheticFixture(&spec.Schema{
+		Items:    &spec.Schema{MinLength: 1, Type: spec.TypeString},
+		MinItems: 2,
+		Type:     spec.TypeArray,
+	}, "", nil))
+
+	// Prefers an example from the spec
+	assert.Equal(t, "cus_123", g.generateSyntheticFixture(&spec.Schema{
+		Examples: []interface{}{"cus_123", "cus_456"},
+		Type:     spec.TypeString,
+	}, "", nil))
+
+	// Combines the branches of an allOf
+	assert.Equal(t,
+		map[string]interface{}{
+			"id":   "",
+			"name": "",
+		},
+		g.generateSyntheticFixture(&spec.Schema{
+			AllOf: []*spec.Schema{
+				{
+					Properties: map[string]*spec.Schema{"id": {Type: spec.TypeString}},
+					Required:   []string{"id"},
+					Type:       spec.TypeObject,
+				},
+				{
+					Properties: map[string]*spec.Schema{"name": {Type: spec.TypeString}},
+					Required:   []string{"name"},
+				},
+			},
+		}, "", nil),
+	)
+
+	// Takes the first branch of a oneOf
+	assert.Equal(t, true, g.generateSyntheticFixture(&spec.Schema{
+		OneOf: []*spec.Schema{
+			{Type: spec.TypeBoolean},
+			{Type: spec.TypeString},
+		},
+	}, "", nil))
+
 	// Nullable object property with expansion
 	assert.Equal(t,
 		map[string]interface{}{
@@ -894,6 +961,168 @@ func TestGenerateSyntheticFixture(t *testing.T) {
 	)
 }
 
+func TestGenerateSyntheticFixture_Realistic(t *testing.T) {
+	definitions := map[string]*spec.Schema{
+		"customer": {
+			Properties: map[string]*spec.Schema{
+				"id": {Type: spec.TypeString},
+			},
+			Required:    []string{"id"},
+			Type:        spec.TypeObject,
+			XResourceID: "customer",
+		},
+	}
+	fixtures := &spec.Fixtures{
+		Resources: map[spec.ResourceID]interface{}{
+			"customer":       map[string]interface{}{"id": "cus_123"},
+			"payment_intent": map[string]interface{}{"id": "pi_123"},
+		},
+	}
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"created":  {Format: spec.FormatUnixTime, Type: spec.TypeInteger},
+			"currency": {Type: spec.TypeString},
+			"customer": {
+				AnyOf: []*spec.Schema{
+					{Type: spec.TypeString},
+					{Ref: "#/components/schemas/customer"},
+				},
+				XExpansionResources: &spec.ExpansionResources{
+					OneOf: []*spec.Schema{{Ref: "#/components/schemas/customer"}},
+				},
+			},
+			"description":   {Nullable: true, Type: spec.TypeString},
+			"id":            {Pattern: "^pi_[a-zA-Z0-9]+$", Type: spec.TypeString},
+			"receipt_email": {Type: spec.TypeString},
+			"statement_descriptor": {
+				MinLength: 5,
+				Pattern:   "^[A-Z]+$",
+				Type:      spec.TypeString,
+			},
+		},
+		Required: []string{
+			"created",
+			"currency",
+			"customer",
+			"id",
+			"statement_descriptor",
+		},
+		Type:        spec.TypeObject,
+		XResourceID: "payment_intent",
+	}
+
+	g := DataGenerator{definitions: definitions, fixtures: fixtures, verbose: verbose}
+	fixture := g.generateSyntheticFixture(schema, "", nil).(map[string]interface{})
+
+	assert.InDelta(t, time.Now().Unix(), fixture["created"], 60)
+	assert.Equal(t, "usd", fixture["currency"])
+	assert.Regexp(t, "^cus_[a-zA-Z0-9]+$", fixture["customer"])
+	assert.Regexp(t, "^pi_[a-zA-Z0-9]+$", fixture["id"])
+	assert.Equal(t, "AAAAA", fixture["statement_descriptor"])
+
+	// Only required properties by default
+	_, ok := fixture["receipt_email"]
+	assert.False(t, ok)
+
+	// Including optional ones if asked for, with nullable ones populated
+	g = DataGenerator{definitions: definitions, fixtures: fixtures, populateOptionalFields: true, verbose: verbose}
+	fixture = g.generateSyntheticFixture(schema, "", nil).(map[string]interface{})
+	assert.Equal(t, "", fixture["description"])
+	assert.Equal(t, "jenny.rosen@example.com", fixture["receipt_email"])
+}
+
+// Optional properties of schemas that refer to each other are only populated
+// so far.
+func TestGenerateSyntheticFixture_PopulateOptionalFieldsDepth(t *testing.T) {
+	definitions := map[string]*spec.Schema{
+		"node": {
+			Properties: map[string]*spec.Schema{
+				"child": {Ref: "#/components/schemas/node"},
+			},
+			Type: spec.TypeObject,
+		},
+	}
+
+	g := DataGenerator{definitions: definitions, populateOptionalFields: true, verbose: verbose}
+	fixture := g.generateSyntheticFixture(definitions["node"], "", nil)
+
+	depth := 0
+	for node := fixture.(map[string]interface{}); node["child"] != nil; depth++ {
+		node = node["child"].(map[string]interface{})
+	}
+	assert.Equal(t, maxSyntheticOptionalDepth, depth)
+}
+
+func TestGenerateAllOfAndOneOf(t *testing.T) {
+	generator := DataGenerator{
+		definitions: map[string]*spec.Schema{
+			"bank_account": {
+				Properties: map[string]*spec.Schema{
+					"bank_name": {Type: spec.TypeString},
+					"object":    {Const: "bank_account", Type: spec.TypeString},
+				},
+				Type: spec.TypeObject,
+			},
+			"card": {
+				Properties: map[string]*spec.Schema{
+					"brand":  {Type: spec.TypeString},
+					"object": {Const: "card", Type: spec.TypeString},
+				},
+				Type: spec.TypeObject,
+			},
+			"resource": {
+				Properties: map[string]*spec.Schema{
+					"id": {Type: spec.TypeString},
+				},
+				Type:        spec.TypeObject,
+				XResourceID: "wallet",
+			},
+			"wallet": {
+				AllOf: []*spec.Schema{
+					{Ref: "#/components/schemas/resource"},
+					{
+						Properties: map[string]*spec.Schema{
+							"source": {
+								Discriminator: &spec.Discriminator{PropertyName: "object"},
+								OneOf: []*spec.Schema{
+									{Ref: "#/components/schemas/card"},
+									{Ref: "#/components/schemas/bank_account"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		fixtures: &spec.Fixtures{
+			Resources: map[spec.ResourceID]interface{}{
+				"wallet": map[string]interface{}{
+					"id": "wal_123",
+					"source": map[string]interface{}{
+						"bank_name": "STRIPE TEST BANK",
+						"object":    "bank_account",
+					},
+				},
+			},
+		},
+		verbose: verbose,
+	}
+
+	data, err := generator.Generate(&GenerateParams{
+		Schema: &spec.Schema{Ref: "#/components/schemas/wallet"},
+	})
+	assert.NoError(t, err)
+
+	// The fixture comes from a branch of the allOf, and the discriminator
+	// picks the branch of the oneOf that its source belongs to
+	wallet := data.(map[string]interface{})
+	assert.True(t, strings.HasPrefix(wallet["id"].(string), "wal_"))
+	assert.Equal(t, map[string]interface{}{
+		"bank_name": "STRIPE TEST BANK",
+		"object":    "bank_account",
+	}, wallet["source"])
+}
+
 func TestGenerateForNullableExpansion(t *testing.T) {
 	generator := DataGenerator{
 		definitions: realSpec.Components.Schemas,
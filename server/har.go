@@ -0,0 +1,367 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Public functions
+//
+
+// EnableHARCapture starts capturing the traffic that stripe-mock handles so
+// that it can be exported in the HAR 1.2 format, either with WriteHAR or
+// through the administrative endpoint at `/_stripe_mock/har`.
+func (s *StubServer) EnableHARCapture() {
+	s.har.mu.Lock()
+	defer s.har.mu.Unlock()
+
+	s.har.enabled = true
+}
+
+// WriteHAR writes the traffic captured since capture was enabled (or since it
+// was last cleared) in the HAR 1.2 format.
+func (s *StubServer) WriteHAR(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s.har.export())
+}
+
+//
+// Private constants
+//
+
+// harMaxEntries is the most requests that are kept in captured traffic. Once
+// it's reached, the oldest are discarded to make room for new ones.
+const harMaxEntries = 1000
+
+// harRedacted replaces the values of headers that carry credentials (see
+// harRedactedHeaders) in captured traffic.
+const harRedacted = "[REDACTED]"
+
+// harPath is the administrative endpoint that exports captured traffic with a
+// `GET` and clears it with a `DELETE`.
+const harPath = pagePathPrefix + "har"
+
+const (
+	harNotCapturing = "Traffic isn't being captured. Start stripe-mock with " +
+		"`-har` or `-har-out` to capture it."
+
+	harUnsupportedMethod = "Unsupported method %s for %s. Send a `GET` to " +
+		"export captured traffic as HAR or a `DELETE` to clear it."
+)
+
+//
+// Private values
+//
+
+// harRedactedHeaders are the headers whose values are redacted in captured
+// traffic because they carry credentials, like API keys in `Authorization`.
+// Browsers leave the same ones out of the HAR files that they export.
+var harRedactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+	"Set-Cookie":          true,
+}
+
+//
+// Private types
+//
+
+// harCapture holds captured traffic. Requests are captured concurrently, so
+// access is synchronized.
+type harCapture struct {
+	enabled bool
+	entries []*harEntry
+	mu      sync.Mutex
+}
+
+// The types below are the parts of the HAR 1.2 format that stripe-mock fills
+// in. See http://www.softwareishard.com/blog/har-12-spec/.
+
+type harContent struct {
+	MimeType string `json:"mimeType"`
+	Size     int    `json:"size"`
+	Text     string `json:"text"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	Cache           struct{}    `json:"cache"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+	Version string      `json:"version"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harRequest struct {
+	BodySize    int          `json:"bodySize"`
+	Cookies     []harPair    `json:"cookies"`
+	Headers     []harPair    `json:"headers"`
+	HeadersSize int          `json:"headersSize"`
+	HTTPVersion string       `json:"httpVersion"`
+	Method      string       `json:"method"`
+	PostData    *harPostData `json:"postData,omitempty"`
+	QueryString []harPair    `json:"queryString"`
+	URL         string       `json:"url"`
+}
+
+type harResponse struct {
+	BodySize    int        `json:"bodySize"`
+	Content     harContent `json:"content"`
+	Cookies     []harPair  `json:"cookies"`
+	Headers     []harPair  `json:"headers"`
+	HeadersSize int        `json:"headersSize"`
+	HTTPVersion string     `json:"httpVersion"`
+	RedirectURL string     `json:"redirectURL"`
+	Status      int        `json:"status"`
+	StatusText  string     `json:"statusText"`
+}
+
+type harTimings struct {
+	Receive float64 `json:"receive"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+}
+
+// harResponseWriter is a ResponseWriter that keeps a copy of a response so
+// that it can be captured.
+type harResponseWriter struct {
+	http.ResponseWriter
+
+	body   bytes.Buffer
+	status int
+}
+
+//
+// Private functions
+//
+
+// add captures a handled request and its response.
+func (c *harCapture) add(r *http.Request, requestBody []byte, w *harResponseWriter, start time.Time) {
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	entry := &harEntry{
+		Request: harRequest{
+			BodySize:    len(requestBody),
+			Cookies:     []harPair{},
+			Headers:     harHeaders(r.Header),
+			HeadersSize: -1,
+			HTTPVersion: r.Proto,
+			Method:      r.Method,
+			QueryString: []harPair{},
+			URL:         scheme + "://" + r.Host + r.URL.RequestURI(),
+		},
+		Response: harResponse{
+			BodySize: w.body.Len(),
+			Content: harContent{
+				MimeType: w.Header().Get("Content-Type"),
+				Size:     w.body.Len(),
+				Text:     w.body.String(),
+			},
+			Cookies:     []harPair{},
+			Headers:     harHeaders(w.Header()),
+			HeadersSize: -1,
+			HTTPVersion: r.Proto,
+			Status:      w.status,
+			StatusText:  http.StatusText(w.status),
+		},
+		StartedDateTime: start.Format("2006-01-02T15:04:05.000Z07:00"),
+		Time:            elapsed,
+		Timings:         harTimings{Wait: elapsed},
+	}
+
+	for name, values := range r.URL.Query() {
+		for _, value := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, harPair{name, value})
+		}
+	}
+	sort.SliceStable(entry.Request.QueryString, func(i, j int) bool {
+		return entry.Request.QueryString[i].Name < entry.Request.QueryString[j].Name
+	})
+
+	if len(requestBody) > 0 {
+		entry.Request.PostData = &harPostData{
+			MimeType: r.Header.Get("Content-Type"),
+			Text:     string(requestBody),
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= harMaxEntries {
+		c.entries = append(c.entries[:0], c.entries[len(c.entries)-harMaxEntries+1:]...)
+	}
+	c.entries = append(c.entries, entry)
+}
+
+// capturing checks whether traffic is being captured.
+func (c *harCapture) capturing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enabled
+}
+
+// export gets the captured traffic as HAR.
+func (c *harCapture) export() *harFile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]*harEntry, len(c.entries))
+	copy(entries, c.entries)
+
+	return &harFile{
+		Log: harLog{
+			Creator: harCreator{Name: "stripe-mock", Version: Version},
+			Entries: entries,
+			Version: "1.2",
+		},
+	}
+}
+
+// captureRequest starts capturing a request. It returns the ResponseWriter
+// that the request should be handled with and a function to call once it's
+// been handled.
+func (s *StubServer) captureRequest(w http.ResponseWriter, r *http.Request, start time.Time) (http.ResponseWriter, func()) {
+	var requestBody []byte
+	if r.Body != nil {
+		var err error
+		requestBody, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			fmt.Printf("Couldn't capture request body: %v\n", err)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	recorder := &harResponseWriter{ResponseWriter: w}
+	return recorder, func() {
+		s.har.add(r, requestBody, recorder, start)
+	}
+}
+
+// handleHAR serves the administrative endpoint at harPath.
+func (s *StubServer) handleHAR(w http.ResponseWriter, r *http.Request, start time.Time) {
+	auth := r.Header.Get("Authorization")
+	key, ok := validateAuth(auth)
+	if !ok {
+		message := fmt.Sprintf(invalidAuthorization, auth)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusUnauthorized, stripeError)
+		return
+	}
+
+	if isPublishableKey(key) {
+		stripeError := createStripeError(typeInvalidRequestError, publishableKeyNotAllowed)
+		writeResponse(w, r, start, http.StatusForbidden, stripeError)
+		return
+	}
+
+	if !s.har.capturing() {
+		stripeError := createStripeError(typeInvalidRequestError, harNotCapturing)
+		writeResponse(w, r, start, http.StatusNotFound, stripeError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		s.har.mu.Lock()
+		s.har.entries = nil
+		s.har.mu.Unlock()
+
+		writeResponse(w, r, start, http.StatusOK, map[string]interface{}{
+			"deleted": true,
+			"object":  "har",
+		})
+
+	case http.MethodGet:
+		writeResponse(w, r, start, http.StatusOK, s.har.export())
+
+	default:
+		stripeError := createStripeError(typeInvalidRequestError,
+			fmt.Sprintf(harUnsupportedMethod, r.Method, r.URL.Path))
+		writeResponse(w, r, start, http.StatusMethodNotAllowed, stripeError)
+	}
+}
+
+// harHeaders converts headers to HAR, sorted by name so that exports are
+// stable. Credentials are redacted (see harRedactedHeaders).
+func harHeaders(header http.Header) []harPair {
+	pairs := []harPair{}
+	for name, values := range header {
+		for _, value := range values {
+			if harRedactedHeaders[http.CanonicalHeaderKey(name)] {
+				value = harRedacted
+			}
+			pairs = append(pairs, harPair{name, value})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return strings.ToLower(pairs[i].Name) < strings.ToLower(pairs[j].Name)
+	})
+	return pairs
+}
+
+// Hijack lets a captured response's connection be taken over (like when a
+// dropped connection is injected). The rest of the response isn't captured.
+func (w *harResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection can't be hijacked")
+	}
+	return hijacker.Hijack()
+}
+
+func (w *harResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *harResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestHARCapture(t *testing.T) {
+	server := getStubServer(t, nil)
+	server.EnableHARCapture()
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/charges", "amount=100", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges?limit=3", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var buffer bytes.Buffer
+	assert.NoError(t, server.WriteHAR(&buffer))
+
+	var har harFile
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &har))
+	assert.Equal(t, "1.2", har.Log.Version)
+	assert.Equal(t, "stripe-mock", har.Log.Creator.Name)
+	assert.Equal(t, 2, len(har.Log.Entries))
+
+	entry := har.Log.Entries[0]
+	assert.Equal(t, "POST", entry.Request.Method)
+	assert.Equal(t, "https://stripe.com/v1/charges", entry.Request.URL)
+	assert.Contains(t, entry.Request.Headers, harPair{"Authorization", harRedacted})
+	assert.NotContains(t, buffer.String(), "sk_test_123")
+	assert.Equal(t, "amount=100", entry.Request.PostData.Text)
+	assert.Equal(t, "application/x-www-form-urlencoded", entry.Request.PostData.MimeType)
+	assert.Equal(t, http.StatusOK, entry.Response.Status)
+	assert.Equal(t, "application/json", entry.Response.Content.MimeType)
+	assert.NotEmpty(t, entry.Response.Content.Text)
+	assert.Equal(t, len(entry.Response.Content.Text), entry.Response.Content.Size)
+	assert.Contains(t, entry.Response.Headers, harPair{"Request-Id", "req_123"})
+	assert.True(t, entry.Time >= 0)
+	assert.NotEmpty(t, entry.StartedDateTime)
+
+	entry = har.Log.Entries[1]
+	assert.Nil(t, entry.Request.PostData)
+	assert.Equal(t, []harPair{{"limit", "3"}}, entry.Request.QueryString)
+}
+
+// Only the most recent requests are kept.
+func TestHARCapture_MaxEntries(t *testing.T) {
+	var capture harCapture
+	for i := 0; i < harMaxEntries+5; i++ {
+		r := httptest.NewRequest("GET", fmt.Sprintf("/v1/charges/ch_%d", i), nil)
+		capture.add(r, nil, &harResponseWriter{ResponseWriter: httptest.NewRecorder()}, time.Now())
+	}
+
+	entries := capture.export().Log.Entries
+	assert.Equal(t, harMaxEntries, len(entries))
+	assert.Equal(t, "http://example.com/v1/charges/ch_5", entries[0].Request.URL)
+	assert.Equal(t, fmt.Sprintf("http://example.com/v1/charges/ch_%d", harMaxEntries+4),
+		entries[len(entries)-1].Request.URL)
+}
+
+func TestHARCapture_Disabled(t *testing.T) {
+	server := getStubServer(t, nil)
+
+	resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, len(server.har.export().Log.Entries))
+
+	resp, _ = sendRequestToServer(t, server, "GET", harPath, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandleHAR(t *testing.T) {
+	server := getStubServer(t, nil)
+	server.EnableHARCapture()
+
+	sendRequestToServer(t, server, "GET", "/v1/charges", "", getDefaultHeaders())
+
+	// Exporting isn't captured itself
+	for i := 0; i < 2; i++ {
+		resp, body := sendRequestToServer(t, server, "GET", harPath, "", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var har harFile
+		assert.NoError(t, json.Unmarshal(body, &har))
+		assert.Equal(t, 1, len(har.Log.Entries))
+	}
+
+	resp, _ := sendRequestToServer(t, server, "GET", harPath, "", getTenantHeaders("pk_test_123"))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "POST", harPath, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "DELETE", harPath, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, len(server.har.export().Log.Entries))
+}
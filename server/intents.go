@@ -0,0 +1,517 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//
+// Private constants
+//
+
+// Actions that a state machine is invoked with in addition to the RPC-style
+// ones taken from the path (e.g. `confirm`).
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+)
+
+// Statuses shared by PaymentIntents and SetupIntents.
+const (
+	intentStatusCanceled              = "canceled"
+	intentStatusProcessing            = "processing"
+	intentStatusRequiresAction        = "requires_action"
+	intentStatusRequiresCapture       = "requires_capture"
+	intentStatusRequiresConfirmation  = "requires_confirmation"
+	intentStatusRequiresPaymentMethod = "requires_payment_method"
+	intentStatusSucceeded             = "succeeded"
+)
+
+const (
+	codePaymentIntentUnexpectedState = "payment_intent_unexpected_state"
+	codeSetupIntentUnexpectedState   = "setup_intent_unexpected_state"
+
+	intentAuthenticationFailure = "We are unable to authenticate your " +
+		"payment method. Please choose a different payment method and try " +
+		"again. The provided PaymentMethod has failed authentication; the %s " +
+		"requires a new payment method."
+
+	intentMissingPaymentMethod = "You cannot confirm this %s because it's " +
+		"missing a payment method. Update the %s with a payment method and " +
+		"then confirm it again."
+
+	intentUnexpectedState = "You cannot %s this %s because it has a status " +
+		"of %s. Only a %s with one of the following statuses may be %s: %s."
+)
+
+//
+// Private values
+//
+
+// authenticationRequiredPaymentMethods are test payment methods and tokens
+// that always require 3-D Secure authentication when an intent using them is
+// confirmed.
+var authenticationRequiredPaymentMethods = map[string]bool{
+	"pm_card_authenticationRequired":        true,
+	"pm_card_authenticationRequiredOnSetup": true,
+	"pm_card_threeDSecure2Required":         true,
+	"pm_card_threeDSecureRequired":          true,
+	"tok_threeDSecure2Required":             true,
+	"tok_threeDSecureRequired":              true,
+}
+
+// authenticationRequiredLast4 are the last four digits of test card numbers
+// that always require 3-D Secure authentication (e.g. `4000002760003184`).
+// Payment methods created with one of these numbers in stateful mode also
+// require authentication.
+var authenticationRequiredLast4 = map[string]bool{
+	"3063": true,
+	"3155": true,
+	"3184": true,
+	"3220": true,
+}
+
+// delayedPaymentMethodTypes are payment method types for which a successfully
+// confirmed PaymentIntent goes to `processing` rather than `succeeded`.
+var delayedPaymentMethodTypes = map[string]bool{
+	"acss_debit":      true,
+	"au_becs_debit":   true,
+	"bacs_debit":      true,
+	"sepa_debit":      true,
+	"us_bank_account": true,
+}
+
+// intentKinds describes the PaymentIntent and SetupIntent objects, which share
+// most of their lifecycle.
+var intentKinds = map[string]*intentKind{
+	"payment_intent": {
+		authenticationFailureCode: "payment_intent_authentication_failure",
+		displayName:               "PaymentIntent",
+		errorCode:                 codePaymentIntentUnexpectedState,
+		failureEventType:          "payment_intent.payment_failed",
+		lastErrorField:            "last_payment_error",
+		redirectParam:             "payment_intent",
+		statusEventTypes: map[string]string{
+			intentStatusCanceled:        "payment_intent.canceled",
+			intentStatusProcessing:      "payment_intent.processing",
+			intentStatusRequiresAction:  "payment_intent.requires_action",
+			intentStatusRequiresCapture: "payment_intent.amount_capturable_updated",
+			intentStatusSucceeded:       "payment_intent.succeeded",
+		},
+		validStatuses: map[string][]string{
+			"cancel": {
+				intentStatusRequiresPaymentMethod,
+				intentStatusRequiresCapture,
+				intentStatusRequiresConfirmation,
+				intentStatusRequiresAction,
+				intentStatusProcessing,
+			},
+			"capture": {
+				intentStatusRequiresCapture,
+			},
+			"confirm": {
+				intentStatusRequiresPaymentMethod,
+				intentStatusRequiresConfirmation,
+				intentStatusRequiresAction,
+			},
+		},
+	},
+	"setup_intent": {
+		authenticationFailureCode: "setup_intent_authentication_failure",
+		displayName:               "SetupIntent",
+		errorCode:                 codeSetupIntentUnexpectedState,
+		failureEventType:          "setup_intent.setup_failed",
+		lastErrorField:            "last_setup_error",
+		redirectParam:             "setup_intent",
+		statusEventTypes: map[string]string{
+			intentStatusCanceled:       "setup_intent.canceled",
+			intentStatusRequiresAction: "setup_intent.requires_action",
+			intentStatusSucceeded:      "setup_intent.succeeded",
+		},
+		validStatuses: map[string][]string{
+			"cancel": {
+				intentStatusRequiresPaymentMethod,
+				intentStatusRequiresConfirmation,
+				intentStatusRequiresAction,
+			},
+			"confirm": {
+				intentStatusRequiresPaymentMethod,
+				intentStatusRequiresConfirmation,
+				intentStatusRequiresAction,
+			},
+		},
+	},
+}
+
+// pastParticiples is used to build error messages for actions.
+var pastParticiples = map[string]string{
+	"cancel":  "canceled",
+	"capture": "captured",
+	"confirm": "confirmed",
+}
+
+// stateMachines maps object types to the function that transitions them in
+// response to an action. Object types without a state machine are stored
+// with only the request's data merged in.
+var stateMachines = map[string]stateMachine{
+	"checkout.session":          transitionCheckoutSession,
+	"payment_intent":            transitionIntent,
+	"payment_method":            transitionPaymentMethod,
+	"setup_intent":              transitionIntent,
+	"v2.core.event_destination": transitionEventDestination,
+}
+
+//
+// Private types
+//
+
+// intentKind describes the parts of the PaymentIntent and SetupIntent
+// lifecycles that differ between the two.
+type intentKind struct {
+	// authenticationFailureCode is the code of the error set on an intent
+	// when its 3-D Secure authentication fails.
+	authenticationFailureCode string
+
+	// displayName is the name of the object as it appears in error messages.
+	displayName string
+
+	// errorCode is the code of the error returned for illegal transitions.
+	errorCode string
+
+	// failureEventType is the type of the event created when a payment or
+	// setup attempt fails, like `payment_intent.payment_failed`.
+	failureEventType string
+
+	// lastErrorField is the field holding the intent's last error, like
+	// `last_payment_error`.
+	lastErrorField string
+
+	// redirectParam is the name of the query parameter that carries the
+	// intent's ID when redirecting to a `return_url`, like `payment_intent`.
+	redirectParam string
+
+	// statusEventTypes maps statuses to the type of the event created when
+	// an intent moves to them, like `payment_intent.succeeded`.
+	statusEventTypes map[string]string
+
+	// validStatuses maps actions to the statuses from which they can be
+	// taken.
+	validStatuses map[string][]string
+}
+
+// stateMachine is a function that transitions an object for an action like
+// `create`, `update`, or `confirm`. It mutates the object in place and
+// returns an error if the action isn't allowed.
+type stateMachine func(s *StubServer, t *transition) *ResponseError
+
+// transition is a single action being applied to an object.
+type transition struct {
+	// account is the connected account that the request was made on behalf
+	// of, if any. Links back to stripe-mock carry it so that they act on the
+	// account's objects.
+	account string
+
+	action string
+
+	// baseURL is the scheme and host that the request was made to, like
+	// `http://localhost:12111`. It's used to build links back to stripe-mock.
+	baseURL string
+
+	object      map[string]interface{}
+	objectType  string
+	requestData map[string]interface{}
+
+	// store is the store that the request acts on.
+	store *ObjectStore
+
+	// tenant is the tenant that the request's API key belongs to. Like
+	// account, links back to stripe-mock carry it.
+	tenant string
+}
+
+//
+// Private functions
+//
+
+// transitionObject runs the state machine for the object's type, if it has
+// one.
+func (s *StubServer) transitionObject(sr *statefulRequest, object map[string]interface{}, action string) *ResponseError {
+	objectType, _ := object["object"].(string)
+
+	machine, ok := stateMachines[objectType]
+	if !ok {
+		return nil
+	}
+
+	return machine(s, &transition{
+		account:     sr.stripeAccount,
+		action:      action,
+		baseURL:     requestBaseURL(sr.request),
+		object:      object,
+		objectType:  objectType,
+		requestData: sr.requestData,
+		store:       sr.store,
+		tenant:      sr.tenant,
+	})
+}
+
+// transitionIntent is the state machine for PaymentIntents and SetupIntents:
+//
+//	requires_payment_method -> requires_confirmation -> requires_action
+//	    -> processing/requires_capture -> succeeded
+//
+// Any status but `succeeded` may also move to `canceled`.
+func transitionIntent(s *StubServer, t *transition) *ResponseError {
+	kind := intentKinds[t.objectType]
+	status, _ := t.object["status"].(string)
+
+	if validStatuses, ok := kind.validStatuses[t.action]; ok {
+		if !containsString(validStatuses, status) {
+			return createIntentUnexpectedStateError(kind, t, status, validStatuses)
+		}
+	}
+
+	switch t.action {
+	case actionCreate:
+		// Fixtures come with values for fields like `canceled_at` that only
+		// make sense later in an intent's lifecycle, so reset them.
+		t.object["cancellation_reason"] = nil
+		t.object["next_action"] = nil
+		if _, ok := t.object["client_secret"]; ok {
+			t.object["client_secret"] = newClientSecret(t.object["id"].(string))
+		}
+		t.object[kind.lastErrorField] = nil
+		if t.objectType == "payment_intent" {
+			t.object["amount_received"] = 0
+			t.object["canceled_at"] = nil
+		}
+
+		if paymentMethod, _ := t.object["payment_method"].(string); paymentMethod != "" {
+			t.object["status"] = intentStatusRequiresConfirmation
+		} else {
+			t.object["status"] = intentStatusRequiresPaymentMethod
+		}
+
+		if confirm, _ := t.requestData["confirm"].(bool); confirm {
+			return confirmIntent(s, kind, t)
+		}
+
+	case actionUpdate:
+		paymentMethod, _ := t.object["payment_method"].(string)
+		if status == intentStatusRequiresPaymentMethod && paymentMethod != "" {
+			t.object["status"] = intentStatusRequiresConfirmation
+		}
+
+	case "cancel":
+		t.object["status"] = intentStatusCanceled
+		t.object["next_action"] = nil
+		t.object["cancellation_reason"] = t.requestData["cancellation_reason"]
+		if t.objectType == "payment_intent" {
+			t.object["canceled_at"] = time.Now().Unix()
+		}
+
+	case "capture":
+		amount := t.object["amount"]
+		if amountToCapture, ok := t.requestData["amount_to_capture"]; ok {
+			amount = amountToCapture
+		}
+		t.object["amount_received"] = amount
+		t.object["status"] = intentStatusSucceeded
+
+	case "confirm":
+		return confirmIntent(s, kind, t)
+	}
+
+	return nil
+}
+
+// transitionPaymentMethod records the last four digits of a card number sent
+// when creating a payment method so that later confirmations can recognize
+// test cards that require authentication.
+func transitionPaymentMethod(s *StubServer, t *transition) *ResponseError {
+	if t.action != actionCreate {
+		return nil
+	}
+
+	cardParams, ok := t.requestData["card"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	number, ok := cardParams["number"].(string)
+	if !ok || len(number) < 4 {
+		return nil
+	}
+
+	card, ok := t.object["card"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	card["last4"] = number[len(number)-4:]
+
+	return nil
+}
+
+// confirmIntent confirms a PaymentIntent or SetupIntent. Intents confirmed
+// with a payment method that requires authentication go to `requires_action`
+// with a `next_action`; confirming again from there simulates that the
+// authentication was completed successfully.
+func confirmIntent(s *StubServer, kind *intentKind, t *transition) *ResponseError {
+	if paymentMethod, ok := t.requestData["payment_method"].(string); ok {
+		t.object["payment_method"] = paymentMethod
+	}
+
+	paymentMethod, _ := t.object["payment_method"].(string)
+	if paymentMethod == "" {
+		message := fmt.Sprintf(intentMissingPaymentMethod, kind.displayName, kind.displayName)
+		return createIntentError(kind, t, message)
+	}
+
+	status, _ := t.object["status"].(string)
+	if status != intentStatusRequiresAction && requiresAuthentication(t.store, paymentMethod) {
+		t.object["status"] = intentStatusRequiresAction
+		t.object["next_action"] = buildNextAction(t)
+		return nil
+	}
+
+	completeIntent(t.store, t.object)
+	return nil
+}
+
+// completeIntent moves an intent that's been successfully confirmed (and
+// authenticated, if necessary) to its next status.
+func completeIntent(store *ObjectStore, intent map[string]interface{}) {
+	paymentMethod, _ := intent["payment_method"].(string)
+
+	intent["next_action"] = nil
+
+	switch {
+	case intent["object"] == "setup_intent":
+		intent["status"] = intentStatusSucceeded
+
+	case intent["capture_method"] == "manual":
+		intent["status"] = intentStatusRequiresCapture
+
+	case isDelayedPaymentMethod(store, paymentMethod):
+		intent["status"] = intentStatusProcessing
+
+	default:
+		intent["amount_received"] = intent["amount"]
+		intent["status"] = intentStatusSucceeded
+	}
+}
+
+// failIntentAuthentication moves an intent whose authentication failed back
+// to `requires_payment_method` with an error describing what happened, which
+// is how the real API handles a failed 3-D Secure challenge.
+func failIntentAuthentication(intent map[string]interface{}) {
+	kind := intentKinds[intent["object"].(string)]
+
+	intent["next_action"] = nil
+	intent["payment_method"] = nil
+	intent["status"] = intentStatusRequiresPaymentMethod
+	intent[kind.lastErrorField] = map[string]interface{}{
+		"code": kind.authenticationFailureCode,
+		"message": fmt.Sprintf(intentAuthenticationFailure,
+			kind.displayName),
+		"type": "card_error",
+	}
+}
+
+// buildNextAction builds the `next_action` of an intent that requires
+// authentication. If a `return_url` was sent with the request, the customer
+// is expected to be redirected to it after authenticating, otherwise the
+// intent is expected to be handled by Stripe.js.
+//
+// Either way, the authentication URL points to a page served by stripe-mock
+// itself (see handleAuthenticatePage).
+func buildNextAction(t *transition) map[string]interface{} {
+	authenticateURL := pageURL(t, authenticatePath+t.object["id"].(string))
+
+	if returnURL, ok := t.requestData["return_url"].(string); ok {
+		return map[string]interface{}{
+			"type": "redirect_to_url",
+			"redirect_to_url": map[string]interface{}{
+				"return_url": returnURL,
+				"url":        authenticateURL,
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"type": "use_stripe_sdk",
+		"use_stripe_sdk": map[string]interface{}{
+			"stripe_js": authenticateURL,
+			"type":      "three_d_secure_redirect",
+		},
+	}
+}
+
+// containsString checks whether a string is in a slice of strings.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// createIntentError creates an error for an intent that can't be transitioned.
+// Like the real API, the error includes the intent in its current state.
+func createIntentError(kind *intentKind, t *transition, message string) *ResponseError {
+	stripeError := createStripeError(typeInvalidRequestError, message)
+	stripeError.ErrorInfo.Code = kind.errorCode
+
+	if t.objectType == "payment_intent" {
+		stripeError.ErrorInfo.PaymentIntent = t.object
+	} else {
+		stripeError.ErrorInfo.SetupIntent = t.object
+	}
+
+	return stripeError
+}
+
+// createIntentUnexpectedStateError creates the error returned when an action
+// is taken on an intent from a status that doesn't allow it.
+func createIntentUnexpectedStateError(kind *intentKind, t *transition, status string, validStatuses []string) *ResponseError {
+	message := fmt.Sprintf(intentUnexpectedState,
+		t.action, kind.displayName, status, kind.displayName,
+		pastParticiples[t.action], strings.Join(validStatuses, ", "))
+	return createIntentError(kind, t, message)
+}
+
+// isDelayedPaymentMethod checks whether a payment method is of a type whose
+// payments don't succeed immediately. Only payment methods that were stored
+// in stateful mode can be identified as such.
+func isDelayedPaymentMethod(store *ObjectStore, id string) bool {
+	paymentMethod, ok := store.Get(id)
+	if !ok {
+		return false
+	}
+
+	paymentMethodType, _ := paymentMethod["type"].(string)
+	return delayedPaymentMethodTypes[paymentMethodType]
+}
+
+// requiresAuthentication checks whether confirming an intent with the given
+// payment method should require 3-D Secure authentication.
+func requiresAuthentication(store *ObjectStore, id string) bool {
+	if authenticationRequiredPaymentMethods[id] {
+		return true
+	}
+
+	paymentMethod, ok := store.Get(id)
+	if !ok {
+		return false
+	}
+
+	card, ok := paymentMethod["card"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	last4, _ := card["last4"].(string)
+	return authenticationRequiredLast4[last4]
+}
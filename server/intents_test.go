@@ -0,0 +1,186 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestPaymentIntentLifecycle(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent := decodeObject(t, body)
+	id := intent["id"].(string)
+	assert.Equal(t, "requires_payment_method", intent["status"])
+	assert.Nil(t, intent["next_action"])
+	assert.Nil(t, intent["canceled_at"])
+
+	// Adding a payment method means the intent can be confirmed
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id,
+		"payment_method=pm_card_visa", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "requires_confirmation", decodeObject(t, body)["status"])
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id+"/confirm",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent = decodeObject(t, body)
+	assert.Equal(t, "succeeded", intent["status"])
+	assert.Equal(t, 500.0, intent["amount_received"])
+
+	// A succeeded intent can't be canceled
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id+"/cancel",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "payment_intent_unexpected_state", errorInfo["code"])
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t, "You cannot cancel this PaymentIntent because it has a "+
+		"status of succeeded. Only a PaymentIntent with one of the following "+
+		"statuses may be canceled: requires_payment_method, requires_capture, "+
+		"requires_confirmation, requires_action, processing.", errorInfo["message"])
+	assert.Equal(t, id, errorInfo["payment_intent"].(map[string]interface{})["id"])
+}
+
+func TestPaymentIntentManualCapture(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&capture_method=manual&confirm=true&payment_method=pm_card_visa",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent := decodeObject(t, body)
+	id := intent["id"].(string)
+	assert.Equal(t, "requires_capture", intent["status"])
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id+"/capture",
+		"amount_to_capture=400", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent = decodeObject(t, body)
+	assert.Equal(t, "succeeded", intent["status"])
+	assert.Equal(t, 400.0, intent["amount_received"])
+
+	// Capturing twice isn't allowed
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id+"/capture",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// Only one of several concurrent captures of an intent succeeds.
+func TestPaymentIntentConcurrentCapture(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&capture_method=manual&confirm=true&payment_method=pm_card_visa",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	statuses := make([]int, 10)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, _ := sendRequestToServer(t, server, "POST",
+				"/v1/payment_intents/"+id+"/capture", "", getDefaultHeaders())
+			statuses[i] = resp.StatusCode
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, status := range statuses {
+		if status == http.StatusOK {
+			succeeded++
+		} else {
+			assert.Equal(t, http.StatusBadRequest, status)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
+func TestPaymentIntentRequiresAction(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&confirm=true&payment_method=pm_card_threeDSecure2Required",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent := decodeObject(t, body)
+	id := intent["id"].(string)
+	assert.Equal(t, "requires_action", intent["status"])
+	nextAction := intent["next_action"].(map[string]interface{})
+	assert.Equal(t, "use_stripe_sdk", nextAction["type"])
+
+	// Confirming again simulates a completed authentication
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id+"/confirm",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent = decodeObject(t, body)
+	assert.Equal(t, "succeeded", intent["status"])
+	assert.Nil(t, intent["next_action"])
+
+	// With a return URL, the customer is sent to a redirect
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&confirm=true&payment_method=pm_card_threeDSecure2Required&return_url=https://example.com/return",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent = decodeObject(t, body)
+	nextAction = intent["next_action"].(map[string]interface{})
+	assert.Equal(t, "redirect_to_url", nextAction["type"])
+	redirect := nextAction["redirect_to_url"].(map[string]interface{})
+	assert.Equal(t, "https://example.com/return", redirect["return_url"])
+}
+
+func TestPaymentIntentMissingPaymentMethod(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id+"/confirm",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "payment_intent_unexpected_state", errorInfo["code"])
+	assert.Contains(t, errorInfo["message"], "missing a payment method")
+}
+
+func TestSetupIntentLifecycle(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/setup_intents",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent := decodeObject(t, body)
+	id := intent["id"].(string)
+	assert.Equal(t, "requires_payment_method", intent["status"])
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/setup_intents/"+id+"/confirm",
+		"payment_method=pm_card_visa", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "succeeded", decodeObject(t, body)["status"])
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/setup_intents/"+id+"/cancel",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "setup_intent_unexpected_state", errorInfo["code"])
+	assert.Equal(t, id, errorInfo["setup_intent"].(map[string]interface{})["id"])
+}
+
+func TestStubServer_StatelessIntents(t *testing.T) {
+	// Without stateful mode, actions just return the fixture
+	resp, body := sendRequest(t, "POST", "/v1/payment_intents/pi_123/cancel",
+		"", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "requires_payment_method", decodeObject(t, body)["status"])
+}
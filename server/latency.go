@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// Latency describes how long stripe-mock waits before handling a request.
+type Latency struct {
+	// Distribution is the distribution that delays are drawn from: `fixed`
+	// (the default), `uniform`, or `normal`.
+	Distribution string `json:"distribution"`
+
+	// MaxMilliseconds is the longest delay of a `uniform` distribution.
+	MaxMilliseconds float64 `json:"max_milliseconds"`
+
+	// Milliseconds is the delay of a `fixed` distribution, the shortest delay
+	// of a `uniform` distribution, or the mean delay of a `normal`
+	// distribution.
+	Milliseconds float64 `json:"milliseconds"`
+
+	// StddevMilliseconds is the standard deviation of a `normal`
+	// distribution. Delays drawn from it are never negative.
+	StddevMilliseconds float64 `json:"stddev_milliseconds"`
+}
+
+// LatencyProfile configures the latency that stripe-mock adds to requests to
+// simulate a slow API.
+type LatencyProfile struct {
+	// Latency is the latency added to every operation that isn't listed in
+	// Operations.
+	Latency
+
+	// Operations overrides the latency added to individual operations, keyed
+	// by method and path like `POST /v1/payment_intents`.
+	Operations map[string]Latency `json:"operations"`
+}
+
+//
+// Public functions
+//
+
+// LoadLatencyProfile loads a latency profile from a JSON file. For example:
+//
+//	{"distribution": "normal", "milliseconds": 300, "stddev_milliseconds": 100, "operations": {"POST /v1/payment_intents/{intent}/confirm": {"milliseconds": 2000}}}
+func LoadLatencyProfile(path string) (*LatencyProfile, error) {
+	if !isJSONFile(path) {
+		return nil, fmt.Errorf("Latency profile should come from a JSON file")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading latency profile: %v", err)
+	}
+
+	var profile LatencyProfile
+	err = json.Unmarshal(data, &profile)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding latency profile: %v", err)
+	}
+
+	return &profile, nil
+}
+
+// SetLatencyProfile configures the latency that stripe-mock adds to
+// requests. Individual requests can override it with a `Stripe-Mock-Delay`
+// header.
+func (s *StubServer) SetLatencyProfile(profile *LatencyProfile) error {
+	err := profile.Latency.validate()
+	if err != nil {
+		return err
+	}
+
+	for operation, latency := range profile.Operations {
+		if !s.isOperation(operation) {
+			return fmt.Errorf("'%s' isn't an operation like `POST /v1/payment_intents`", operation)
+		}
+
+		err := latency.validate()
+		if err != nil {
+			return fmt.Errorf("latency for '%s': %v", operation, err)
+		}
+	}
+
+	s.latency.mu.Lock()
+	defer s.latency.mu.Unlock()
+
+	s.latency.profile = profile
+	return nil
+}
+
+//
+// Private constants
+//
+
+const (
+	latencyFixed   = "fixed"
+	latencyNormal  = "normal"
+	latencyUniform = "uniform"
+)
+
+// maxDelay is the longest delay that can be requested with
+// `Stripe-Mock-Delay` or configured in a latency profile so that a typo can't
+// hang a test run.
+const maxDelay = 10 * time.Minute
+
+const invalidDelayHeader = "The `Stripe-Mock-Delay` header must be a " +
+	"number of milliseconds from 0 to %v, but was '%s'."
+
+//
+// Private types
+//
+
+// latencySimulator holds the configured latency profile. It can be replaced
+// while requests are being served, so access is synchronized.
+type latencySimulator struct {
+	mu      sync.Mutex
+	profile *LatencyProfile
+}
+
+//
+// Private functions
+//
+
+// delayRequest waits before a request to a route is handled. The delay comes
+// from the request's `Stripe-Mock-Delay` header if it has one, or is drawn
+// from the configured latency profile otherwise. Waiting stops early if the
+// client gives up.
+//
+// Returns an error suitable for sending back to the client (with a status of
+// 400) if the header is invalid.
+func (s *StubServer) delayRequest(r *http.Request, route *stubServerRoute) *ResponseError {
+	delay, stripeError := s.requestDelay(r, route)
+	if stripeError != nil {
+		return stripeError
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-r.Context().Done():
+	case <-timer.C:
+	}
+	return nil
+}
+
+// requestDelay decides how long to wait before handling a request to a route.
+func (s *StubServer) requestDelay(r *http.Request, route *stubServerRoute) (time.Duration, *ResponseError) {
+	if header := r.Header.Get("Stripe-Mock-Delay"); header != "" {
+		milliseconds, err := strconv.ParseFloat(header, 64)
+		if err != nil || !isValidDelay(milliseconds) {
+			return 0, createStripeError(typeInvalidRequestError,
+				fmt.Sprintf(invalidDelayHeader, maxDelay.Milliseconds(), header))
+		}
+		return time.Duration(milliseconds * float64(time.Millisecond)), nil
+	}
+
+	s.latency.mu.Lock()
+	profile := s.latency.profile
+	s.latency.mu.Unlock()
+
+	if profile == nil {
+		return 0, nil
+	}
+
+	latency := profile.Latency
+	if operationLatency, ok := profile.Operations[r.Method+" "+string(route.path)]; ok {
+		latency = operationLatency
+	}
+
+	return latency.draw(), nil
+}
+
+// draw draws a delay from a latency's distribution.
+func (l Latency) draw() time.Duration {
+	var milliseconds float64
+
+	switch l.Distribution {
+	case latencyNormal:
+		milliseconds = math.Max(0, l.Milliseconds+rand.NormFloat64()*l.StddevMilliseconds)
+	case latencyUniform:
+		milliseconds = l.Milliseconds + rand.Float64()*(l.MaxMilliseconds-l.Milliseconds)
+	default:
+		milliseconds = l.Milliseconds
+	}
+
+	// A normal distribution's tail can reach past the longest delay.
+	milliseconds = math.Min(milliseconds, float64(maxDelay.Milliseconds()))
+
+	return time.Duration(milliseconds * float64(time.Millisecond))
+}
+
+// validate makes sure that a latency's distribution makes sense.
+func (l Latency) validate() error {
+	for _, milliseconds := range []float64{l.Milliseconds, l.MaxMilliseconds, l.StddevMilliseconds} {
+		if !isValidDelay(milliseconds) {
+			return fmt.Errorf("latencies should be numbers of milliseconds from 0 to %v, but got %v",
+				maxDelay.Milliseconds(), milliseconds)
+		}
+	}
+
+	switch l.Distribution {
+	case "", latencyFixed, latencyNormal:
+	case latencyUniform:
+		if l.MaxMilliseconds < l.Milliseconds {
+			return fmt.Errorf("max_milliseconds of a uniform distribution can't be less than milliseconds")
+		}
+	default:
+		return fmt.Errorf("distribution should be one of fixed, normal, or uniform, but was '%s'",
+			l.Distribution)
+	}
+
+	return nil
+}
+
+// isValidDelay checks that a delay in milliseconds is a number from 0 to
+// maxDelay. The range is checked before converting to a time.Duration, which
+// would overflow for large values, and NaN fails every comparison so it's
+// checked for separately.
+func isValidDelay(milliseconds float64) bool {
+	return !math.IsNaN(milliseconds) && !math.IsInf(milliseconds, 0) &&
+		milliseconds >= 0 && milliseconds <= float64(maxDelay.Milliseconds())
+}
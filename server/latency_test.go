@@ -0,0 +1,112 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestLoadLatencyProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "latency.json")
+	err := os.WriteFile(path,
+		[]byte(`{"distribution": "normal", "milliseconds": 300, "stddev_milliseconds": 100, "operations": {"POST /v1/customers": {"milliseconds": 2000}}}`), 0o600)
+	assert.NoError(t, err)
+
+	profile, err := LoadLatencyProfile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, latencyNormal, profile.Distribution)
+	assert.Equal(t, 300.0, profile.Milliseconds)
+	assert.Equal(t, 100.0, profile.StddevMilliseconds)
+	assert.Equal(t, 2000.0, profile.Operations["POST /v1/customers"].Milliseconds)
+
+	_, err = LoadLatencyProfile(filepath.Join(t.TempDir(), "latency.yaml"))
+	assert.Error(t, err)
+}
+
+func TestSetLatencyProfile(t *testing.T) {
+	server := getStubServer(t, nil)
+
+	assert.NoError(t, server.SetLatencyProfile(&LatencyProfile{
+		Latency:    Latency{Distribution: latencyUniform, Milliseconds: 10, MaxMilliseconds: 20},
+		Operations: map[string]Latency{"POST /v1/customers": {Milliseconds: 5}},
+	}))
+
+	assert.Error(t, server.SetLatencyProfile(&LatencyProfile{
+		Latency: Latency{Milliseconds: -1},
+	}))
+	for _, milliseconds := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), 1e300} {
+		assert.Error(t, server.SetLatencyProfile(&LatencyProfile{
+			Latency: Latency{Milliseconds: milliseconds},
+		}))
+		assert.Error(t, server.SetLatencyProfile(&LatencyProfile{
+			Latency: Latency{Distribution: latencyNormal, StddevMilliseconds: milliseconds},
+		}))
+		assert.Error(t, server.SetLatencyProfile(&LatencyProfile{
+			Operations: map[string]Latency{"POST /v1/customers": {Milliseconds: milliseconds}},
+		}))
+	}
+	assert.Error(t, server.SetLatencyProfile(&LatencyProfile{
+		Latency: Latency{Distribution: "exponential"},
+	}))
+	assert.Error(t, server.SetLatencyProfile(&LatencyProfile{
+		Latency: Latency{Distribution: latencyUniform, Milliseconds: 20, MaxMilliseconds: 10},
+	}))
+	assert.Error(t, server.SetLatencyProfile(&LatencyProfile{
+		Operations: map[string]Latency{"POST /v1/nonexistent": {Milliseconds: 5}},
+	}))
+}
+
+func TestLatencyDraw(t *testing.T) {
+	assert.Equal(t, 250*time.Millisecond, Latency{Milliseconds: 250}.draw())
+
+	for i := 0; i < 100; i++ {
+		delay := Latency{Distribution: latencyUniform, Milliseconds: 10, MaxMilliseconds: 20}.draw()
+		assert.True(t, delay >= 10*time.Millisecond && delay <= 20*time.Millisecond)
+
+		delay = Latency{Distribution: latencyNormal, Milliseconds: 1, StddevMilliseconds: 100}.draw()
+		assert.True(t, delay >= 0)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	server := getStubServer(t, nil)
+	assert.NoError(t, server.SetLatencyProfile(&LatencyProfile{
+		Operations: map[string]Latency{"POST /v1/customers": {Milliseconds: 50}},
+	}))
+
+	start := time.Now()
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/customers", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	// The header overrides the profile
+	headers := getDefaultHeaders()
+	headers["Stripe-Mock-Delay"] = "0"
+	start = time.Now()
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/customers", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	headers["Stripe-Mock-Delay"] = "60"
+	start = time.Now()
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, time.Since(start) >= 60*time.Millisecond)
+}
+
+func TestLatency_InvalidHeader(t *testing.T) {
+	for _, delay := range []string{"soon", "-5", "3600000", "NaN", "Inf", "-Inf", "1e300"} {
+		headers := getDefaultHeaders()
+		headers["Stripe-Mock-Delay"] = delay
+
+		resp, body := sendRequest(t, "GET", "/v1/charges", "", headers, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+		assert.Contains(t, errorInfo["message"], "Stripe-Mock-Delay")
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"github.com/stripe/stripe-mock/spec"
+)
+
+//
+// Private constants
+//
+
+// defaultListLimit is the number of objects returned by a list endpoint when
+// no `limit` is given.
+const defaultListLimit = 10
+
+//
+// Private values
+//
+
+// listFilterParams are list parameters that filter objects on a field of the
+// same name, like `customer` or `status`. `created` and `price` are handled
+// separately because they don't compare to a field directly.
+var listFilterParams = []string{"customer", "status", "type"}
+
+//
+// Private functions
+//
+
+// applyStatefulList fills a generated list with stored objects of the type
+// that the list contains, applying any filters and pagination parameters
+// sent with the request. Lists of objects that can't be created through the
+// API (e.g. country specs) keep their stateless behavior.
+//
+// Returns an error suitable for sending back to the client (with a status of
+// 400) if a pagination cursor references an object that doesn't exist.
+func (s *StubServer) applyStatefulList(sr *statefulRequest, generated map[string]interface{}) (map[string]interface{}, *ResponseError) {
+	objectType := s.listObjectType(sr.responseSchema)
+	if objectType == "" || !s.creatableResources[objectType] {
+		return generated, nil
+	}
+
+	var matches []interface{}
+	totalCount := 0
+	for _, object := range sr.store.List(objectType) {
+		if matchesListFilters(object, sr.requestData) {
+			matches = append(matches, object)
+			totalCount++
+		} else if isListCursor(object, sr.requestData) {
+			matches = append(matches, object)
+		}
+	}
+
+	data, hasMore, stripeError := paginateList(objectType, matches, sr.requestData)
+	if stripeError != nil {
+		return nil, stripeError
+	}
+
+	generated["data"] = data
+	generated["has_more"] = hasMore
+	generated["url"] = sr.request.URL.Path
+	if _, ok := generated["total_count"]; ok {
+		generated["total_count"] = totalCount
+	}
+	return generated, nil
+}
+
+// listObjectType gets the resource ID of the objects in a list schema, like
+// `customer` for the list returned by `GET /v1/customers`.
+func (s *StubServer) listObjectType(schema *spec.Schema) string {
+	if schema != nil && schema.Ref != "" {
+		schema = s.spec.Components.Schemas[definitionFromJSONPointer(schema.Ref)]
+	}
+	if schema == nil {
+		return ""
+	}
+
+	data, ok := schema.Properties["data"]
+	if !ok || data.Items == nil {
+		return ""
+	}
+
+	return s.resolveResourceID(data.Items)
+}
+
+// matchesCreated checks whether an object's `created` timestamp matches a
+// `created` list parameter, which is either an exact timestamp or a map of
+// range operators (`gt`, `gte`, `lt`, and `lte`) as produced by the coercer.
+func matchesCreated(created interface{}, param interface{}) bool {
+	timestamp, ok := toInt64(created)
+	if !ok {
+		return false
+	}
+
+	if exact, ok := toInt64(param); ok {
+		return timestamp == exact
+	}
+
+	operators, ok := param.(map[string]interface{})
+	if !ok {
+		return true
+	}
+
+	for operator, value := range operators {
+		bound, ok := toInt64(value)
+		if !ok {
+			continue
+		}
+
+		switch operator {
+		case "gt":
+			ok = timestamp > bound
+		case "gte":
+			ok = timestamp >= bound
+		case "lt":
+			ok = timestamp < bound
+		case "lte":
+			ok = timestamp <= bound
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesListFilters checks whether an object matches the filters sent with a
+// list request.
+func matchesListFilters(object map[string]interface{}, requestData map[string]interface{}) bool {
+	for _, param := range listFilterParams {
+		value, ok := requestData[param].(string)
+		if !ok {
+			continue
+		}
+
+		// Subscriptions can be listed with any status using `status=all`.
+		if param == "status" && value == "all" {
+			continue
+		}
+
+		if referenceID(object[param]) != value {
+			return false
+		}
+	}
+
+	// Like the real API, canceled subscriptions are only listed when they're
+	// asked for explicitly.
+	if _, ok := requestData["status"]; !ok &&
+		object["object"] == "subscription" && object["status"] == "canceled" {
+		return false
+	}
+
+	if created, ok := requestData["created"]; ok && !matchesCreated(object["created"], created) {
+		return false
+	}
+
+	if price, ok := requestData["price"].(string); ok && !matchesPrice(object, price) {
+		return false
+	}
+
+	return true
+}
+
+// matchesPrice checks whether an object has the given price, either directly
+// (like an invoice item) or in one of its items (like a subscription).
+func matchesPrice(object map[string]interface{}, price string) bool {
+	if referenceID(object["price"]) == price {
+		return true
+	}
+
+	items, ok := object["items"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	data, _ := items["data"].([]interface{})
+	for _, item := range data {
+		itemMap, ok := item.(map[string]interface{})
+		if ok && referenceID(itemMap["price"]) == price {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isListCursor checks whether an object is the one that a list request's
+// `starting_after` (or otherwise `ending_before`) cursor references. Cursors
+// are kept in lists even if they don't match the request's filters so that
+// pages can be found relative to them.
+func isListCursor(object map[string]interface{}, requestData map[string]interface{}) bool {
+	cursor, ok := requestData["starting_after"].(string)
+	if !ok {
+		cursor, ok = requestData["ending_before"].(string)
+	}
+	return ok && object["id"] == cursor
+}
+
+// paginateList picks the page of a list of objects of type objectType
+// requested with the `limit`, `starting_after`, and `ending_before`
+// parameters. Returns the page along with whether there are more objects
+// beyond it, or a `resource_missing` error naming the cursor parameter if its
+// object isn't in the list.
+func paginateList(objectType string, objects []interface{}, requestData map[string]interface{}) ([]interface{}, bool, *ResponseError) {
+	limit := defaultListLimit
+	if requestLimit, ok := toInt64(requestData["limit"]); ok && requestLimit > 0 {
+		limit = int(requestLimit)
+	}
+
+	if startingAfter, ok := requestData["starting_after"].(string); ok {
+		i := listIndex(objects, startingAfter)
+		if i < 0 {
+			return nil, false, createResourceMissingError(objectType, startingAfter, "starting_after")
+		}
+		objects = objects[i+1:]
+	} else if endingBefore, ok := requestData["ending_before"].(string); ok {
+		i := listIndex(objects, endingBefore)
+		if i < 0 {
+			return nil, false, createResourceMissingError(objectType, endingBefore, "ending_before")
+		}
+
+		objects = objects[:i]
+		if len(objects) > limit {
+			return objects[len(objects)-limit:], true, nil
+		}
+		return objects, false, nil
+	}
+
+	if objects == nil {
+		objects = []interface{}{}
+	}
+
+	if len(objects) > limit {
+		return objects[:limit], true, nil
+	}
+	return objects, false, nil
+}
+
+// listIndex finds the index of the object with the given ID in a list, or -1
+// if it's not there.
+func listIndex(objects []interface{}, id string) int {
+	for i, object := range objects {
+		if object.(map[string]interface{})["id"] == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// referenceID gets the ID from a field that references another object, which
+// may be either an ID or an expanded object.
+func referenceID(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		id, _ := v["id"].(string)
+		return id
+	case string:
+		return v
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestApplyStatefulList(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	// Customers need to exist to be used as filters
+	testStore(server).Put(map[string]interface{}{"id": "cus_0", "object": "customer"})
+	testStore(server).Put(map[string]interface{}{"id": "cus_1", "object": "customer"})
+
+	for i := 0; i < 3; i++ {
+		testStore(server).Put(map[string]interface{}{
+			"created":  1000 + i,
+			"customer": fmt.Sprintf("cus_%d", i%2),
+			"id":       fmt.Sprintf("pi_%d", i),
+			"object":   "payment_intent",
+		})
+	}
+
+	listIDs := func(query string) ([]string, bool) {
+		resp, body := sendRequestToServer(t, server, "GET",
+			"/v1/payment_intents"+query, "", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		list := decodeObject(t, body)
+		assert.Equal(t, "/v1/payment_intents", list["url"])
+
+		var ids []string
+		for _, object := range list["data"].([]interface{}) {
+			ids = append(ids, object.(map[string]interface{})["id"].(string))
+		}
+		return ids, list["has_more"].(bool)
+	}
+
+	// Most recently created first
+	ids, hasMore := listIDs("")
+	assert.Equal(t, []string{"pi_2", "pi_1", "pi_0"}, ids)
+	assert.False(t, hasMore)
+
+	ids, _ = listIDs("?customer=cus_0")
+	assert.Equal(t, []string{"pi_2", "pi_0"}, ids)
+
+	ids, _ = listIDs("?created[gte]=1001")
+	assert.Equal(t, []string{"pi_2", "pi_1"}, ids)
+
+	ids, _ = listIDs("?created[gt]=1000&created[lt]=1002")
+	assert.Equal(t, []string{"pi_1"}, ids)
+
+	ids, _ = listIDs("?created=1000")
+	assert.Equal(t, []string{"pi_0"}, ids)
+
+	ids, hasMore = listIDs("?limit=2")
+	assert.Equal(t, []string{"pi_2", "pi_1"}, ids)
+	assert.True(t, hasMore)
+
+	ids, hasMore = listIDs("?limit=2&starting_after=pi_1")
+	assert.Equal(t, []string{"pi_0"}, ids)
+	assert.False(t, hasMore)
+
+	ids, hasMore = listIDs("?limit=1&ending_before=pi_0")
+	assert.Equal(t, []string{"pi_1"}, ids)
+	assert.True(t, hasMore)
+
+	// A cursor that the filters exclude still marks a position in the list
+	ids, hasMore = listIDs("?customer=cus_0&starting_after=pi_1")
+	assert.Equal(t, []string{"pi_0"}, ids)
+	assert.False(t, hasMore)
+}
+
+func TestApplyStatefulList_UnknownCursor(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	for _, param := range []string{"starting_after", "ending_before"} {
+		resp, body := sendRequestToServer(t, server, "GET",
+			"/v1/payment_intents?"+param+"=pi_missing", "", getDefaultHeaders())
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+		assert.Equal(t, "resource_missing", errorInfo["code"])
+		assert.Equal(t, param, errorInfo["param"])
+		assert.Equal(t, "No such payment_intent: 'pi_missing'", errorInfo["message"])
+	}
+}
+
+func TestApplyStatefulList_Empty(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/payment_intents",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{}, decodeObject(t, body)["data"])
+}
+
+func TestMatchesListFilters(t *testing.T) {
+	subscription := map[string]interface{}{
+		"customer": map[string]interface{}{"id": "cus_123"},
+		"items": map[string]interface{}{
+			"data": []interface{}{
+				map[string]interface{}{"price": map[string]interface{}{"id": "price_123"}},
+			},
+		},
+		"object": "subscription",
+		"status": "canceled",
+	}
+
+	// Canceled subscriptions are only listed when asked for
+	assert.False(t, matchesListFilters(subscription, map[string]interface{}{}))
+	assert.True(t, matchesListFilters(subscription, map[string]interface{}{"status": "all"}))
+	assert.True(t, matchesListFilters(subscription, map[string]interface{}{"status": "canceled"}))
+	assert.False(t, matchesListFilters(subscription, map[string]interface{}{"status": "active"}))
+
+	assert.True(t, matchesListFilters(subscription, map[string]interface{}{
+		"customer": "cus_123",
+		"price":    "price_123",
+		"status":   "all",
+	}))
+	assert.False(t, matchesListFilters(subscription, map[string]interface{}{
+		"price":  "price_456",
+		"status": "all",
+	}))
+
+	event := map[string]interface{}{"object": "event", "type": "customer.created"}
+	assert.True(t, matchesListFilters(event, map[string]interface{}{"type": "customer.created"}))
+	assert.False(t, matchesListFilters(event, map[string]interface{}{"type": "customer.updated"}))
+}
+
+func TestPaginateList_UnknownCursor(t *testing.T) {
+	objects := []interface{}{map[string]interface{}{"id": "pi_1"}}
+
+	_, _, err := paginateList("payment_intent", objects, map[string]interface{}{"starting_after": "pi_missing"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "resource_missing", err.ErrorInfo.Code)
+	assert.Equal(t, "starting_after", err.ErrorInfo.Param)
+	assert.Equal(t, "No such payment_intent: 'pi_missing'", err.ErrorInfo.Message)
+
+	_, _, err = paginateList("payment_intent", objects, map[string]interface{}{"ending_before": "pi_missing"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "ending_before", err.ErrorInfo.Param)
+}
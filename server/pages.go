@@ -0,0 +1,276 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//
+// Private constants
+//
+
+// pagePathPrefix is the prefix of paths for pages that stripe-mock serves to
+// browsers (as opposed to API endpoints). Requests to these paths don't need
+// to be authenticated.
+const pagePathPrefix = "/_stripe_mock/"
+
+// authenticatePath is the path of the simulated 3-D Secure page. It's
+// followed by the ID of the intent being authenticated.
+const authenticatePath = pagePathPrefix + "3d_secure/"
+
+// pageAccountParam is the query parameter that identifies the connected
+// account that a page acts on behalf of, since a browser can't send
+// `Stripe-Account`.
+const pageAccountParam = "stripe_account"
+
+// pageTenantParam is the query parameter that identifies the tenant (see
+// tenantStore) whose objects a page acts on, since a browser doesn't send an
+// API key.
+const pageTenantParam = "tenant"
+
+const (
+	authenticateResultComplete = "complete"
+	authenticateResultFail     = "fail"
+)
+
+//
+// Private values
+//
+
+var authenticatePageTemplate = template.Must(template.New("authenticate").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>stripe-mock 3D Secure</title>
+</head>
+<body>
+<h1>3D Secure authentication</h1>
+<p>This is a simulated authentication page served by stripe-mock for
+<code id="intent">{{.ID}}</code>{{if .Amount}} ({{.Amount}} {{.Currency}}){{end}}.</p>
+<form method="post">
+<button type="submit" name="result" value="complete" id="complete">Complete</button>
+<button type="submit" name="result" value="fail" id="fail">Fail</button>
+</form>
+</body>
+</html>
+`))
+
+var messagePageTemplate = template.Must(template.New("message").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>stripe-mock</title>
+</head>
+<body>
+<p id="message">{{.}}</p>
+</body>
+</html>
+`))
+
+//
+// Private functions
+//
+
+// handlePage serves one of the pages that stripe-mock provides for browsers.
+// These are only available in stateful mode because they act on stored
+// objects.
+func (s *StubServer) handlePage(w http.ResponseWriter, r *http.Request, start time.Time) {
+	if s.store == nil {
+		writePage(w, r, start, http.StatusNotFound, messagePageTemplate,
+			"Pages are only available when stripe-mock is started with -stateful.")
+		return
+	}
+
+	query := r.URL.Query()
+	platform := s.store.Namespace(tenantNamespace(query.Get(pageTenantParam)))
+	store, stripeError := requestStore(platform, query.Get(pageAccountParam))
+	if stripeError != nil {
+		writePage(w, r, start, http.StatusForbidden, messagePageTemplate,
+			stripeError.ErrorInfo.Message)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, authenticatePath) {
+		s.handleAuthenticatePage(w, r, start, store, strings.TrimPrefix(r.URL.Path, authenticatePath))
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, checkoutPath) {
+		s.handleCheckoutPage(w, r, start, store, strings.TrimPrefix(r.URL.Path, checkoutPath))
+		return
+	}
+
+	writePage(w, r, start, http.StatusNotFound, messagePageTemplate,
+		fmt.Sprintf("Unrecognized page: %s", r.URL.Path))
+}
+
+// handleAuthenticatePage serves the simulated 3-D Secure page for an intent in
+// `requires_action`. A `GET` shows the page and a `POST` (from one of its
+// buttons) completes or fails the authentication, then redirects to the
+// intent's `return_url` if it has one.
+func (s *StubServer) handleAuthenticatePage(w http.ResponseWriter, r *http.Request, start time.Time, store *ObjectStore, id string) {
+	intent, ok := store.Get(id)
+	if !ok {
+		writePage(w, r, start, http.StatusNotFound, messagePageTemplate,
+			fmt.Sprintf("No such intent: '%s'", id))
+		return
+	}
+
+	kind, ok := intentKinds[intent["object"].(string)]
+	if !ok || intent["status"] != intentStatusRequiresAction {
+		writePage(w, r, start, http.StatusBadRequest, messagePageTemplate,
+			fmt.Sprintf("%s doesn't require authentication (status: %v).", id, intent["status"]))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writePage(w, r, start, http.StatusOK, authenticatePageTemplate, map[string]interface{}{
+			"Amount":   intent["amount"],
+			"Currency": intent["currency"],
+			"ID":       id,
+		})
+		return
+
+	case http.MethodPost:
+		// Handled below
+
+	default:
+		writePage(w, r, start, http.StatusMethodNotAllowed, messagePageTemplate,
+			http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writePage(w, r, start, http.StatusBadRequest, messagePageTemplate,
+			fmt.Sprintf("Couldn't parse form: %v", err))
+		return
+	}
+
+	var authenticate func(intent map[string]interface{})
+	var redirectStatus string
+	switch r.PostForm.Get("result") {
+	case authenticateResultComplete:
+		authenticate = func(intent map[string]interface{}) { completeIntent(store, intent) }
+		redirectStatus = "succeeded"
+
+	case authenticateResultFail:
+		authenticate = failIntentAuthentication
+		redirectStatus = "failed"
+
+	default:
+		writePage(w, r, start, http.StatusBadRequest, messagePageTemplate,
+			fmt.Sprintf("Unrecognized result: '%s'", r.PostForm.Get("result")))
+		return
+	}
+
+	// The intent is checked and transitioned atomically so that only one of
+	// several concurrent submissions authenticates it.
+	var previous map[string]interface{}
+	var returnURL string
+	intent, ok, err = store.Update(id, func(intent map[string]interface{}) (map[string]interface{}, error) {
+		if intent["status"] != intentStatusRequiresAction {
+			return nil, fmt.Errorf("%s doesn't require authentication (status: %v).", id, intent["status"])
+		}
+
+		previous = deepCopyMap(intent)
+		returnURL = intentReturnURL(intent)
+		authenticate(intent)
+		return intent, nil
+	})
+	if err != nil {
+		writePage(w, r, start, http.StatusBadRequest, messagePageTemplate, err.Error())
+		return
+	}
+	if !ok {
+		writePage(w, r, start, http.StatusNotFound, messagePageTemplate,
+			fmt.Sprintf("No such intent: '%s'", id))
+		return
+	}
+
+	s.createObjectEvents(store, r.URL.Query().Get(pageAccountParam), previous, intent)
+
+	if returnURL == "" {
+		writePage(w, r, start, http.StatusOK, messagePageTemplate,
+			fmt.Sprintf("Authentication %s. You can close this window.", redirectStatus))
+		return
+	}
+
+	location, err := url.Parse(returnURL)
+	if err != nil {
+		writePage(w, r, start, http.StatusBadRequest, messagePageTemplate,
+			fmt.Sprintf("Invalid return_url: %v", err))
+		return
+	}
+
+	// Like the real API, reflect the intent back to the integration in the
+	// query string of its return URL.
+	query := location.Query()
+	query.Set(kind.redirectParam, id)
+	if clientSecret, ok := intent["client_secret"].(string); ok {
+		query.Set(kind.redirectParam+"_client_secret", clientSecret)
+	}
+	query.Set("redirect_status", redirectStatus)
+	location.RawQuery = query.Encode()
+
+	http.Redirect(w, r, location.String(), http.StatusSeeOther)
+	fmt.Printf("Response: elapsed=%v status=%v\n", time.Now().Sub(start), http.StatusSeeOther)
+}
+
+// intentReturnURL extracts the URL that the customer should be returned to
+// after authenticating an intent, or an empty string if there isn't one.
+func intentReturnURL(intent map[string]interface{}) string {
+	nextAction, ok := intent["next_action"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	redirect, ok := nextAction["redirect_to_url"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	returnURL, _ := redirect["return_url"].(string)
+	return returnURL
+}
+
+// pageURL builds a link to one of stripe-mock's pages for an object being
+// transitioned. The link identifies the object's tenant and connected account
+// (if any) so that the page can find the object.
+func pageURL(t *transition, path string) string {
+	query := url.Values{pageTenantParam: {t.tenant}}
+	if t.account != "" {
+		query.Set(pageAccountParam, t.account)
+	}
+	return t.baseURL + path + "?" + query.Encode()
+}
+
+// requestBaseURL gets the scheme and host that a request was made to so that
+// stripe-mock can build links back to itself, like `http://localhost:12111`.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// writePage renders an HTML page and writes it as a response.
+func writePage(w http.ResponseWriter, r *http.Request, start time.Time, status int, tmpl *template.Template, data interface{}) {
+	var page strings.Builder
+	err := tmpl.Execute(&page, data)
+	if err != nil {
+		fmt.Printf("Error rendering page: %v\n", err)
+		status = http.StatusInternalServerError
+		page.Reset()
+		page.WriteString(http.StatusText(status))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writeResponse(w, r, start, status, page.String())
+}
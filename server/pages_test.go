@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestAuthenticatePage(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&confirm=true&payment_method=pm_card_threeDSecure2Required&return_url=https://example.com/return?order=1",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent := decodeObject(t, body)
+	id := intent["id"].(string)
+
+	redirect := intent["next_action"].(map[string]interface{})["redirect_to_url"].(map[string]interface{})
+	authenticateURL, err := url.Parse(redirect["url"].(string))
+	assert.NoError(t, err)
+	assert.Equal(t, "https", authenticateURL.Scheme)
+	assert.Equal(t, "stripe.com", authenticateURL.Host)
+	assert.Equal(t, authenticatePath+id, authenticateURL.Path)
+
+	// The page is served without authentication
+	resp, body = sendRequestToServer(t, server, "GET", authenticateURL.RequestURI(), "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), id)
+	assert.Contains(t, string(body), `value="complete"`)
+	assert.Contains(t, string(body), `value="fail"`)
+
+	resp, _ = sendRequestToServer(t, server, "POST", authenticateURL.RequestURI(),
+		"result=complete", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", location.Host)
+	assert.Equal(t, "/return", location.Path)
+	assert.Equal(t, "1", location.Query().Get("order"))
+	assert.Equal(t, id, location.Query().Get("payment_intent"))
+	assert.Equal(t, "succeeded", location.Query().Get("redirect_status"))
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent = decodeObject(t, body)
+	assert.Equal(t, "succeeded", intent["status"])
+	assert.Nil(t, intent["next_action"])
+
+	// Authentication can't happen twice
+	resp, _ = sendRequestToServer(t, server, "GET", authenticateURL.RequestURI(), "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAuthenticatePage_Fail(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&confirm=true&payment_method=pm_card_threeDSecure2Required&return_url=https://example.com/return",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "POST", testPageURL(authenticatePath+id),
+		"result=fail", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.True(t, strings.HasSuffix(resp.Header.Get("Location"), "redirect_status=failed"))
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent := decodeObject(t, body)
+	assert.Equal(t, "requires_payment_method", intent["status"])
+	assert.Nil(t, intent["payment_method"])
+	lastError := intent["last_payment_error"].(map[string]interface{})
+	assert.Equal(t, "payment_intent_authentication_failure", lastError["code"])
+}
+
+// Only one of several concurrent submissions of the page authenticates the
+// intent, even if they have different results.
+func TestAuthenticatePage_Concurrent(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&confirm=true&payment_method=pm_card_threeDSecure2Required&return_url=https://example.com/return",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	statuses := make([]int, 10)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		result := authenticateResultComplete
+		if i%2 == 1 {
+			result = authenticateResultFail
+		}
+
+		wg.Add(1)
+		go func(i int, result string) {
+			defer wg.Done()
+			resp, _ := sendRequestToServer(t, server, "POST", testPageURL(authenticatePath+id),
+				"result="+result, map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+			statuses[i] = resp.StatusCode
+		}(i, result)
+	}
+	wg.Wait()
+
+	redirected := 0
+	for _, status := range statuses {
+		if status == http.StatusSeeOther {
+			redirected++
+		} else {
+			assert.Equal(t, http.StatusBadRequest, status)
+		}
+	}
+	assert.Equal(t, 1, redirected)
+}
+
+func TestAuthenticatePage_NotFound(t *testing.T) {
+	resp, _ := sendRequest(t, "GET", authenticatePath+"pi_123", "", nil,
+		&testStubServerOptions{stateful: true})
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Pages aren't available outside of stateful mode
+	resp, _ = sendRequest(t, "GET", authenticatePath+"pi_123", "", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-mock/spec"
+)
+
+//
+// Public types
+//
+
+// RestrictedKeyPermissions are the permissions of a restricted API key, keyed
+// by resource ID (e.g. `customer` or `checkout.session`). Each is one of
+// `none`, `read`, or `write`, the last of which implies `read`. Resources
+// that aren't listed have no access, just like with the real API.
+type RestrictedKeyPermissions map[string]string
+
+//
+// Public functions
+//
+
+// LoadRestrictedKeys loads the permissions of restricted API keys from a JSON
+// file that maps each key to its permissions. For example:
+//
+//	{"rk_test_readonly": {"customer": "read", "payout": "none"}}
+func LoadRestrictedKeys(path string) (map[string]RestrictedKeyPermissions, error) {
+	if !isJSONFile(path) {
+		return nil, fmt.Errorf("Restricted keys should come from a JSON file")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading restricted keys: %v", err)
+	}
+
+	var keys map[string]RestrictedKeyPermissions
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding restricted keys: %v", err)
+	}
+
+	return keys, nil
+}
+
+// SetRestrictedKeyPermissions declares the permissions of a restricted API key
+// (`rk_test_...`). Requests made with the key that need a permission it
+// doesn't have fail with a 403. Restricted keys whose permissions haven't
+// been declared have full access.
+func (s *StubServer) SetRestrictedKeyPermissions(key string, permissions RestrictedKeyPermissions) error {
+	if !isRestrictedKey(key) {
+		return fmt.Errorf("'%s' isn't a test mode restricted key like `rk_test_123`", key)
+	}
+
+	for resource, level := range permissions {
+		if !permissionLevels[level] {
+			return fmt.Errorf("permission for '%s' of '%s' should be one of none, read, or write, but was '%s'",
+				resource, key, level)
+		}
+	}
+
+	s.restrictedKeys.mu.Lock()
+	defer s.restrictedKeys.mu.Unlock()
+
+	if s.restrictedKeys.permissions == nil {
+		s.restrictedKeys.permissions = make(map[string]RestrictedKeyPermissions)
+	}
+
+	copied := make(RestrictedKeyPermissions, len(permissions))
+	for resource, level := range permissions {
+		copied[resource] = level
+	}
+	s.restrictedKeys.permissions[key] = copied
+
+	return nil
+}
+
+//
+// Private constants
+//
+
+const (
+	permissionNone  = "none"
+	permissionRead  = "read"
+	permissionWrite = "write"
+)
+
+// restrictedKeysPath is the prefix of the administrative endpoint that
+// declares the permissions of restricted keys. It's followed by the key.
+const restrictedKeysPath = pagePathPrefix + "restricted_keys/"
+
+const (
+	codeInsufficientPermissions = "insufficient_permissions"
+
+	missingPermission = "The provided key '%s' does not have the required " +
+		"permissions for this endpoint. Having the '%s' permission would " +
+		"allow this request to continue."
+
+	restrictedKeysSecretKeyRequired = "Permissions of restricted keys can " +
+		"only be managed with a secret key like `sk_test_123`."
+
+	restrictedKeysUnsupportedMethod = "Unsupported method %s for %s. Send a " +
+		"`GET` to show a restricted key's permissions, a `POST` with " +
+		"parameters like `customer=read` to replace them, or a `DELETE` to " +
+		"give the key full access again."
+)
+
+//
+// Private values
+//
+
+// permissionLevels are the valid levels of a permission.
+var permissionLevels = map[string]bool{
+	permissionNone:  true,
+	permissionRead:  true,
+	permissionWrite: true,
+}
+
+//
+// Private types
+//
+
+// restrictedKeys holds the declared permissions of restricted keys. They can
+// be changed through an administrative endpoint while requests are being
+// served, so access is synchronized.
+type restrictedKeys struct {
+	mu          sync.Mutex
+	permissions map[string]RestrictedKeyPermissions
+}
+
+//
+// Private functions
+//
+
+// checkPermissions makes sure that an API key has the permission needed to
+// make a request to a route. Only restricted keys with declared permissions
+// are limited. The permission needed is derived from the resource that the
+// route responds with and its verb: a `GET` needs `read` and anything else
+// needs `write`.
+func (s *StubServer) checkPermissions(key string, r *http.Request, schema *spec.Schema) *ResponseError {
+	s.restrictedKeys.mu.Lock()
+	permissions, ok := s.restrictedKeys.permissions[key]
+	s.restrictedKeys.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	resource := s.permissionResource(schema)
+	if resource == "" {
+		return nil
+	}
+
+	needed := permissionWrite
+	if r.Method == http.MethodGet {
+		needed = permissionRead
+	}
+
+	level := permissions[resource]
+	if level == permissionWrite || level == needed {
+		return nil
+	}
+
+	stripeError := createStripeError(typeInvalidRequestError,
+		fmt.Sprintf(missingPermission, maskAPIKey(key),
+			"rak_"+strings.Replace(resource, ".", "_", -1)+"_"+needed))
+	stripeError.ErrorInfo.Code = codeInsufficientPermissions
+	return stripeError
+}
+
+// handleRestrictedKeys serves the administrative endpoint at
+// restrictedKeysPath. It must be called with a secret key because a
+// restricted key shouldn't be able to grant itself permissions.
+func (s *StubServer) handleRestrictedKeys(w http.ResponseWriter, r *http.Request, start time.Time) {
+	auth := r.Header.Get("Authorization")
+	authKey, ok := validateAuth(auth)
+	if !ok {
+		message := fmt.Sprintf(invalidAuthorization, auth)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusUnauthorized, stripeError)
+		return
+	}
+
+	if !strings.HasPrefix(authKey, "sk_") {
+		stripeError := createStripeError(typeInvalidRequestError, restrictedKeysSecretKeyRequired)
+		writeResponse(w, r, start, http.StatusForbidden, stripeError)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, restrictedKeysPath)
+
+	switch r.Method {
+	case http.MethodDelete:
+		s.restrictedKeys.mu.Lock()
+		delete(s.restrictedKeys.permissions, key)
+		s.restrictedKeys.mu.Unlock()
+
+		writeResponse(w, r, start, http.StatusOK, map[string]interface{}{
+			"deleted": true,
+			"id":      key,
+			"object":  "restricted_key",
+		})
+		return
+
+	case http.MethodGet:
+		// Handled below
+
+	case http.MethodPost:
+		err := r.ParseForm()
+		if err != nil {
+			stripeError := createStripeError(typeInvalidRequestError,
+				fmt.Sprintf("Couldn't parse form: %v", err))
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+
+		permissions := make(RestrictedKeyPermissions)
+		for resource := range r.PostForm {
+			permissions[resource] = r.PostForm.Get(resource)
+		}
+
+		err = s.SetRestrictedKeyPermissions(key, permissions)
+		if err != nil {
+			stripeError := createStripeError(typeInvalidRequestError, err.Error())
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+
+	default:
+		stripeError := createStripeError(typeInvalidRequestError,
+			fmt.Sprintf(restrictedKeysUnsupportedMethod, r.Method, r.URL.Path))
+		writeResponse(w, r, start, http.StatusMethodNotAllowed, stripeError)
+		return
+	}
+
+	s.restrictedKeys.mu.Lock()
+	permissions, ok := s.restrictedKeys.permissions[key]
+	s.restrictedKeys.mu.Unlock()
+	if !ok {
+		stripeError := createStripeError(typeInvalidRequestError,
+			fmt.Sprintf("No permissions have been declared for '%s'.", key))
+		writeResponse(w, r, start, http.StatusNotFound, stripeError)
+		return
+	}
+
+	writeResponse(w, r, start, http.StatusOK, map[string]interface{}{
+		"id":          key,
+		"object":      "restricted_key",
+		"permissions": permissions,
+	})
+}
+
+// isRestrictedKey checks whether an API key is a valid looking test mode
+// restricted key.
+func isRestrictedKey(key string) bool {
+	_, ok := validateAuth("Bearer " + key)
+	return ok && strings.HasPrefix(key, "rk_")
+}
+
+// maskAPIKey hides all but the last four characters of an API key's secret
+// part like the real API does in error messages.
+func maskAPIKey(key string) string {
+	prefixEnd := strings.LastIndex(key, "_") + 1
+	secret := key[prefixEnd:]
+	if len(secret) <= 4 {
+		return key
+	}
+	return key[:prefixEnd] + strings.Repeat("*", len(secret)-4) + secret[len(secret)-4:]
+}
+
+// permissionResource finds the resource that governs the permission needed
+// for a route from its response schema. Lists and search results are governed
+// by the resource they contain, and deleted objects by the resource that was
+// deleted.
+func (s *StubServer) permissionResource(schema *spec.Schema) string {
+	resource := s.resolveResourceID(schema)
+
+	if resource == "" && schema != nil {
+		if data, ok := schema.Properties["data"]; ok && data.Items != nil {
+			resource = s.resolveResourceID(data.Items)
+		}
+	}
+
+	return strings.TrimPrefix(resource, "deleted_")
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+const testSearchPath = "/v1/customers/search?query=email%3A%22jenny%40example.com%22"
+
+func TestLoadRestrictedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "restricted_keys.json")
+	err := os.WriteFile(path, []byte(`{"rk_test_readonly": {"customer": "read"}}`), 0o600)
+	assert.NoError(t, err)
+
+	keys, err := LoadRestrictedKeys(path)
+	assert.NoError(t, err)
+	assert.Equal(t, RestrictedKeyPermissions{"customer": "read"}, keys["rk_test_readonly"])
+
+	_, err = LoadRestrictedKeys(filepath.Join(t.TempDir(), "restricted_keys.yaml"))
+	assert.Error(t, err)
+
+	_, err = LoadRestrictedKeys(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestMaskAPIKey(t *testing.T) {
+	assert.Equal(t, "rk_test_*******1234", maskAPIKey("rk_test_abcdefg1234"))
+	assert.Equal(t, "rk_test_123", maskAPIKey("rk_test_123"))
+}
+
+func TestSetRestrictedKeyPermissions(t *testing.T) {
+	server := getStubServer(t, nil)
+
+	assert.NoError(t, server.SetRestrictedKeyPermissions("rk_test_123",
+		RestrictedKeyPermissions{"customer": "read", "payout": "none"}))
+
+	// Only restricted keys can be limited
+	assert.Error(t, server.SetRestrictedKeyPermissions("sk_test_123",
+		RestrictedKeyPermissions{"customer": "read"}))
+	assert.Error(t, server.SetRestrictedKeyPermissions("rk_live_123",
+		RestrictedKeyPermissions{"customer": "read"}))
+
+	assert.Error(t, server.SetRestrictedKeyPermissions("rk_test_123",
+		RestrictedKeyPermissions{"customer": "admin"}))
+}
+
+func TestCheckPermissions(t *testing.T) {
+	server := getStubServer(t, nil)
+	assert.NoError(t, server.SetRestrictedKeyPermissions("rk_test_readonly",
+		RestrictedKeyPermissions{"customer": "read", "payment_intent": "write"}))
+
+	headers := getTenantHeaders("rk_test_readonly")
+
+	resp, _ := sendRequestToServer(t, server, "GET", testSearchPath, "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/customers", "", headers)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, typeInvalidRequestError, errorInfo["type"])
+	assert.Equal(t, codeInsufficientPermissions, errorInfo["code"])
+	assert.Contains(t, errorInfo["message"], "does not have the required permissions")
+	assert.Contains(t, errorInfo["message"], "rk_test_****only")
+	assert.Contains(t, errorInfo["message"], "rak_customer_write")
+
+	// Deleting needs write access to the deleted resource
+	resp, body = sendRequestToServer(t, server, "DELETE", "/v1/customers/cus_123", "", headers)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(body), "rak_customer_write")
+
+	// Write access implies read access
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/payment_intents", "amount=500", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Resources that aren't listed can't be accessed at all
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/events/evt_123", "", headers)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	errorInfo = decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, typeInvalidRequestError, errorInfo["type"])
+	assert.Equal(t, codeInsufficientPermissions, errorInfo["code"])
+	assert.Contains(t, errorInfo["message"], "rak_event_read")
+
+	// The same key sent through Basic auth is limited the same way
+	resp, _ = sendRequestToServer(t, server, "GET", testSearchPath, "",
+		getBasicAuthHeaders("rk_test_readonly"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/customers", "",
+		getBasicAuthHeaders("rk_test_readonly"))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(body), "rak_customer_write")
+
+	// Secret keys and restricted keys without declared permissions aren't
+	// limited
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/customers", "",
+		getTenantHeaders("sk_test_readonly"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/customers", "",
+		getTenantHeaders("rk_test_other"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRestrictedKeysEndpoint(t *testing.T) {
+	server := getStubServer(t, nil)
+	path := restrictedKeysPath + "rk_test_readonly"
+
+	resp, _ := sendRequestToServer(t, server, "GET", path, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body := sendRequestToServer(t, server, "POST", path, "customer=read", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	restrictedKey := decodeObject(t, body)
+	assert.Equal(t, "rk_test_readonly", restrictedKey["id"])
+	assert.Equal(t, map[string]interface{}{"customer": "read"}, restrictedKey["permissions"])
+
+	resp, _ = sendRequestToServer(t, server, "GET", path, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	headers := getTenantHeaders("rk_test_readonly")
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/customers", "", headers)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// A restricted key can't change permissions, including its own
+	resp, _ = sendRequestToServer(t, server, "POST", path, "customer=write", headers)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "POST", path, "customer=admin", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "DELETE", path, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/customers", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "POST", path, "customer=read", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//
+// Private constants
+//
+
+const (
+	clientSecretMismatch = "The client_secret provided does not match the " +
+		"client_secret associated with the %s."
+
+	clientSecretMissing = "Missing required param: client_secret."
+
+	codeParameterMissing = "parameter_missing"
+
+	publishableKeyNotAllowed = "This API call cannot be made with a " +
+		"publishable API key. Please use a secret API key. You can find a " +
+		"list of your API keys at https://dashboard.stripe.com/account/apikeys."
+)
+
+//
+// Private values
+//
+
+// publishableKeyRoutes are the routes that can be called with a publishable
+// key, which are the ones that Stripe.js and the mobile SDKs call from the
+// client. Each maps to whether the request must carry the `client_secret` of
+// the object it acts on.
+var publishableKeyRoutes = map[string]bool{
+	"GET /v1/payment_intents/{intent}":          true,
+	"GET /v1/setup_intents/{intent}":            true,
+	"GET /v1/sources/{source}":                  true,
+	"POST /v1/payment_intents/{intent}/confirm": true,
+	"POST /v1/payment_methods":                  false,
+	"POST /v1/setup_intents/{intent}/confirm":   true,
+	"POST /v1/sources":                          false,
+	"POST /v1/tokens":                           false,
+}
+
+//
+// Private functions
+//
+
+// checkPublishableKeyRoute makes sure that a route can be called with a
+// publishable key. Returns whether the request must carry a `client_secret`
+// (see checkClientSecret).
+func checkPublishableKeyRoute(r *http.Request, route *stubServerRoute) (bool, *ResponseError) {
+	clientSecretRequired, ok := publishableKeyRoutes[r.Method+" "+string(route.path)]
+	if !ok {
+		return false, createStripeError(typeInvalidRequestError, publishableKeyNotAllowed)
+	}
+	return clientSecretRequired, nil
+}
+
+// checkClientSecret makes sure that a request made with a publishable key
+// carries the `client_secret` of the object that it acts on. In stateful
+// mode, the secret must match the stored object's. Otherwise, there's nothing
+// to match it against, so it only needs to be present.
+//
+// The secret is removed from the request's data once checked because it only
+// serves to authenticate the request.
+func checkClientSecret(store *ObjectStore, pathParams *PathParamsMap, requestData map[string]interface{}) *ResponseError {
+	clientSecret, _ := requestData["client_secret"].(string)
+	if clientSecret == "" {
+		stripeError := createStripeError(typeInvalidRequestError, clientSecretMissing)
+		stripeError.ErrorInfo.Code = codeParameterMissing
+		stripeError.ErrorInfo.Param = "client_secret"
+		return stripeError
+	}
+	delete(requestData, "client_secret")
+
+	if store == nil || pathParams == nil || pathParams.PrimaryID == nil {
+		return nil
+	}
+
+	// Objects that aren't stored are left for stateful behavior to handle.
+	stored, ok := store.Get(*pathParams.PrimaryID)
+	if !ok || stored["client_secret"] == clientSecret {
+		return nil
+	}
+
+	displayName, _ := stored["object"].(string)
+	if kind, ok := intentKinds[displayName]; ok {
+		displayName = kind.displayName
+	}
+
+	stripeError := createStripeError(typeInvalidRequestError,
+		fmt.Sprintf(clientSecretMismatch, displayName))
+	stripeError.ErrorInfo.Param = "client_secret"
+	return stripeError
+}
+
+// isPublishableKey checks whether an API key is a publishable key.
+func isPublishableKey(key string) bool {
+	return strings.HasPrefix(key, "pk_")
+}
+
+// newClientSecret generates a new client secret for an object, which like in
+// the real API is the object's ID followed by a random secret.
+func newClientSecret(id string) string {
+	return id + "_secret_" + randomIDRandomPart()
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestNewClientSecret(t *testing.T) {
+	clientSecret := newClientSecret("pi_123")
+	assert.True(t, strings.HasPrefix(clientSecret, "pi_123_secret_"))
+	assert.NotEqual(t, clientSecret, newClientSecret("pi_123"))
+}
+
+func TestPublishableKey_AllowedRoutes(t *testing.T) {
+	headers := getTenantHeaders("pk_test_123")
+
+	resp, _ := sendRequest(t, "POST", "/v1/payment_methods", "type=card", headers, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := sendRequest(t, "POST", "/v1/customers", "", headers, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, publishableKeyNotAllowed, errorInfo["message"])
+
+	resp, _ = sendRequest(t, "GET", "/v1/payment_intents", "", headers, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestPublishableKey_ClientSecretStateless(t *testing.T) {
+	headers := getTenantHeaders("pk_test_123")
+
+	resp, body := sendRequest(t, "GET", "/v1/payment_intents/pi_123", "", headers, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, codeParameterMissing, errorInfo["code"])
+	assert.Equal(t, "client_secret", errorInfo["param"])
+
+	// Without stored objects, any secret is accepted
+	resp, _ = sendRequest(t, "GET", "/v1/payment_intents/pi_123?client_secret=pi_123_secret_456",
+		"", headers, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The secret is accepted even by endpoints that don't otherwise take it
+	resp, _ = sendRequest(t, "POST", "/v1/payment_intents/pi_123/confirm",
+		"client_secret=pi_123_secret_456&payment_method=pm_card_visa", headers, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestPublishableKey_ClientSecretStateful(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500", getTenantHeaders("sk_test_shard"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	intent := decodeObject(t, body)
+	id := intent["id"].(string)
+	clientSecret := intent["client_secret"].(string)
+	assert.True(t, strings.HasPrefix(clientSecret, id+"_secret_"))
+
+	headers := getTenantHeaders("pk_test_shard")
+
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/payment_intents/"+id+"?client_secret=pi_123_secret_456", "", headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "client_secret", errorInfo["param"])
+	assert.Contains(t, errorInfo["message"], "PaymentIntent")
+
+	query := url.Values{"client_secret": {clientSecret}}.Encode()
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/payment_intents/"+id+"?"+query, "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, id, decodeObject(t, body)["id"])
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id+"/confirm",
+		query+"&payment_method=pm_card_visa", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "succeeded", decodeObject(t, body)["status"])
+
+	// Publishable keys share their secret key's objects, but can't wipe them
+	resp, _ = sendRequestToServer(t, server, "DELETE", tenantPath, "", headers)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestPublishableKey_RestrictedKeysEndpoint(t *testing.T) {
+	resp, _ := sendRequest(t, "POST", restrictedKeysPath+"rk_test_123", "customer=read",
+		getTenantHeaders("pk_test_123"), nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
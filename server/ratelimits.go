@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-mock/spec"
+)
+
+//
+// Public types
+//
+
+// RateLimits configures how many requests per second each API key may make,
+// similar to the real API's read and write limits. A limit of zero means
+// unlimited.
+type RateLimits struct {
+	// Operations limits individual operations, keyed by method and path like
+	// `POST /v1/payment_intents`. They apply in addition to Read and Write.
+	Operations map[string]int `json:"operations"`
+
+	// Read limits `GET` requests.
+	Read int `json:"read"`
+
+	// Write limits requests with any other method.
+	Write int `json:"write"`
+}
+
+//
+// Public functions
+//
+
+// LoadRateLimits loads rate limits from a JSON file. For example:
+//
+//	{"read": 100, "write": 100, "operations": {"POST /v1/payment_intents": 5}}
+func LoadRateLimits(path string) (*RateLimits, error) {
+	if !isJSONFile(path) {
+		return nil, fmt.Errorf("Rate limits should come from a JSON file")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading rate limits: %v", err)
+	}
+
+	var limits RateLimits
+	err = json.Unmarshal(data, &limits)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding rate limits: %v", err)
+	}
+
+	return &limits, nil
+}
+
+// SetRateLimits configures rate limits. Requests over a limit fail with a 429
+// and a `Stripe-Should-Retry` header, which lets clients exercise their retry
+// and backoff logic.
+func (s *StubServer) SetRateLimits(limits *RateLimits) error {
+	if limits.Read < 0 || limits.Write < 0 {
+		return fmt.Errorf("rate limits can't be negative")
+	}
+
+	for operation, limit := range limits.Operations {
+		if limit < 0 {
+			return fmt.Errorf("rate limit for '%s' can't be negative", operation)
+		}
+
+		if !s.isOperation(operation) {
+			return fmt.Errorf("'%s' isn't an operation like `POST /v1/payment_intents`", operation)
+		}
+	}
+
+	s.rateLimiter.mu.Lock()
+	defer s.rateLimiter.mu.Unlock()
+
+	s.rateLimiter.limits = *limits
+	s.rateLimiter.buckets = make(map[string]*rateLimitBucket)
+	return nil
+}
+
+//
+// Private constants
+//
+
+const (
+	codeRateLimit = "rate_limit"
+
+	rateLimitExceeded = "Request rate limit exceeded. Learn more about rate " +
+		"limits here https://stripe.com/docs/rate-limits."
+
+	typeRateLimitError = "rate_limit_error"
+)
+
+//
+// Private types
+//
+
+// rateLimitBucket is a token bucket that holds up to a second's worth of
+// requests and refills continuously.
+type rateLimitBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter tracks the requests made by each API key against the configured
+// rate limits.
+type rateLimiter struct {
+	// buckets are keyed by API key and the name of the limit that they
+	// track.
+	buckets map[string]*rateLimitBucket
+
+	limits RateLimits
+	mu     sync.Mutex
+
+	// now gets the current time. It's only replaced in tests.
+	now func() time.Time
+}
+
+//
+// Private functions
+//
+
+// checkRateLimits makes sure that a request made with an API key is within
+// the configured rate limits, and counts it against them.
+func (s *StubServer) checkRateLimits(key string, r *http.Request, route *stubServerRoute) *ResponseError {
+	s.rateLimiter.mu.Lock()
+	defer s.rateLimiter.mu.Unlock()
+
+	limiter := &s.rateLimiter
+
+	globalName, globalLimit := "write", limiter.limits.Write
+	if r.Method == http.MethodGet {
+		globalName, globalLimit = "read", limiter.limits.Read
+	}
+
+	operation := r.Method + " " + string(route.path)
+	operationLimit := limiter.limits.Operations[operation]
+
+	// Both limits have to have room before the request is counted against
+	// either of them.
+	if !limiter.hasRoom(key, globalName, globalLimit) || !limiter.hasRoom(key, operation, operationLimit) {
+		stripeError := createStripeError(typeRateLimitError, rateLimitExceeded)
+		stripeError.ErrorInfo.Code = codeRateLimit
+		return stripeError
+	}
+
+	limiter.take(key, globalName, globalLimit)
+	limiter.take(key, operation, operationLimit)
+	return nil
+}
+
+// isOperation checks whether a string names one of the server's operations
+// by its method and its path exactly as it appears in the OpenAPI spec, like
+// `POST /v1/payment_intents`.
+func (s *StubServer) isOperation(operation string) bool {
+	parts := strings.SplitN(operation, " ", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	for _, route := range s.routes[spec.HTTPVerb(strings.ToUpper(parts[0]))] {
+		if route.path == spec.Path(parts[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket gets the bucket tracking an API key's requests against a limit,
+// refilled for the time that's passed since it was last used.
+func (l *rateLimiter) bucket(key, name string, limit int) *rateLimitBucket {
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+
+	bucketKey := key + " " + name
+	bucket, ok := l.buckets[bucketKey]
+	if !ok {
+		bucket = &rateLimitBucket{tokens: float64(limit), updated: now}
+		l.buckets[bucketKey] = bucket
+	}
+
+	elapsed := now.Sub(bucket.updated).Seconds()
+	bucket.tokens = math.Min(float64(limit), bucket.tokens+elapsed*float64(limit))
+	bucket.updated = now
+	return bucket
+}
+
+// hasRoom checks whether an API key can make another request within a limit.
+// A limit of zero means unlimited.
+func (l *rateLimiter) hasRoom(key, name string, limit int) bool {
+	if limit == 0 {
+		return true
+	}
+	return l.bucket(key, name, limit).tokens >= 1
+}
+
+// take counts a request made by an API key against a limit.
+func (l *rateLimiter) take(key, name string, limit int) {
+	if limit == 0 {
+		return
+	}
+	l.bucket(key, name, limit).tokens--
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestLoadRateLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rate_limits.json")
+	err := os.WriteFile(path,
+		[]byte(`{"read": 100, "operations": {"POST /v1/customers": 5}}`), 0o600)
+	assert.NoError(t, err)
+
+	limits, err := LoadRateLimits(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, limits.Read)
+	assert.Equal(t, 0, limits.Write)
+	assert.Equal(t, 5, limits.Operations["POST /v1/customers"])
+
+	_, err = LoadRateLimits(filepath.Join(t.TempDir(), "rate_limits.yaml"))
+	assert.Error(t, err)
+}
+
+func TestSetRateLimits(t *testing.T) {
+	server := getStubServer(t, nil)
+
+	assert.NoError(t, server.SetRateLimits(&RateLimits{
+		Operations: map[string]int{"POST /v1/customers": 5},
+		Read:       100,
+	}))
+
+	assert.Error(t, server.SetRateLimits(&RateLimits{Read: -1}))
+	assert.Error(t, server.SetRateLimits(&RateLimits{
+		Operations: map[string]int{"POST /v1/customers": -1},
+	}))
+	assert.Error(t, server.SetRateLimits(&RateLimits{
+		Operations: map[string]int{"POST /v1/nonexistent": 5},
+	}))
+	assert.Error(t, server.SetRateLimits(&RateLimits{
+		Operations: map[string]int{"/v1/customers": 5},
+	}))
+}
+
+func TestRateLimits(t *testing.T) {
+	server := getStubServer(t, nil)
+	assert.NoError(t, server.SetRateLimits(&RateLimits{Read: 2}))
+
+	now := time.Unix(1600000000, 0)
+	server.rateLimiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges", "", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Stripe-Should-Retry"))
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, typeRateLimitError, errorInfo["type"])
+	assert.Equal(t, codeRateLimit, errorInfo["code"])
+
+	// Writes and other keys have their own limits
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/customers", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		getTenantHeaders("sk_test_other"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The limit refills over time
+	now = now.Add(500 * time.Millisecond)
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestRateLimits_Operation(t *testing.T) {
+	server := getStubServer(t, nil)
+	assert.NoError(t, server.SetRateLimits(&RateLimits{
+		Operations: map[string]int{"POST /v1/customers": 1},
+		Write:      3,
+	}))
+
+	now := time.Unix(1600000000, 0)
+	server.rateLimiter.now = func() time.Time { return now }
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/customers", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/customers", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Rejected requests don't count against the write limit, but requests to
+	// other operations do
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/payment_intents", "amount=500", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/payment_intents", "amount=500", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/payment_intents", "amount=500", getDefaultHeaders())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/stripe/stripe-mock/spec"
+)
+
+//
+// Private values
+//
+
+// magicIDPrefixes are prefixes of IDs that the real API accepts in test mode
+// without them ever having been created, like `pm_card_visa` or `tok_visa`.
+// References to them are never checked against the store.
+var magicIDPrefixes = []string{
+	"pm_auBecsDebit",
+	"pm_bacsDebit",
+	"pm_card_",
+	"pm_sepaDebit",
+	"pm_usBankAccount",
+	"src_",
+	"tok_",
+}
+
+// unreferencingParams are request parameters whose values never reference
+// other objects, so they're skipped when checking references.
+var unreferencingParams = map[string]bool{
+	"expand":   true,
+	"metadata": true,
+}
+
+//
+// Private functions
+//
+
+// checkReferences cross-checks ID parameters in a request's data against an
+// object store. Which parameters reference objects is found in the request's
+// schema (see schemaReferencedResource), and a parameter's value is only
+// considered a reference if it has the ID prefix of the resource that it's
+// for (like `cus_`).
+//
+// Returns a `resource_missing` error for the first parameter that references
+// an object that doesn't exist, like the real API does, or nil if all
+// references are valid.
+func (s *StubServer) checkReferences(store *ObjectStore, schema *spec.Schema, requestData map[string]interface{}) *ResponseError {
+	return s.checkReferencesInValue(store, schema, "", requestData)
+}
+
+// checkReferencesInValue is checkReferences for a single value, which may be
+// a nested map or array, and the schema that it's described by. param is the
+// full form-encoded name of the parameter that the value was found under,
+// like `line_items[0][price]`.
+func (s *StubServer) checkReferencesInValue(store *ObjectStore, schema *spec.Schema, param string, value interface{}) *ResponseError {
+	if schema == nil {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			if !unreferencingParams[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			subParam := key
+			if param != "" {
+				subParam = fmt.Sprintf("%s[%s]", param, key)
+			}
+
+			stripeError := s.checkReferencesInValue(store, s.propertySchema(schema, key), subParam, v[key])
+			if stripeError != nil {
+				return stripeError
+			}
+		}
+
+	case []interface{}:
+		itemsSchema := s.itemsSchema(schema)
+		for i, item := range v {
+			stripeError := s.checkReferencesInValue(store, itemsSchema,
+				fmt.Sprintf("%s[%d]", param, i), item)
+			if stripeError != nil {
+				return stripeError
+			}
+		}
+
+	case string:
+		resourceID := s.referencedResource(schema, v)
+		if resourceID == "" {
+			return nil
+		}
+
+		if _, ok := store.Get(v); !ok {
+			return createResourceMissingError(resourceID, v, param)
+		}
+	}
+
+	return nil
+}
+
+// initializeResourceIDPrefixes records the ID prefix of every resource that
+// can be created through the API (see initializeCreatableResources), as found
+// in its fixture. For example, `cus` for `customer` because its fixture has an
+// ID like `cus_123`. Resources whose fixtures don't have a prefixed ID (e.g.
+// coupons, whose IDs are chosen by the user) aren't recorded.
+//
+// The reverse is recorded too, so that a resource can be found from the prefix
+// in a parameter's pattern. Where several resources share a prefix, the first
+// of them in alphabetical order is used.
+func (s *StubServer) initializeResourceIDPrefixes() {
+	s.resourceIDPrefixes = make(map[string]string)
+	s.resourcesByIDPrefix = make(map[string]string)
+
+	resourceIDs := make([]string, 0, len(s.creatableResources))
+	for resourceID := range s.creatableResources {
+		resourceIDs = append(resourceIDs, resourceID)
+	}
+	sort.Strings(resourceIDs)
+
+	for _, resourceID := range resourceIDs {
+		fixture, ok := s.fixtures.Resources[spec.ResourceID(resourceID)].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		id, ok := fixture["id"].(string)
+		if !ok {
+			continue
+		}
+
+		i := strings.LastIndex(id, "_")
+		if i < 1 {
+			continue
+		}
+
+		prefix := id[:i]
+		s.resourceIDPrefixes[resourceID] = prefix
+		if _, ok := s.resourcesByIDPrefix[prefix]; !ok {
+			s.resourcesByIDPrefix[prefix] = resourceID
+		}
+	}
+}
+
+// isMagicID checks whether an ID is one that's accepted in test mode without
+// having been created (see magicIDPrefixes).
+func isMagicID(id string) bool {
+	for _, prefix := range magicIDPrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// referencedResource gets the resource ID of the object referenced by a
+// string value described by schema, or an empty string if it isn't a
+// reference to an object that can be created through the API.
+func (s *StubServer) referencedResource(schema *spec.Schema, value string) string {
+	if isMagicID(value) {
+		return ""
+	}
+
+	resourceID := s.schemaReferencedResource(schema)
+	prefix, ok := s.resourceIDPrefixes[resourceID]
+	if !ok || !strings.HasPrefix(value, prefix+"_") {
+		return ""
+	}
+
+	return resourceID
+}
+
+// schemaReferencedResource gets the resource that a parameter's schema says
+// it takes the ID of, or an empty string if it doesn't say. That's either the
+// first resource in its `x-expansionResources`, the first resource referenced
+// from its `anyOf` (as for an expandable field that's either an ID or the
+// object), or the resource whose ID prefix its `pattern` starts with (like
+// `^cus_` for `customer`).
+func (s *StubServer) schemaReferencedResource(schema *spec.Schema) string {
+	for _, branch := range s.schemaBranches(schema) {
+		if branch.XExpansionResources != nil {
+			for _, expansionSchema := range branch.XExpansionResources.OneOf {
+				resourceID := s.resolveResourceID(expansionSchema)
+				if resourceID != "" {
+					return resourceID
+				}
+			}
+		}
+
+		for _, anyOfSchema := range branch.AnyOf {
+			if anyOfSchema.Ref == "" {
+				continue
+			}
+
+			resourceID := s.resolveResourceID(anyOfSchema)
+			if resourceID != "" {
+				return resourceID
+			}
+		}
+
+		if branch.Pattern != "" {
+			resourceID := s.resourcesByIDPrefix[idPrefixFromPattern(branch.Pattern)]
+			if resourceID != "" {
+				return resourceID
+			}
+		}
+	}
+
+	return ""
+}
+
+// propertySchema gets the schema of the property named key in an object
+// schema, looking through any `allOf`, `anyOf`, or `oneOf` that the object
+// is made of. Returns nil if there's no such property.
+func (s *StubServer) propertySchema(schema *spec.Schema, key string) *spec.Schema {
+	for _, branch := range s.schemaBranches(schema) {
+		if propertySchema, ok := branch.Properties[key]; ok {
+			return propertySchema
+		}
+	}
+	return nil
+}
+
+// itemsSchema gets the schema of the items of an array schema, looking
+// through any `allOf`, `anyOf`, or `oneOf` that the array is made of. Returns
+// nil if there's none.
+func (s *StubServer) itemsSchema(schema *spec.Schema) *spec.Schema {
+	for _, branch := range s.schemaBranches(schema) {
+		if branch.Items != nil {
+			return branch.Items
+		}
+	}
+	return nil
+}
+
+// schemaBranches gets a schema and the schemas in its `allOf`, `anyOf`, and
+// `oneOf`, recursively and in that order, with references dereferenced.
+// References that can't be found are left out.
+func (s *StubServer) schemaBranches(schema *spec.Schema) []*spec.Schema {
+	var branches []*spec.Schema
+	seen := make(map[*spec.Schema]bool)
+
+	var visit func(schema *spec.Schema)
+	visit = func(schema *spec.Schema) {
+		if schema != nil && schema.Ref != "" {
+			schema = s.spec.Components.Schemas[definitionFromJSONPointer(schema.Ref)]
+		}
+		if schema == nil || seen[schema] {
+			return
+		}
+		seen[schema] = true
+		branches = append(branches, schema)
+
+		for _, subSchemas := range [][]*spec.Schema{schema.AllOf, schema.AnyOf, schema.OneOf} {
+			for _, subSchema := range subSchemas {
+				visit(subSchema)
+			}
+		}
+	}
+	visit(schema)
+
+	return branches
+}
+
+// idPrefixFromPattern gets the ID prefix that strings matching an anchored
+// pattern must start with, like `cus` for `^cus_[a-zA-Z0-9]+$`. Returns an
+// empty string if the pattern doesn't require one.
+func idPrefixFromPattern(pattern string) string {
+	if !strings.HasPrefix(pattern, "^") {
+		return ""
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return ""
+	}
+
+	literal, _ := compiled.LiteralPrefix()
+	i := strings.LastIndex(literal, "_")
+	if i < 1 {
+		return ""
+	}
+	return literal[:i]
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-mock/spec"
+)
+
+func TestCheckReferences(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&customer=cus_nonexistent", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "resource_missing", errorInfo["code"])
+	assert.Equal(t, "customer", errorInfo["param"])
+	assert.Equal(t, "No such customer: 'cus_nonexistent'", errorInfo["message"])
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/customers",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	customerID := decodeObject(t, body)["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&customer="+customerID, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestCheckReferences_MagicIDs(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&payment_method=pm_card_visa", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestCheckReferences_Nested(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"items": {
+				Items: &spec.Schema{
+					Properties: map[string]*spec.Schema{
+						"default_customer": {
+							AnyOf: []*spec.Schema{
+								{Type: spec.TypeString},
+								{Ref: "#/components/schemas/customer"},
+							},
+						},
+					},
+					Type: spec.TypeObject,
+				},
+				Type: spec.TypeArray,
+			},
+			"metadata": metadataSchema(),
+		},
+		Type: spec.TypeObject,
+	}
+
+	err := server.checkReferences(testStore(server), schema, map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"default_customer": "cus_123"},
+		},
+		"metadata": map[string]interface{}{"customer": "cus_456"},
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, "items[0][default_customer]", err.ErrorInfo.Param)
+}
+
+func TestCheckReferences_Unreferencing(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	// Parameters whose schemas don't say that they reference an object
+	// aren't checked, whatever they look like.
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&description=cus_nonexistent&return_url=cus_nonexistent", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestCheckReferences_Stateless(t *testing.T) {
+	resp, _ := sendRequest(t, "POST", "/v1/payment_intents",
+		"amount=500&customer=cus_nonexistent", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestReferencedResource(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	expandable := &spec.Schema{
+		AnyOf: []*spec.Schema{
+			{Type: spec.TypeString},
+			{Ref: "#/components/schemas/customer"},
+		},
+	}
+	expansionResources := &spec.Schema{
+		Type: spec.TypeString,
+		XExpansionResources: &spec.ExpansionResources{
+			OneOf: []*spec.Schema{{Ref: "#/components/schemas/customer"}},
+		},
+	}
+	patterned := &spec.Schema{Pattern: "^cus_[a-zA-Z0-9]+$", Type: spec.TypeString}
+	plain := &spec.Schema{Type: spec.TypeString}
+
+	assert.Equal(t, "customer", server.referencedResource(expandable, "cus_123"))
+	assert.Equal(t, "customer", server.referencedResource(expansionResources, "cus_123"))
+	assert.Equal(t, "customer", server.referencedResource(patterned, "cus_123"))
+	assert.Equal(t, "customer", server.referencedResource(&spec.Schema{
+		AnyOf: []*spec.Schema{patterned, {Enum: []interface{}{""}, Type: spec.TypeString}},
+	}, "cus_123"))
+	assert.Equal(t, "", server.referencedResource(expandable, "not_a_customer"))
+	assert.Equal(t, "", server.referencedResource(plain, "cus_123"))
+	assert.Equal(t, "", server.referencedResource(&spec.Schema{
+		Pattern: "^pm_card_",
+		Type:    spec.TypeString,
+	}, "pm_card_visa"))
+}
+
+func TestIDPrefixFromPattern(t *testing.T) {
+	assert.Equal(t, "cus", idPrefixFromPattern("^cus_[a-zA-Z0-9]+$"))
+	assert.Equal(t, "cus", idPrefixFromPattern("^cus_"))
+	assert.Equal(t, "", idPrefixFromPattern("cus_"))
+	assert.Equal(t, "", idPrefixFromPattern("^(cus|pm)_"))
+	assert.Equal(t, "", idPrefixFromPattern("^[a-z]+$"))
+}
@@ -0,0 +1,457 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//
+// Private constants
+//
+
+const (
+	searchOperatorAnd = "AND"
+	searchOperatorOr  = "OR"
+)
+
+// maxSearchClauses is the maximum number of clauses allowed in a search
+// query.
+const maxSearchClauses = 10
+
+// minSearchSubstringLength is the minimum length of a value used with the
+// substring operator (`~`).
+const minSearchSubstringLength = 3
+
+// searchPageTokenPrefix prefixes the offset encoded in a search page token so
+// that a token from elsewhere isn't mistaken for one.
+const searchPageTokenPrefix = "page:"
+
+const (
+	searchPageInvalid  = "The page token '%s' is invalid."
+	searchQueryInvalid = "The search query '%s' is invalid: %s."
+)
+
+//
+// Private types
+//
+
+// searchClause is a single clause of a search query, like `email:"a@b.com"`,
+// `-status:"canceled"`, `amount>1000`, or `metadata["key"]:"value"`.
+type searchClause struct {
+	field string
+
+	// metadataKey is set for clauses on a metadata key, in which case field
+	// is `metadata`.
+	metadataKey string
+
+	// negated is true for clauses starting with `-`.
+	negated bool
+
+	// operator is one of `:`, `~`, `>`, `<`, `>=`, and `<=`.
+	operator string
+
+	// number is the value as a number, if it is one.
+	number *int64
+
+	value string
+}
+
+// searchQuery is a parsed search query.
+type searchQuery struct {
+	clauses []*searchClause
+
+	// operator is the operator (`AND` or `OR`) that joins the clauses. Stripe
+	// doesn't allow them to be mixed in a single query. Empty for queries with
+	// a single clause.
+	operator string
+}
+
+// searchQueryParser parses a search query. See parseSearchQuery.
+type searchQueryParser struct {
+	pos   int
+	query string
+}
+
+//
+// Private functions
+//
+
+// applyStatefulSearch fills a generated search result with stored objects
+// matching the request's `query`, paginated with `limit` and `page`.
+func (s *StubServer) applyStatefulSearch(sr *statefulRequest, generated map[string]interface{}) (map[string]interface{}, *ResponseError) {
+	objectType := s.listObjectType(sr.responseSchema)
+	if objectType == "" || !s.creatableResources[objectType] {
+		return generated, nil
+	}
+
+	rawQuery, _ := sr.requestData["query"].(string)
+	query, stripeError := parseSearchQueryParam(rawQuery)
+	if stripeError != nil {
+		return nil, stripeError
+	}
+
+	offset := 0
+	if page, ok := sr.requestData["page"].(string); ok {
+		offset, ok = decodeSearchPageToken(page)
+		if !ok {
+			stripeError := createStripeError(typeInvalidRequestError,
+				fmt.Sprintf(searchPageInvalid, page))
+			stripeError.ErrorInfo.Param = "page"
+			return nil, stripeError
+		}
+	}
+
+	matches := []interface{}{}
+	for _, object := range sr.store.List(objectType) {
+		if query.matches(object) {
+			matches = append(matches, object)
+		}
+	}
+
+	limit := defaultListLimit
+	if requestLimit, ok := toInt64(sr.requestData["limit"]); ok && requestLimit > 0 {
+		limit = int(requestLimit)
+	}
+
+	page := []interface{}{}
+	if offset < len(matches) {
+		page = matches[offset:]
+	}
+	hasMore := len(page) > limit
+	if hasMore {
+		page = page[:limit]
+	}
+
+	generated["data"] = page
+	generated["has_more"] = hasMore
+	generated["url"] = sr.request.URL.Path
+	if _, ok := generated["total_count"]; ok {
+		generated["total_count"] = len(matches)
+	}
+
+	// Like the generator, `next_page` is only included when there's more.
+	delete(generated, "next_page")
+	if hasMore {
+		generated["next_page"] = encodeSearchPageToken(offset + limit)
+	}
+
+	return generated, nil
+}
+
+// checkSearchQuery makes sure that the `query` sent to a search endpoint is
+// well-formed.
+func checkSearchQuery(route *stubServerRoute, requestData map[string]interface{}) *ResponseError {
+	if !strings.HasSuffix(string(route.path), "/search") {
+		return nil
+	}
+
+	query, ok := requestData["query"].(string)
+	if !ok {
+		return nil
+	}
+
+	_, stripeError := parseSearchQueryParam(query)
+	return stripeError
+}
+
+// decodeSearchPageToken decodes a token produced by encodeSearchPageToken back
+// into an offset.
+func decodeSearchPageToken(token string) (int, bool) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !strings.HasPrefix(string(decoded), searchPageTokenPrefix) {
+		return 0, false
+	}
+
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(decoded), searchPageTokenPrefix))
+	if err != nil || offset < 0 {
+		return 0, false
+	}
+	return offset, true
+}
+
+// encodeSearchPageToken encodes the offset of a page of search results into an
+// opaque token suitable for `next_page`.
+func encodeSearchPageToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%s%d", searchPageTokenPrefix, offset)))
+}
+
+// parseSearchQuery parses a query in Stripe's search query language. For
+// example:
+//
+//	email:"jenny@example.com" AND -status:"canceled"
+//	amount>1000 OR metadata["order_id"]:"6735"
+func parseSearchQuery(query string) (*searchQuery, error) {
+	p := &searchQueryParser{query: query}
+	parsed := &searchQuery{}
+
+	for {
+		p.skipSpaces()
+		clause, err := p.parseClause()
+		if err != nil {
+			return nil, err
+		}
+		parsed.clauses = append(parsed.clauses, clause)
+
+		p.skipSpaces()
+		if p.done() {
+			break
+		}
+
+		operatorPos := p.pos
+		operator := p.readWhile(func(c byte) bool { return c != ' ' })
+		if operator != searchOperatorAnd && operator != searchOperatorOr {
+			return nil, fmt.Errorf("expected AND or OR at position %d but found '%s'",
+				operatorPos, operator)
+		}
+		if parsed.operator != "" && parsed.operator != operator {
+			return nil, fmt.Errorf("AND and OR can't be combined in a single query")
+		}
+		parsed.operator = operator
+
+		if len(parsed.clauses) == maxSearchClauses {
+			return nil, fmt.Errorf("a query may have at most %d clauses", maxSearchClauses)
+		}
+	}
+
+	return parsed, nil
+}
+
+// parseSearchQueryParam is parseSearchQuery, but returns an error suitable for
+// sending back to the client.
+func parseSearchQueryParam(query string) (*searchQuery, *ResponseError) {
+	parsed, err := parseSearchQuery(query)
+	if err != nil {
+		stripeError := createStripeError(typeInvalidRequestError,
+			fmt.Sprintf(searchQueryInvalid, query, err))
+		stripeError.ErrorInfo.Param = "query"
+		return nil, stripeError
+	}
+	return parsed, nil
+}
+
+// searchValueString formats a field's value for comparing it to a string in a
+// query. Numbers decoded from fixtures are floats, but are formatted like the
+// integers they represent.
+func searchValueString(value interface{}) string {
+	if number, ok := value.(float64); ok && number == float64(int64(number)) {
+		return strconv.FormatInt(int64(number), 10)
+	}
+	return fmt.Sprint(value)
+}
+
+// done checks whether the parser has consumed the whole query.
+func (p *searchQueryParser) done() bool {
+	return p.pos >= len(p.query)
+}
+
+// expect consumes the given string, or returns an error if it's not next.
+func (p *searchQueryParser) expect(s string) error {
+	if !strings.HasPrefix(p.query[p.pos:], s) {
+		return fmt.Errorf("expected '%s' at position %d", s, p.pos)
+	}
+	p.pos += len(s)
+	return nil
+}
+
+// parseClause parses a single clause (see searchClause).
+func (p *searchQueryParser) parseClause() (*searchClause, error) {
+	clause := &searchClause{}
+
+	if !p.done() && p.query[p.pos] == '-' {
+		clause.negated = true
+		p.pos++
+	}
+
+	fieldPos := p.pos
+	clause.field = p.readWhile(func(c byte) bool {
+		return c == '_' || c == '.' ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	})
+	if clause.field == "" {
+		return nil, fmt.Errorf("expected a field at position %d", fieldPos)
+	}
+
+	if clause.field == "metadata" {
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		key, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		clause.metadataKey = key
+	}
+
+	for _, operator := range []string{">=", "<=", ":", "~", ">", "<"} {
+		if strings.HasPrefix(p.query[p.pos:], operator) {
+			clause.operator = operator
+			p.pos += len(operator)
+			break
+		}
+	}
+	if clause.operator == "" {
+		return nil, fmt.Errorf("expected one of :, ~, >, <, >=, or <= at position %d", p.pos)
+	}
+
+	valuePos := p.pos
+	quoted := !p.done() && p.query[p.pos] == '"'
+	if quoted {
+		value, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		clause.value = value
+	} else {
+		clause.value = p.readWhile(func(c byte) bool { return c != ' ' })
+		number, err := strconv.ParseInt(clause.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a quoted string or a number at position %d", valuePos)
+		}
+		clause.number = &number
+	}
+
+	switch clause.operator {
+	case "~":
+		if !quoted || len(clause.value) < minSearchSubstringLength {
+			return nil, fmt.Errorf("the ~ operator requires a quoted value of at least %d characters",
+				minSearchSubstringLength)
+		}
+
+	case ">", "<", ">=", "<=":
+		if clause.number == nil {
+			return nil, fmt.Errorf("the %s operator requires a numeric value at position %d",
+				clause.operator, valuePos)
+		}
+	}
+
+	return clause, nil
+}
+
+// parseQuoted parses a double-quoted string in which `\"` and `\\` are
+// escapes.
+func (p *searchQueryParser) parseQuoted() (string, error) {
+	start := p.pos
+	if err := p.expect(`"`); err != nil {
+		return "", err
+	}
+
+	var value strings.Builder
+	for !p.done() {
+		c := p.query[p.pos]
+		p.pos++
+
+		switch c {
+		case '"':
+			return value.String(), nil
+		case '\\':
+			if p.done() {
+				break
+			}
+			value.WriteByte(p.query[p.pos])
+			p.pos++
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return "", fmt.Errorf("unterminated string starting at position %d", start)
+}
+
+// readWhile consumes characters while they match a predicate and returns
+// them.
+func (p *searchQueryParser) readWhile(predicate func(c byte) bool) string {
+	start := p.pos
+	for !p.done() && predicate(p.query[p.pos]) {
+		p.pos++
+	}
+	return p.query[start:p.pos]
+}
+
+// skipSpaces consumes any spaces.
+func (p *searchQueryParser) skipSpaces() {
+	p.readWhile(func(c byte) bool { return c == ' ' })
+}
+
+// matches checks whether an object matches a search clause.
+func (c *searchClause) matches(object map[string]interface{}) bool {
+	var value interface{}
+	if c.metadataKey != "" {
+		metadata, _ := object["metadata"].(map[string]interface{})
+		value = metadata[c.metadataKey]
+	} else {
+		value = lookupField(object, c.field)
+	}
+
+	// An ID field may be expanded.
+	if id := referenceID(value); id != "" {
+		value = id
+	}
+
+	var matched bool
+	switch c.operator {
+	case ":":
+		if c.number != nil {
+			number, ok := toInt64(value)
+			matched = ok && number == *c.number
+		} else {
+			matched = value != nil && strings.EqualFold(searchValueString(value), c.value)
+		}
+
+	case "~":
+		matched = value != nil && strings.Contains(
+			strings.ToLower(searchValueString(value)), strings.ToLower(c.value))
+
+	default:
+		number, ok := toInt64(value)
+		if ok {
+			switch c.operator {
+			case ">":
+				matched = number > *c.number
+			case "<":
+				matched = number < *c.number
+			case ">=":
+				matched = number >= *c.number
+			case "<=":
+				matched = number <= *c.number
+			}
+		}
+	}
+
+	return matched != c.negated
+}
+
+// matches checks whether an object matches a search query.
+func (q *searchQuery) matches(object map[string]interface{}) bool {
+	for _, clause := range q.clauses {
+		matched := clause.matches(object)
+
+		if q.operator == searchOperatorOr && matched {
+			return true
+		}
+		if q.operator != searchOperatorOr && !matched {
+			return false
+		}
+	}
+
+	return q.operator != searchOperatorOr
+}
+
+// lookupField looks up a possibly nested field of an object, like `status` or
+// `billing_details.address.country`. Returns nil if it doesn't exist.
+func lookupField(object map[string]interface{}, field string) interface{} {
+	var value interface{} = object
+	for _, part := range strings.Split(field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[part]
+	}
+	return value
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	query, err := parseSearchQuery(`email:"jenny@example.com" AND -metadata["order_id"]:"6735" AND amount>=1000`)
+	assert.NoError(t, err)
+	assert.Equal(t, searchOperatorAnd, query.operator)
+	assert.Equal(t, 3, len(query.clauses))
+
+	assert.Equal(t, "email", query.clauses[0].field)
+	assert.Equal(t, ":", query.clauses[0].operator)
+	assert.Equal(t, "jenny@example.com", query.clauses[0].value)
+
+	assert.True(t, query.clauses[1].negated)
+	assert.Equal(t, "order_id", query.clauses[1].metadataKey)
+	assert.Equal(t, "6735", query.clauses[1].value)
+	assert.Nil(t, query.clauses[1].number)
+
+	assert.Equal(t, ">=", query.clauses[2].operator)
+	assert.Equal(t, int64(1000), *query.clauses[2].number)
+
+	query, err = parseSearchQuery(`name~"Jen\"ny"`)
+	assert.NoError(t, err)
+	assert.Equal(t, `Jen"ny`, query.clauses[0].value)
+}
+
+func TestParseSearchQuery_Invalid(t *testing.T) {
+	testCases := []string{
+		``,
+		`email`,
+		`email:jenny`,
+		`email:"jenny`,
+		`email:"a" AND`,
+		`email:"a" NOT name:"b"`,
+		`email:"a" AND name:"b" OR status:"c"`,
+		`name~"ab"`,
+		`amount>"1000"`,
+		`metadata:"a"`,
+		`metadata["key":"a"`,
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			_, err := parseSearchQuery(testCase)
+			assert.Error(t, err)
+		})
+	}
+
+	query := `a:"1"`
+	for i := 1; i < maxSearchClauses+1; i++ {
+		query += fmt.Sprintf(` AND a:"%d"`, i)
+	}
+	_, err := parseSearchQuery(query)
+	assert.Error(t, err)
+}
+
+func TestSearchQueryMatches(t *testing.T) {
+	object := map[string]interface{}{
+		"amount":   1000.0,
+		"customer": map[string]interface{}{"id": "cus_123"},
+		"email":    "Jenny@Example.com",
+		"metadata": map[string]interface{}{"order_id": "6735"},
+		"shipping": map[string]interface{}{"name": "Jenny Rosen"},
+		"status":   "active",
+	}
+
+	testCases := []struct {
+		query   string
+		matches bool
+	}{
+		{`email:"jenny@example.com"`, true},
+		{`email:"jenny"`, false},
+		{`email~"example"`, true},
+		{`-email:"jenny@example.com"`, false},
+		{`metadata["order_id"]:"6735"`, true},
+		{`metadata["missing"]:"6735"`, false},
+		{`amount:1000`, true},
+		{`amount>999 AND amount<1001`, true},
+		{`amount>1000`, false},
+		{`amount<=1000`, true},
+		{`customer:"cus_123"`, true},
+		{`shipping.name:"jenny rosen"`, true},
+		{`status:"canceled" OR status:"active"`, true},
+		{`status:"canceled" AND email~"jenny"`, false},
+		{`status:"canceled" OR email:"other@example.com"`, false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.query, func(t *testing.T) {
+			query, err := parseSearchQuery(testCase.query)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.matches, query.matches(object))
+		})
+	}
+}
+
+func TestSearchPageToken(t *testing.T) {
+	offset, ok := decodeSearchPageToken(encodeSearchPageToken(20))
+	assert.True(t, ok)
+	assert.Equal(t, 20, offset)
+
+	_, ok = decodeSearchPageToken("not a token")
+	assert.False(t, ok)
+}
+
+func TestApplyStatefulSearch(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	for i := 0; i < 3; i++ {
+		testStore(server).Put(map[string]interface{}{
+			"email":  fmt.Sprintf("user%d@example.com", i),
+			"id":     fmt.Sprintf("cus_%d", i),
+			"object": "customer",
+		})
+	}
+
+	search := func(params url.Values) map[string]interface{} {
+		resp, body := sendRequestToServer(t, server, "GET",
+			"/v1/customers/search?"+params.Encode(), "", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		return decodeObject(t, body)
+	}
+
+	result := search(url.Values{"query": {`email:"user1@example.com"`}})
+	assert.Equal(t, "search_result", result["object"])
+	assert.Equal(t, 1, len(result["data"].([]interface{})))
+	assert.Equal(t, false, result["has_more"])
+	_, ok := result["next_page"]
+	assert.False(t, ok)
+
+	result = search(url.Values{"query": {`email~"example"`}, "limit": {"2"}})
+	data := result["data"].([]interface{})
+	assert.Equal(t, 2, len(data))
+	assert.Equal(t, "cus_2", data[0].(map[string]interface{})["id"])
+	assert.Equal(t, true, result["has_more"])
+
+	result = search(url.Values{
+		"limit": {"2"},
+		"page":  {result["next_page"].(string)},
+		"query": {`email~"example"`},
+	})
+	data = result["data"].([]interface{})
+	assert.Equal(t, 1, len(data))
+	assert.Equal(t, "cus_0", data[0].(map[string]interface{})["id"])
+	assert.Equal(t, false, result["has_more"])
+
+	resp, body := sendRequestToServer(t, server, "GET",
+		"/v1/customers/search?query=email%3A%22a%22&page=bogus", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "page", decodeObject(t, body)["error"].(map[string]interface{})["param"])
+}
+
+func TestCheckSearchQuery(t *testing.T) {
+	// Malformed queries are rejected even when not running in stateful mode
+	resp, body := sendRequest(t, "GET", "/v1/customers/search?query=email%3Ajenny",
+		"", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t, "query", errorInfo["param"])
+}
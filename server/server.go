@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -135,11 +136,24 @@ func (p *PathParamsSecondaryID) appendReplacedID(replacedID string) {
 // returned from Stripe's API.
 type ResponseError struct {
 	ErrorInfo struct {
+		Code    string `json:"code,omitempty"`
 		Message string `json:"message"`
+		Param   string `json:"param,omitempty"`
 		Type    string `json:"type"`
+
+		// PaymentIntent and SetupIntent are included with errors that
+		// involve an intent, like when one can't be confirmed.
+		PaymentIntent map[string]interface{} `json:"payment_intent,omitempty"`
+		SetupIntent   map[string]interface{} `json:"setup_intent,omitempty"`
 	} `json:"error"`
 }
 
+// Error returns the error's message so that it can be returned as an error,
+// like from the function given to ObjectStore.Update.
+func (e *ResponseError) Error() string {
+	return e.ErrorInfo.Message
+}
+
 // LoadFixtures load fixtures from a JSON file
 //
 // If path is empty, fixtures are loaded from internal embedded assets.
@@ -197,6 +211,11 @@ func LoadSpec(embeddedSpec []byte, specPath string) (*spec.Spec, error) {
 		return nil, fmt.Errorf("error decoding spec: %v", err)
 	}
 
+	err = stripeSpec.ResolveReferences()
+	if err != nil {
+		return nil, fmt.Errorf("error resolving spec references: %v", err)
+	}
+
 	return &stripeSpec, nil
 }
 
@@ -208,16 +227,63 @@ type StubServer struct {
 	spec               *spec.Spec
 	strictVersionCheck bool
 	verbose            bool
+
+	// populateOptionalFields makes synthetic fixtures include optional
+	// properties. See PopulateOptionalFields.
+	populateOptionalFields bool
+
+	// creatableResources is the set of resource IDs (e.g. `customer`) that
+	// can be created through the API. Only populated in stateful mode.
+	creatableResources map[string]bool
+
+	// resourceIDPrefixes maps creatable resource IDs to the prefix of their
+	// objects' IDs, like `cus` for `customer`. Only populated in stateful
+	// mode.
+	resourceIDPrefixes map[string]string
+
+	// resourcesByIDPrefix is the reverse of resourceIDPrefixes. Only
+	// populated in stateful mode.
+	resourcesByIDPrefix map[string]string
+
+	// cassette records requests and responses from an upstream server or
+	// replays them. See SetRecording and SetReplay.
+	cassette cassettePlayer
+
+	// faultInjector decides which requests get faults. See
+	// SetFaultInjection.
+	faultInjector faultInjector
+
+	// har holds captured traffic. See EnableHARCapture.
+	har harCapture
+
+	// latency is the latency added to requests. See SetLatencyProfile.
+	latency latencySimulator
+
+	// rateLimiter tracks requests against the configured rate limits. See
+	// SetRateLimits.
+	rateLimiter rateLimiter
+
+	// restrictedKeys holds the declared permissions of restricted keys. See
+	// SetRestrictedKeyPermissions.
+	restrictedKeys restrictedKeys
+
+	// store holds objects created through the API, partitioned by tenant
+	// (see tenantStore). It's nil unless the server is running in stateful
+	// mode.
+	store *ObjectStore
 }
 
 // NewStubServer creates a new instance of StubServer
-func NewStubServer(fixtures *spec.Fixtures, spec *spec.Spec, strictVersionCheck, verbose bool) (*StubServer, error) {
+func NewStubServer(fixtures *spec.Fixtures, spec *spec.Spec, strictVersionCheck, stateful, verbose bool) (*StubServer, error) {
 	s := StubServer{
 		fixtures:           fixtures,
 		spec:               spec,
 		strictVersionCheck: strictVersionCheck,
 		verbose:            verbose,
 	}
+	if stateful {
+		s.store = NewObjectStore()
+	}
 	err := s.initializeRouter()
 	if err != nil {
 		return nil, err
@@ -225,17 +291,54 @@ func NewStubServer(fixtures *spec.Fixtures, spec *spec.Spec, strictVersionCheck,
 	return &s, nil
 }
 
+// PopulateOptionalFields makes the synthetic fixtures that are generated for
+// objects without a fixture include their optional properties, rather than
+// only the ones that are required.
+func (s *StubServer) PopulateOptionalFields() {
+	s.populateOptionalFields = true
+}
+
 // HandleRequest handes an HTTP request directed at the API stub.
 func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	fmt.Printf("Request: %v %v\n", r.Method, r.URL.Path)
 
+	// Traffic is captured for HAR export if it's been enabled, except for
+	// requests that export it.
+	if r.URL.Path != harPath && s.har.capturing() {
+		var captured func()
+		w, captured = s.captureRequest(w, r, start)
+		defer captured()
+	}
+
+	// Administrative endpoints that control stripe-mock itself.
+	if r.URL.Path == harPath {
+		s.handleHAR(w, r, start)
+		return
+	}
+	if r.URL.Path == tenantPath {
+		s.handleTenant(w, r, start)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, restrictedKeysPath) {
+		s.handleRestrictedKeys(w, r, start)
+		return
+	}
+
+	// Pages meant for browsers (like the simulated 3-D Secure page) aren't
+	// part of the API and don't require authentication.
+	if strings.HasPrefix(r.URL.Path, pagePathPrefix) {
+		s.handlePage(w, r, start)
+		return
+	}
+
 	//
 	// Validate headers
 	//
 
 	auth := r.Header.Get("Authorization")
-	if !validateAuth(auth) {
+	key, ok := validateAuth(auth)
+	if !ok {
 		message := fmt.Sprintf(invalidAuthorization, auth)
 		stripeError := createStripeError(typeInvalidRequestError, message)
 		writeResponse(w, r, start, http.StatusUnauthorized, stripeError)
@@ -270,6 +373,28 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	// Every response needs a Request-Id header except the invalid authorization
 	w.Header().Set("Request-Id", "req_123")
 
+	// Requests made on behalf of a connected account have the account
+	// reflected back like the Stripe API does. In stateful mode, they act on
+	// the account's objects rather than the platform's.
+	stripeAccount := r.Header.Get("Stripe-Account")
+	stripeError := checkStripeAccountHeader(stripeAccount)
+	if stripeError != nil {
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+	if stripeAccount != "" {
+		w.Header().Set("Stripe-Account", stripeAccount)
+	}
+
+	var store *ObjectStore
+	if s.store != nil {
+		store, stripeError = requestStore(s.tenantStore(key), stripeAccount)
+		if stripeError != nil {
+			writeResponse(w, r, start, http.StatusForbidden, stripeError)
+			return
+		}
+	}
+
 	//
 	// Route request
 	//
@@ -290,6 +415,39 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Like the real API, requests over a rate limit can be retried after
+	// backing off.
+	stripeError = s.checkRateLimits(key, r, route)
+	if stripeError != nil {
+		w.Header().Set("Stripe-Should-Retry", "true")
+		writeResponse(w, r, start, http.StatusTooManyRequests, stripeError)
+		return
+	}
+
+	// Simulated latency is added before anything else is done with the
+	// request.
+	stripeError = s.delayRequest(r, route)
+	if stripeError != nil {
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	// Injected faults either fail the request outright, or let it be carried
+	// out and then interfere with its response.
+	fault, timeout := s.chooseFault(r, route)
+	switch fault {
+	case faultError:
+		w.Header().Set("Stripe-Should-Retry", "true")
+		writeResponse(w, r, start, http.StatusInternalServerError, createInjectedError())
+		return
+
+	case faultDisconnect, faultTimeout:
+		fmt.Printf("Injecting fault: %s\n", fault)
+		buffered := newBufferedResponseWriter(w)
+		defer deliverFault(fault, timeout, w, r, buffered)
+		w = buffered
+	}
+
 	response, ok := route.operation.Responses["200"]
 	if !ok {
 		fmt.Printf("Couldn't find 200 response in spec\n")
@@ -313,12 +471,44 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Restricted keys may only make requests that they have permission for.
+	stripeError = s.checkPermissions(key, r, responseContent.Schema)
+	if stripeError != nil {
+		writeResponse(w, r, start, http.StatusForbidden, stripeError)
+		return
+	}
+
+	// Publishable keys may only make the requests that Stripe.js and the
+	// mobile SDKs make.
+	var clientSecretRequired bool
+	if isPublishableKey(key) {
+		clientSecretRequired, stripeError = checkPublishableKeyRoute(r, route)
+		if stripeError != nil {
+			writeResponse(w, r, start, http.StatusForbidden, stripeError)
+			return
+		}
+	}
+
 	if s.verbose {
 		fmt.Printf("IDs extracted from route: %+v\n", pathParams)
 		fmt.Printf("Response schema: %s\n", responseContent.Schema)
 	}
 
-	requestData, err := param.ParseParams(r)
+	// The raw body is kept so that it can be forwarded upstream while
+	// recording.
+	var body []byte
+	if s.cassette.active() && r.Body != nil {
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			message := fmt.Sprintf("Couldn't read body: %v", err)
+			stripeError := createStripeError(typeInvalidRequestError, message)
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	requestData, formData, err := parseRequestData(r)
 	if err != nil {
 		message := fmt.Sprintf("Couldn't parse query/body: %v", err)
 		fmt.Printf(message + "\n")
@@ -335,21 +525,61 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if clientSecretRequired {
+		stripeError = checkClientSecret(store, pathParams, requestData)
+		if stripeError != nil {
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+	}
+
 	// Note that requestData is actually manipulated in place, but we show it
 	// returned here to make it clear that this function will be manipulating
 	// it.
-	requestData, stripeError := validateAndCoerceRequest(r, route, requestData)
+	requestData, stripeError = validateAndCoerceRequest(r, route, s.spec.Components.Schemas, requestData, formData)
 	if stripeError != nil {
 		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
 		return
 	}
 
+	// In stateful mode, objects referenced by the request must exist.
+	if s.store != nil {
+		stripeError = s.checkReferences(store, route.requestSchema, requestData)
+		if stripeError != nil {
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+	}
+
 	expansions, rawExpansions := extractExpansions(requestData)
 	if s.verbose {
 		fmt.Printf("Expansions: %+v\n", rawExpansions)
 	}
 
-	generator := DataGenerator{s.spec.Components.Schemas, s.fixtures, s.verbose}
+	stripeError = checkExpansionDepth(rawExpansions)
+	if stripeError != nil {
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	stripeError = checkSearchQuery(route, requestData)
+	if stripeError != nil {
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	// Requests that have passed validation may be answered by an upstream
+	// server or a recording of one.
+	if s.cassette.active() && s.serveFromCassette(w, r, start, body, requestData) {
+		return
+	}
+
+	generator := DataGenerator{
+		definitions:            s.spec.Components.Schemas,
+		fixtures:               s.fixtures,
+		populateOptionalFields: s.populateOptionalFields,
+		verbose:                s.verbose,
+	}
 	responseData, err := generator.Generate(&GenerateParams{
 		Expansions:    expansions,
 		PathParams:    pathParams,
@@ -364,6 +594,33 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 			createInternalServerError())
 		return
 	}
+
+	// In stateful mode, the generated response is reconciled with objects that
+	// were created by previous requests.
+	if s.store != nil {
+		var status int
+		responseData, status, stripeError = s.applyStatefulBehavior(&statefulRequest{
+			expansions:     expansions,
+			pathParams:     pathParams,
+			request:        r,
+			requestData:    requestData,
+			responseData:   responseData,
+			responseSchema: responseContent.Schema,
+			route:          route,
+			store:          store,
+			stripeAccount:  stripeAccount,
+			tenant:         apiKeyTenant(key),
+		})
+		if stripeError != nil {
+			writeResponse(w, r, start, status, stripeError)
+			return
+		}
+
+		// Expanded fields are filled with the objects they reference rather
+		// than with fixtures.
+		responseData = expandFromStore(store, responseData, expansions)
+	}
+
 	if s.verbose {
 		responseDataJSON, err := json.MarshalIndent(responseData, "", "  ")
 		if err != nil {
@@ -455,6 +712,7 @@ func (s *StubServer) initializeRouter() error {
 				hasPrimaryID:     hasPrimaryID,
 				pattern:          pathPattern,
 				operation:        operation,
+				path:             path,
 				pathParamNames:   pathParamNames,
 				requestMediaType: requestMediaType,
 				requestSchema:    requestSchema,
@@ -482,6 +740,11 @@ func (s *StubServer) initializeRouter() error {
 		})
 	}
 
+	if s.store != nil {
+		s.initializeCreatableResources()
+		s.initializeResourceIDPrefixes()
+	}
+
 	fmt.Printf("Routing to %v path(s) and %v endpoint(s) with %v validator(s)\n",
 		numPaths, numEndpoints, numValidators)
 	return nil
@@ -580,6 +843,9 @@ const (
 	contentTypeEmpty      = "Request's `Content-Type` header was empty. Expected: `%s`."
 	contentTypeMismatched = "Request's `Content-Type` didn't match the path's expected media type. Expected: `%s`. Was: `%s`."
 
+	// formMediaType is the media type of most of the API's request bodies.
+	formMediaType = "application/x-www-form-urlencoded"
+
 	invalidAuthorization = "Please authenticate by specifying an " +
 		"`Authorization` header with any valid looking testmode secret API " +
 		"key. For example, `Authorization: Bearer sk_test_123`. " +
@@ -639,6 +905,7 @@ var pathParameterPattern = regexp.MustCompile(`\{(\w+)\}`)
 type stubServerRoute struct {
 	hasPrimaryID     bool
 	operation        *spec.Operation
+	path             spec.Path
 	pathParamNames   []string
 	pattern          *regexp.Regexp
 	requestMediaType *string
@@ -688,15 +955,10 @@ func createInternalServerError() *ResponseError {
 
 // This creates a Stripe error to return in case of API errors.
 func createStripeError(errorType string, errorMessage string) *ResponseError {
-	return &ResponseError{
-		ErrorInfo: struct {
-			Message string `json:"message"`
-			Type    string `json:"type"`
-		}{
-			Message: errorMessage,
-			Type:    errorType,
-		},
-	}
+	stripeError := &ResponseError{}
+	stripeError.ErrorInfo.Message = errorMessage
+	stripeError.ErrorInfo.Type = errorType
+	return stripeError
 }
 
 func extractExpansions(data map[string]interface{}) (*ExpansionLevel, []string) {
@@ -737,6 +999,15 @@ func getRequestBodySchema(operation *spec.Operation) (*string, *spec.Schema) {
 		return nil, nil
 	}
 
+	// Operations that accept more than one media type have the same schema
+	// for each, but the form media type is preferred so that the one
+	// reported in errors doesn't change between runs.
+	for _, mediaType := range []string{formMediaType, param.JSONMediaType} {
+		if content, ok := operation.RequestBody.Content[mediaType]; ok {
+			return &mediaType, content.Schema
+		}
+	}
+
 	for mediaType, spec := range operation.RequestBody.Content {
 		return &mediaType, spec.Schema
 	}
@@ -748,6 +1019,38 @@ func isCurl(userAgent string) bool {
 	return strings.HasPrefix(userAgent, "curl/")
 }
 
+// isJSONRequest checks whether a request has a JSON-encoded body.
+func isJSONRequest(r *http.Request) bool {
+	contentType := strings.Split(r.Header.Get("Content-Type"), ";")[0]
+	return r.Method != http.MethodGet && strings.TrimSpace(contentType) == param.JSONMediaType
+}
+
+// parseRequestData parses the parameters of a request. Along with all of
+// them, it returns the ones that were encoded as strings and need coercing:
+// all of them for a form-encoded request, or just those in the query string
+// (and not overridden by the body) for a JSON-encoded one.
+func parseRequestData(r *http.Request) (map[string]interface{}, map[string]interface{}, error) {
+	if !isJSONRequest(r) {
+		requestData, err := param.ParseParams(r)
+		return requestData, requestData, err
+	}
+
+	queryData, bodyData, err := param.ParseJSONParams(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	requestData := make(map[string]interface{}, len(queryData)+len(bodyData))
+	for key, value := range queryData {
+		requestData[key] = value
+	}
+	for key, value := range bodyData {
+		requestData[key] = value
+		delete(queryData, key)
+	}
+	return requestData, queryData, nil
+}
+
 // parseExpansionLevel parses a set of raw expansions from a request query
 // string or form and produces a structure more useful for performing actual
 // expansions.
@@ -782,12 +1085,17 @@ func parseExpansionLevel(raw []string) *ExpansionLevel {
 // schema and does parameter coercion.
 //
 // Firstly, `Content-Type` is checked against the schema's media type, then
-// string-encoded parameters are coerced to expected types (where possible).
-// Finally, we validate the incoming payload against the schema.
+// string-encoded parameters (formData, which is part of requestData; see
+// parseRequestData) are coerced to expected types (where possible).
+// Finally, we validate the incoming payload against the schema and check the
+// formats of its values (like `unix-time` or `email`), with definitions used to
+// resolve references.
 func validateAndCoerceRequest(
 	r *http.Request,
 	route *stubServerRoute,
-	requestData map[string]interface{}) (map[string]interface{}, *ResponseError) {
+	definitions map[string]*spec.Schema,
+	requestData map[string]interface{},
+	formData map[string]interface{}) (map[string]interface{}, *ResponseError) {
 
 	// We only check content type on non-`GET` non-`DELETE` requests.
 	//
@@ -812,19 +1120,27 @@ func validateAndCoerceRequest(
 		// We want to chop off the `; charset=utf-8` at the end.
 		contentType = strings.Split(contentType, ";")[0]
 
-		if contentType != *route.requestMediaType {
+		// JSON is accepted by every operation in addition to the media type
+		// in the spec.
+		if contentType != *route.requestMediaType && contentType != param.JSONMediaType {
 			message := fmt.Sprintf(contentTypeMismatched, *route.requestMediaType, contentType)
 			fmt.Printf(message + "\n")
 			return nil, createStripeError(typeInvalidRequestError, message)
 		}
 	}
 
-	err := coercer.CoerceParams(route.requestSchema, requestData)
+	// Parameters decoded from JSON already have the types they're meant to
+	// have, so only those decoded from strings are coerced. For a JSON
+	// request, those are the ones in its query string.
+	err := coercer.CoerceParams(route.requestSchema, formData)
 	if err != nil {
 		message := fmt.Sprintf("Request coercion error: %v", err)
 		fmt.Printf(message + "\n")
 		return nil, createStripeError(typeInvalidRequestError, message)
 	}
+	for key, value := range formData {
+		requestData[key] = value
+	}
 
 	fmt.Printf("Request data = %+v\n", requestData)
 	err = route.requestValidator.Validate(requestData)
@@ -834,20 +1150,31 @@ func validateAndCoerceRequest(
 		return nil, createStripeError(typeInvalidRequestError, message)
 	}
 
+	formatErr := spec.CheckFormats(route.requestSchema, definitions, requestData)
+	if formatErr != nil {
+		fmt.Printf("Request validation error: %v\n", formatErr)
+		stripeError := createStripeError(typeInvalidRequestError, formatErr.Message)
+		stripeError.ErrorInfo.Code = formatErr.Code
+		stripeError.ErrorInfo.Param = formatErr.Param
+		return nil, stripeError
+	}
+
 	// All checks were successful.
 	return requestData, nil
 }
 
-func validateAuth(auth string) bool {
+// validateAuth checks that an `Authorization` header carries a test mode
+// secret, restricted, or publishable key, and returns the key if it does.
+func validateAuth(auth string) (string, bool) {
 	if auth == "" {
-		return false
+		return "", false
 	}
 
 	parts := strings.Split(auth, " ")
 
 	// Expect ["Bearer", "sk_test_123"] or ["Basic", "aaaaa"]
 	if len(parts) != 2 || parts[1] == "" {
-		return false
+		return "", false
 	}
 
 	var key string
@@ -855,38 +1182,42 @@ func validateAuth(auth string) bool {
 	case "Basic":
 		keyBytes, err := base64.StdEncoding.DecodeString(parts[1])
 		if err != nil {
-			return false
+			return "", false
 		}
-		key = string(keyBytes)
+
+		// The credential is `<username>:<password>`, where the key is the
+		// username and the password is left empty (as in curl's `-u
+		// sk_test_123:`).
+		key = strings.SplitN(string(keyBytes), ":", 2)[0]
 
 	case "Bearer":
 		key = parts[1]
 
 	default:
-		return false
+		return "", false
 	}
 
 	keyParts := strings.Split(key, "_")
 
 	// Expect ["sk", "test", "123"]
 	if len(keyParts) != 3 {
-		return false
+		return "", false
 	}
 
-	if keyParts[0] != "rk" && keyParts[0] != "sk" {
-		return false
+	if keyParts[0] != "pk" && keyParts[0] != "rk" && keyParts[0] != "sk" {
+		return "", false
 	}
 
 	if keyParts[1] != "test" {
-		return false
+		return "", false
 	}
 
 	// Expect something (anything but an empty string) in the third position
 	if len(keyParts[2]) == 0 {
-		return false
+		return "", false
 	}
 
-	return true
+	return key, true
 }
 
 func writeResponse(w http.ResponseWriter, r *http.Request, start time.Time, status int, data interface{}) {
@@ -894,6 +1225,11 @@ func writeResponse(w http.ResponseWriter, r *http.Request, start time.Time, stat
 		data = http.StatusText(status)
 	}
 
+	// Errors from the v2 API have a different shape.
+	if stripeError, ok := data.(*ResponseError); ok && isV2Path(r.URL.Path) {
+		data = newV2ResponseError(stripeError)
+	}
+
 	var encodedData []byte
 	var err error
 
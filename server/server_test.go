@@ -10,7 +10,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
 	"runtime"
 	"testing"
 
@@ -27,6 +29,8 @@ var chargeCreateMethod *spec.Operation
 var chargeGetMethod *spec.Operation
 var customerDeleteMethod *spec.Operation
 var invoicePayMethod *spec.Operation
+var paymentIntentCreateMethod *spec.Operation
+var paymentIntentListMethod *spec.Operation
 var quotePdfMethod *spec.Operation
 
 // Try to avoid using the real spec as much as possible because it's more
@@ -191,19 +195,177 @@ func initTestSpec() {
 	// `POST` to `/pay` on an invoice).
 	invoicePayMethod = &spec.Operation{}
 
+	paymentIntentCreateMethod = getFormOperation(
+		map[string]*spec.Schema{
+			"amount":         {Type: spec.TypeInteger},
+			"capture_method": {Type: spec.TypeString},
+			"confirm":        {Type: spec.TypeBoolean},
+			"customer":       {Pattern: "^cus_", Type: spec.TypeString},
+			"description":    {Type: spec.TypeString},
+			"metadata":       metadataSchema(),
+			"payment_method": {Type: spec.TypeString},
+			"return_url":     {Type: spec.TypeString},
+		},
+		[]string{"amount"},
+		"#/components/schemas/payment_intent",
+	)
+
+	paymentIntentListMethod = &spec.Operation{
+		Parameters: []*spec.Parameter{
+			{
+				In:   spec.ParameterQuery,
+				Name: "created",
+				Schema: &spec.Schema{
+					// As loaded from JSON, where additionalProperties is
+					// omitted on a schema with only anyOf.
+					AdditionalPropertiesAllowed: true,
+					AnyOf: []*spec.Schema{
+						{
+							Properties: map[string]*spec.Schema{
+								"gt":  {Type: spec.TypeInteger},
+								"gte": {Type: spec.TypeInteger},
+								"lt":  {Type: spec.TypeInteger},
+								"lte": {Type: spec.TypeInteger},
+							},
+							Type: spec.TypeObject,
+						},
+						{Type: spec.TypeInteger},
+					},
+				},
+			},
+			{In: spec.ParameterQuery, Name: "customer", Schema: &spec.Schema{Type: spec.TypeString}},
+			{In: spec.ParameterQuery, Name: "ending_before", Schema: &spec.Schema{Type: spec.TypeString}},
+			{In: spec.ParameterQuery, Name: "limit", Schema: &spec.Schema{Type: spec.TypeInteger}},
+			{In: spec.ParameterQuery, Name: "starting_after", Schema: &spec.Schema{Type: spec.TypeString}},
+		},
+		Responses: map[spec.StatusCode]spec.Response{
+			"200": {
+				Content: map[string]spec.MediaType{
+					"application/json": {
+						Schema: &spec.Schema{
+							Properties: map[string]*spec.Schema{
+								"data": {
+									Items: &spec.Schema{Ref: "#/components/schemas/payment_intent"},
+									Type:  spec.TypeArray,
+								},
+								"has_more": {Type: spec.TypeBoolean},
+								"object": {
+									Enum: []interface{}{"list"},
+									Type: spec.TypeString,
+								},
+								"url": {Type: spec.TypeString},
+							},
+							Type: spec.TypeObject,
+						},
+					},
+				},
+			},
+		},
+	}
+
 	testFixtures =
 		spec.Fixtures{
 			Resources: map[spec.ResourceID]interface{}{
+				spec.ResourceID("account"): map[string]interface{}{
+					"id":     "acct_123",
+					"object": "account",
+				},
 				spec.ResourceID("charge"): map[string]interface{}{
 					"customer": "cus_123",
 					"id":       "ch_123",
 				},
+				spec.ResourceID("checkout.session"): map[string]interface{}{
+					"amount_total":   1000,
+					"cancel_url":     "https://example.com/cancel",
+					"currency":       "usd",
+					"customer":       nil,
+					"customer_email": nil,
+					"id":             "cs_test_123",
+					"metadata":       map[string]interface{}{},
+					"mode":           "payment",
+					"object":         "checkout.session",
+					"payment_intent": nil,
+					"payment_status": "unpaid",
+					"setup_intent":   nil,
+					"status":         "open",
+					"subscription":   nil,
+					"success_url":    "https://example.com/success",
+					"url":            "https://checkout.stripe.com/c/pay/cs_test_123",
+				},
 				spec.ResourceID("customer"): map[string]interface{}{
-					"id": "cus_123",
+					"id":     "cus_123",
+					"object": "customer",
 				},
 				spec.ResourceID("deleted_customer"): map[string]interface{}{
 					"deleted": true,
 				},
+				spec.ResourceID("event"): map[string]interface{}{
+					"id":     "evt_123",
+					"object": "event",
+					"type":   "customer.created",
+				},
+				spec.ResourceID("item"): map[string]interface{}{
+					"id":       "li_123",
+					"object":   "item",
+					"quantity": 1,
+				},
+				spec.ResourceID("payment_intent"): map[string]interface{}{
+					"amount":              1099,
+					"amount_received":     0,
+					"canceled_at":         1234567890,
+					"cancellation_reason": nil,
+					"capture_method":      "automatic",
+					"client_secret":       "pi_123_secret_456",
+					"customer":            nil,
+					"id":                  "pi_123",
+					"metadata":            map[string]interface{}{},
+					"next_action":         map[string]interface{}{"type": "type"},
+					"object":              "payment_intent",
+					"payment_method":      nil,
+					"status":              "requires_payment_method",
+				},
+				spec.ResourceID("payment_method"): map[string]interface{}{
+					"id":     "pm_123",
+					"object": "payment_method",
+					"type":   "card",
+				},
+				spec.ResourceID("setup_intent"): map[string]interface{}{
+					"cancellation_reason": nil,
+					"id":                  "seti_123",
+					"metadata":            map[string]interface{}{},
+					"next_action":         map[string]interface{}{"type": "type"},
+					"object":              "setup_intent",
+					"payment_method":      nil,
+					"status":              "requires_payment_method",
+				},
+				spec.ResourceID("subscription"): map[string]interface{}{
+					"customer": "cus_123",
+					"id":       "sub_123",
+					"object":   "subscription",
+					"status":   "incomplete",
+				},
+				spec.ResourceID("v2.core.event"): map[string]interface{}{
+					"context": nil,
+					"created": "2024-10-01T00:00:00.000Z",
+					"id":      "evt_test_123",
+					"object":  "v2.core.event",
+					"related_object": map[string]interface{}{
+						"id":   "mtr_123",
+						"type": "billing.meter",
+						"url":  "/v1/billing/meters/mtr_123",
+					},
+					"type": "v1.billing.meter.error_report_triggered",
+				},
+				spec.ResourceID("v2.core.event_destination"): map[string]interface{}{
+					"enabled_events":   []interface{}{"v1.billing.meter.error_report_triggered"},
+					"event_payload":    "thin",
+					"id":               "ed_123",
+					"name":             "My destination",
+					"object":           "v2.core.event_destination",
+					"status":           "enabled",
+					"type":             "webhook_endpoint",
+					"webhook_endpoint": nil,
+				},
 			},
 		}
 
@@ -213,6 +375,10 @@ func initTestSpec() {
 		},
 		Components: spec.Components{
 			Schemas: map[string]*spec.Schema{
+				"account": {
+					Type:        "object",
+					XResourceID: "account",
+				},
 				"charge": {
 					Type: "object",
 					Properties: map[string]*spec.Schema{
@@ -234,6 +400,28 @@ func initTestSpec() {
 					XExpandableFields: &[]string{"customer"},
 					XResourceID:       "charge",
 				},
+				"checkout.session": {
+					Type: "object",
+					Properties: map[string]*spec.Schema{
+						"amount_total":   {Type: "integer", Nullable: true},
+						"cancel_url":     {Type: "string", Nullable: true},
+						"currency":       {Type: "string", Nullable: true},
+						"customer":       {Type: "string", Nullable: true},
+						"customer_email": {Type: "string", Nullable: true},
+						"id":             {Type: "string"},
+						"metadata":       metadataSchema(),
+						"mode":           {Type: "string"},
+						"object":         {Type: "string"},
+						"payment_intent": {Type: "string", Nullable: true},
+						"payment_status": {Type: "string"},
+						"setup_intent":   {Type: "string", Nullable: true},
+						"status":         {Type: "string", Nullable: true},
+						"subscription":   {Type: "string", Nullable: true},
+						"success_url":    {Type: "string", Nullable: true},
+						"url":            {Type: "string", Nullable: true},
+					},
+					XResourceID: "checkout.session",
+				},
 				"customer": {
 					Type:        "object",
 					XResourceID: "customer",
@@ -245,9 +433,128 @@ func initTestSpec() {
 					Type:        "object",
 					XResourceID: "deleted_customer",
 				},
+				"event": {
+					Type: "object",
+					Properties: map[string]*spec.Schema{
+						"id":     {Type: "string"},
+						"object": {Type: "string"},
+						"type":   {Type: "string"},
+					},
+					XResourceID: "event",
+				},
+				"item": {
+					Type: "object",
+					Properties: map[string]*spec.Schema{
+						"id":       {Type: "string"},
+						"object":   {Type: "string"},
+						"quantity": {Type: "integer", Nullable: true},
+					},
+					XResourceID: "item",
+				},
+				"payment_intent": {
+					Type: "object",
+					Properties: map[string]*spec.Schema{
+						"amount":              {Type: "integer"},
+						"amount_received":     {Type: "integer"},
+						"canceled_at":         {Type: "integer", Nullable: true},
+						"cancellation_reason": {Type: "string", Nullable: true},
+						"capture_method":      {Type: "string"},
+						"client_secret":       {Type: "string", Nullable: true},
+						"customer": {
+							AnyOf: []*spec.Schema{
+								{Type: "string"},
+								{Ref: "#/components/schemas/customer"},
+							},
+							Nullable: true,
+							XExpansionResources: &spec.ExpansionResources{
+								OneOf: []*spec.Schema{
+									{Ref: "#/components/schemas/customer"},
+								},
+							},
+						},
+						"id":             {Type: "string"},
+						"metadata":       metadataSchema(),
+						"next_action":    {Type: "object", Nullable: true},
+						"object":         {Type: "string"},
+						"payment_method": {Type: "string", Nullable: true},
+						"status":         {Type: "string"},
+					},
+					XExpandableFields: &[]string{"customer"},
+					XResourceID:       "payment_intent",
+				},
+				"payment_method": {
+					Properties: map[string]*spec.Schema{
+						"id":     {Type: "string"},
+						"object": {Type: "string"},
+						"type":   {Type: "string"},
+					},
+					Type:        "object",
+					XResourceID: "payment_method",
+				},
+				"setup_intent": {
+					Type: "object",
+					Properties: map[string]*spec.Schema{
+						"cancellation_reason": {Type: "string", Nullable: true},
+						"id":                  {Type: "string"},
+						"metadata":            metadataSchema(),
+						"next_action":         {Type: "object", Nullable: true},
+						"object":              {Type: "string"},
+						"payment_method":      {Type: "string", Nullable: true},
+						"status":              {Type: "string"},
+					},
+					XResourceID: "setup_intent",
+				},
+				"subscription": {
+					Type: "object",
+					Properties: map[string]*spec.Schema{
+						"customer": {Type: "string"},
+						"id":       {Type: "string"},
+						"object":   {Type: "string"},
+						"status":   {Type: "string"},
+					},
+					XResourceID: "subscription",
+				},
+				"v2.core.event": {
+					Type: "object",
+					Properties: map[string]*spec.Schema{
+						"context":        {Nullable: true, Type: "string"},
+						"created":        {Type: "string"},
+						"id":             {Type: "string"},
+						"object":         {Type: "string"},
+						"related_object": {Nullable: true, Type: "object"},
+						"type":           {Type: "string"},
+					},
+					XResourceID: "v2.core.event",
+				},
+				"v2.core.event_destination": {
+					Type: "object",
+					Properties: map[string]*spec.Schema{
+						"enabled_events": {
+							Items: &spec.Schema{Type: "string"},
+							Type:  "array",
+						},
+						"event_payload":    {Type: "string"},
+						"id":               {Type: "string"},
+						"name":             {Type: "string"},
+						"object":           {Type: "string"},
+						"status":           {Type: "string"},
+						"type":             {Type: "string"},
+						"webhook_endpoint": {Nullable: true, Type: "object"},
+					},
+					XResourceID: "v2.core.event_destination",
+				},
 			},
 		},
 		Paths: map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
+			spec.Path("/v1/accounts"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"type": {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/account",
+				),
+			},
 			spec.Path("/v1/application_fees/{fee}/refunds"): {
 				"get": applicationFeeRefundCreateMethod,
 			},
@@ -261,15 +568,308 @@ func initTestSpec() {
 			spec.Path("/v1/charges/{id}"): {
 				"get": chargeGetMethod,
 			},
+			spec.Path("/v1/checkout/sessions"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"cancel_url":     {Type: spec.TypeString},
+						"currency":       {Type: spec.TypeString},
+						"customer_email": {Format: spec.FormatEmail, Type: spec.TypeString},
+						"expires_at":     {Format: spec.FormatUnixTime, Type: spec.TypeInteger},
+						"line_items": {
+							Type: spec.TypeArray,
+							Items: &spec.Schema{
+								Type: spec.TypeObject,
+								Properties: map[string]*spec.Schema{
+									"price": {Type: spec.TypeString},
+									"price_data": {
+										Type: spec.TypeObject,
+										Properties: map[string]*spec.Schema{
+											"currency": {Type: spec.TypeString},
+											"product_data": {
+												Type: spec.TypeObject,
+												Properties: map[string]*spec.Schema{
+													"name": {Type: spec.TypeString},
+												},
+											},
+											"unit_amount": {Type: spec.TypeInteger},
+											"unit_amount_decimal": {
+												Format: spec.FormatDecimal,
+												Type:   spec.TypeString,
+											},
+										},
+									},
+									"quantity": {Type: spec.TypeInteger},
+								},
+							},
+						},
+						"locale": {
+							Enum:                    []interface{}{"auto", "en", "fr"},
+							Type:                    spec.TypeString,
+							XStripeBypassValidation: true,
+						},
+						"mode": {
+							Enum: []interface{}{"payment", "setup", "subscription"},
+							Type: spec.TypeString,
+						},
+						"success_url": {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/checkout.session",
+				),
+			},
+			spec.Path("/v1/checkout/sessions/{session}"): {
+				"get": getFormOperation(nil, nil, "#/components/schemas/checkout.session"),
+			},
+			spec.Path("/v1/checkout/sessions/{session}/line_items"): {
+				"get": &spec.Operation{
+					Responses: map[spec.StatusCode]spec.Response{
+						"200": {
+							Content: map[string]spec.MediaType{
+								"application/json": {
+									Schema: &spec.Schema{
+										Type: spec.TypeObject,
+										Properties: map[string]*spec.Schema{
+											"data": {
+												Type:  spec.TypeArray,
+												Items: &spec.Schema{Ref: "#/components/schemas/item"},
+											},
+											"has_more": {Type: spec.TypeBoolean},
+											"object": {
+												Type: spec.TypeString,
+												Enum: []interface{}{"list"},
+											},
+											"url": {Type: spec.TypeString},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			spec.Path("/v1/customers"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"email": {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/customer",
+				),
+			},
+			spec.Path("/v1/customers/search"): {
+				"get": &spec.Operation{
+					Parameters: []*spec.Parameter{
+						{In: spec.ParameterQuery, Name: "limit", Schema: &spec.Schema{Type: spec.TypeInteger}},
+						{In: spec.ParameterQuery, Name: "page", Schema: &spec.Schema{Type: spec.TypeString}},
+						{In: spec.ParameterQuery, Name: "query", Required: true, Schema: &spec.Schema{Type: spec.TypeString}},
+					},
+					Responses: map[spec.StatusCode]spec.Response{
+						"200": {
+							Content: map[string]spec.MediaType{
+								"application/json": {
+									Schema: &spec.Schema{
+										Properties: map[string]*spec.Schema{
+											"data": {
+												Items: &spec.Schema{Ref: "#/components/schemas/customer"},
+												Type:  spec.TypeArray,
+											},
+											"has_more":  {Type: spec.TypeBoolean},
+											"next_page": {Nullable: true, Type: spec.TypeString},
+											"object": {
+												Enum: []interface{}{"search_result"},
+												Type: spec.TypeString,
+											},
+											"url": {Type: spec.TypeString},
+										},
+										Type: spec.TypeObject,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
 			spec.Path("/v1/customers/{id}"): {
 				"delete": customerDeleteMethod,
 			},
+			spec.Path("/v1/events/{id}"): {
+				"get": getFormOperation(nil, nil, "#/components/schemas/event"),
+			},
 			spec.Path("/v1/invoices/{id}/pay"): {
 				"post": invoicePayMethod,
 			},
+			spec.Path("/v1/payment_intents"): {
+				"get":  paymentIntentListMethod,
+				"post": paymentIntentCreateMethod,
+			},
+			spec.Path("/v1/payment_intents/{intent}"): {
+				"get": &spec.Operation{
+					Parameters: []*spec.Parameter{
+						{
+							In:     spec.ParameterQuery,
+							Name:   "client_secret",
+							Schema: &spec.Schema{Type: spec.TypeString},
+						},
+						{
+							In:   spec.ParameterQuery,
+							Name: "expand",
+							Schema: &spec.Schema{
+								Items: &spec.Schema{Type: spec.TypeString},
+								Type:  spec.TypeArray,
+							},
+						},
+					},
+					Responses: map[spec.StatusCode]spec.Response{
+						"200": {
+							Content: map[string]spec.MediaType{
+								"application/json": {
+									Schema: &spec.Schema{
+										Ref: "#/components/schemas/payment_intent",
+									},
+								},
+							},
+						},
+					},
+				},
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"metadata":       metadataSchema(),
+						"payment_method": {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/payment_intent",
+				),
+			},
+			spec.Path("/v1/payment_intents/{intent}/cancel"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"cancellation_reason": {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/payment_intent",
+				),
+			},
+			spec.Path("/v1/payment_intents/{intent}/capture"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"amount_to_capture": {Type: spec.TypeInteger},
+					},
+					nil,
+					"#/components/schemas/payment_intent",
+				),
+			},
+			spec.Path("/v1/payment_intents/{intent}/confirm"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"payment_method": {Type: spec.TypeString},
+						"return_url":     {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/payment_intent",
+				),
+			},
+			spec.Path("/v1/payment_methods"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"type": {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/payment_method",
+				),
+			},
+			spec.Path("/v1/setup_intents"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"confirm":        {Type: spec.TypeBoolean},
+						"payment_method": {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/setup_intent",
+				),
+			},
+			spec.Path("/v1/setup_intents/{intent}/cancel"): {
+				"post": getFormOperation(nil, nil, "#/components/schemas/setup_intent"),
+			},
+			spec.Path("/v1/setup_intents/{intent}/confirm"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"payment_method": {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/setup_intent",
+				),
+			},
 			spec.Path("/v1/quotes/{quote}/pdf"): {
 				"get": quotePdfMethod,
 			},
+			spec.Path("/v2/core/event_destinations"): {
+				"get": {
+					Parameters: []*spec.Parameter{
+						{
+							In:     spec.ParameterQuery,
+							Name:   "limit",
+							Schema: &spec.Schema{Type: spec.TypeInteger},
+						},
+						{
+							In:     spec.ParameterQuery,
+							Name:   "page",
+							Schema: &spec.Schema{Type: spec.TypeString},
+						},
+					},
+					Responses: map[spec.StatusCode]spec.Response{
+						"200": {
+							Content: map[string]spec.MediaType{
+								"application/json": {
+									Schema: &spec.Schema{
+										Properties: map[string]*spec.Schema{
+											"data": {
+												Items: &spec.Schema{
+													Ref: "#/components/schemas/v2.core.event_destination",
+												},
+												Type: "array",
+											},
+											"next_page_url": {
+												Nullable: true,
+												Type:     "string",
+											},
+											"previous_page_url": {
+												Nullable: true,
+												Type:     "string",
+											},
+										},
+										Type: "object",
+									},
+								},
+							},
+						},
+					},
+				},
+				"post": getJSONOperation(
+					map[string]*spec.Schema{
+						"enabled_events": {
+							Items: &spec.Schema{Type: spec.TypeString},
+							Type:  spec.TypeArray,
+						},
+						"event_payload": {Type: spec.TypeString},
+						"name":          {Type: spec.TypeString},
+						"type":          {Type: spec.TypeString},
+						"webhook_endpoint": {
+							Properties: map[string]*spec.Schema{
+								"url": {Type: spec.TypeString},
+							},
+							Type: spec.TypeObject,
+						},
+					},
+					[]string{"enabled_events", "name", "type"},
+					"#/components/schemas/v2.core.event_destination",
+				),
+			},
+			spec.Path("/v2/core/event_destinations/{id}"): {
+				"get": getFormOperation(nil, nil, "#/components/schemas/v2.core.event_destination"),
+			},
+			spec.Path("/v2/core/events/{id}"): {
+				"get": getFormOperation(nil, nil, "#/components/schemas/v2.core.event"),
+			},
 		},
 	}
 }
@@ -285,6 +885,185 @@ func TestCanLoadEmbeddedSpecs(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLoadSpec_OpenAPI31(t *testing.T) {
+	specPath := filepath.Join(t.TempDir(), "openapi.json")
+	err := os.WriteFile(specPath, []byte(`{
+		"openapi": "3.1.0",
+		"info": {"version": "2024-01-01"},
+		"components": {
+			"parameters": {
+				"widget": {"in": "path", "name": "widget", "required": true, "schema": {"type": "string"}}
+			},
+			"requestBodies": {
+				"widget": {
+					"content": {
+						"application/x-www-form-urlencoded": {
+							"schema": {
+								"additionalProperties": false,
+								"properties": {
+									"label": {"$ref": "#/components/schemas/label", "maxLength": 20},
+									"size": {"exclusiveMinimum": 0, "type": "integer"}
+								},
+								"type": "object"
+							}
+						}
+					}
+				}
+			},
+			"responses": {
+				"widget": {
+					"description": "A widget.",
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/widget"}}}
+				}
+			},
+			"schemas": {
+				"label": {"type": "string"},
+				"widget": {
+					"properties": {
+						"id": {"examples": ["wid_123"], "type": "string"},
+						"label": {"$ref": "#/components/schemas/label", "description": "A label.", "maxLength": 20},
+						"note": {"type": ["string", "null"]},
+						"object": {"const": "widget", "type": "string"}
+					},
+					"required": ["id", "label", "note", "object"],
+					"type": "object"
+				}
+			}
+		},
+		"paths": {
+			"/v1/widgets/{widget}": {
+				"post": {
+					"parameters": [{"$ref": "#/components/parameters/widget"}],
+					"requestBody": {"$ref": "#/components/requestBodies/widget"},
+					"responses": {"200": {"$ref": "#/components/responses/widget"}}
+				}
+			}
+		}
+	}`), 0644)
+	assert.NoError(t, err)
+
+	openAPI31Spec, err := LoadSpec(nil, specPath)
+	assert.NoError(t, err)
+	assert.True(t, openAPI31Spec.Components.Schemas["widget"].Properties["note"].Nullable)
+
+	server, err := NewStubServer(&spec.Fixtures{}, openAPI31Spec, false, false, false)
+	assert.NoError(t, err)
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/widgets/wid_123", "size=1", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{
+		"id":     "wid_123",
+		"label":  "",
+		"note":   nil,
+		"object": "widget",
+	}, decodeObject(t, body))
+
+	// References with sibling keywords are sent back like any other field
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/widgets/wid_123", "label=hello", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", decodeObject(t, body)["label"])
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/widgets/wid_123",
+		"label=much_too_long_for_a_label", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// exclusiveMinimum is a number in OpenAPI 3.1
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/widgets/wid_123", "size=0", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// References have to point into the components
+	err = os.WriteFile(specPath, []byte(`{
+		"paths": {
+			"/v1/widgets": {
+				"get": {"responses": {"200": {"$ref": "#/paths/~1v1~1widget/get/responses/200"}}}
+			}
+		}
+	}`), 0644)
+	assert.NoError(t, err)
+	_, err = LoadSpec(nil, specPath)
+	assert.Error(t, err)
+}
+
+func TestHandleRequest_ComposedResponseSchemas(t *testing.T) {
+	widgetSpec := &spec.Spec{
+		Components: spec.Components{
+			Schemas: map[string]*spec.Schema{
+				"widget": {
+					Properties: map[string]*spec.Schema{
+						"id": {Type: spec.TypeString},
+						"kind": {
+							OneOf: []*spec.Schema{
+								{Type: spec.TypeString},
+								{Type: spec.TypeInteger},
+							},
+						},
+						"object": {Const: "widget", Type: spec.TypeString},
+						"shade": {
+							AllOf: []*spec.Schema{
+								{Type: spec.TypeString},
+								{MaxLength: 20},
+							},
+						},
+					},
+					Required:    []string{"id", "kind", "object", "shade"},
+					Type:        spec.TypeObject,
+					XResourceID: "widget",
+				},
+			},
+		},
+		Info: &spec.Info{Version: testSpecAPIVersion},
+		Paths: map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
+			spec.Path("/v1/widgets"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"kind":  {Type: spec.TypeString},
+						"shade": {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/widget",
+				),
+			},
+			spec.Path("/v1/widgets/{widget}"): {
+				"post": getFormOperation(
+					map[string]*spec.Schema{
+						"kind":  {Type: spec.TypeString},
+						"shade": {Type: spec.TypeString},
+					},
+					nil,
+					"#/components/schemas/widget",
+				),
+			},
+		},
+	}
+
+	for _, stateful := range []bool{false, true} {
+		server, err := NewStubServer(&spec.Fixtures{
+			Resources: map[spec.ResourceID]interface{}{
+				"widget": map[string]interface{}{
+					"id":     "wid_123",
+					"kind":   "small",
+					"object": "widget",
+					"shade":  "red",
+				},
+			},
+		}, widgetSpec, false, stateful, false)
+		assert.NoError(t, err)
+
+		resp, body := sendRequestToServer(t, server, "POST", "/v1/widgets", "kind=abc&shade=teal", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		widget := decodeObject(t, body)
+		assert.Equal(t, "abc", widget["kind"])
+		assert.Equal(t, "teal", widget["shade"])
+
+		resp, body = sendRequestToServer(t, server, "POST", "/v1/widgets/"+widget["id"].(string),
+			"kind=5&shade=navy", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		widget = decodeObject(t, body)
+		assert.Equal(t, "5", widget["kind"])
+		assert.Equal(t, "navy", widget["shade"])
+	}
+}
+
 func TestDoubleSlashFixHandler(t *testing.T) {
 	var lastPath string
 
@@ -539,7 +1318,7 @@ func TestStubServer_AllowsEmptyContentTypeOnDelete(t *testing.T) {
 
 func TestStubServer_ErrorsOnMismatchedContentType(t *testing.T) {
 	headers := getDefaultHeaders()
-	headers["Content-Type"] = "application/json"
+	headers["Content-Type"] = "text/plain"
 
 	resp, body := sendRequest(t, "POST", "/v1/charges",
 		"amount=123", headers, nil)
@@ -555,10 +1334,88 @@ func TestStubServer_ErrorsOnMismatchedContentType(t *testing.T) {
 	assert.Equal(t,
 		fmt.Sprintf(contentTypeMismatched,
 			"application/x-www-form-urlencoded",
-			"application/json"),
+			"text/plain"),
 		errorInfo["message"])
 }
 
+func TestStubServer_Formats(t *testing.T) {
+	testCases := []struct {
+		body    string
+		code    string
+		message string
+		param   string
+	}{
+		{"customer_email=jenny", "email_invalid", "Invalid email address: jenny", "customer_email"},
+		{"expires_at=-1", "", "Invalid timestamp: must be an integer Unix timestamp, but got -1", "expires_at"},
+		{"line_items[0][price_data][unit_amount_decimal]=1.2.3", "", "Invalid decimal: 1.2.3",
+			"line_items[0][price_data][unit_amount_decimal]"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.param, func(t *testing.T) {
+			resp, body := sendRequest(t, "POST", "/v1/checkout/sessions", testCase.body, getDefaultHeaders(), nil)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+			assert.Equal(t, typeInvalidRequestError, errorInfo["type"])
+			assert.Equal(t, testCase.message, errorInfo["message"])
+			assert.Equal(t, testCase.param, errorInfo["param"])
+			if testCase.code != "" {
+				assert.Equal(t, testCase.code, errorInfo["code"])
+			}
+		})
+	}
+
+	resp, _ := sendRequest(t, "POST", "/v1/checkout/sessions",
+		"customer_email=jenny@example.com&expires_at=1700000000&line_items[0][price_data][unit_amount_decimal]=12.3456789012345678",
+		getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestStubServer_BypassValidation(t *testing.T) {
+	// `locale` is marked with `x-stripeBypassValidation`, so values that
+	// aren't in its enum are accepted like they are by the Stripe API
+	resp, _ := sendRequest(t, "POST", "/v1/checkout/sessions", "locale=zh-Hant-TW", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := sendRequest(t, "POST", "/v1/checkout/sessions", "mode=bogus", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, decodeObject(t, body)["error"].(map[string]interface{})["message"], "Request validation error")
+}
+
+func TestStubServer_JSONBody(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Content-Type"] = "application/json; charset=utf-8"
+
+	resp, _ := sendRequest(t, "POST", "/v1/charges",
+		`{"amount": 123}`, headers, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// JSON values aren't coerced from strings, so they have to have the
+	// right types already
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		`{"amount": "123"}`, headers, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Contains(t, errorInfo["message"], "Request validation error")
+
+	resp, _ = sendRequest(t, "POST", "/v1/charges", `{}`, headers, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = sendRequest(t, "POST", "/v1/charges", `[123]`, headers, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Parameters in the query string are still coerced alongside the body
+	resp, body = sendRequest(t, "POST", "/v1/payment_intents?amount=500&confirm=false",
+		`{"capture_method": "manual"}`, headers, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "manual", decodeObject(t, body)["capture_method"])
+
+	// But values in the body that override them aren't
+	resp, _ = sendRequest(t, "POST", "/v1/charges?amount=123",
+		`{"amount": "123"}`, headers, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
 func TestStubServer_ReflectsIdempotencyKey(t *testing.T) {
 	headers := getDefaultHeaders()
 	headers["Idempotency-Key"] = "my-key"
@@ -749,27 +1606,37 @@ func TestIsCurl(t *testing.T) {
 
 func TestValidateAuth(t *testing.T) {
 	testCases := []struct {
-		auth string
-		want bool
+		auth    string
+		want    bool
+		wantKey string
 	}{
-		{"Basic " + encode64("sk_test_123"), true},
-		{"Bearer sk_test_123", true},
-		{"", false},
-		{"Bearer", false},
-		{"Basic", false},
-		{"Bearer ", false},
-		{"Basic ", false},
-		{"Basic 123", false}, // "123" is not a valid key when base64 decoded
-		{"Basic " + encode64("sk_test"), false},
-		{"Bearer sk_test_123 extra", false},
-		{"Bearer sk_test", false},
-		{"Bearer sk_test_123_extra", false},
-		{"Bearer sk_live_123", false},
-		{"Bearer sk_test_", false},
+		{"Basic " + encode64("sk_test_123"), true, "sk_test_123"},
+		{"Basic " + encode64("sk_test_123:"), true, "sk_test_123"},
+		{"Basic " + encode64("rk_test_123:password"), true, "rk_test_123"},
+		{"Bearer sk_test_123", true, "sk_test_123"},
+		{"Bearer rk_test_123", true, "rk_test_123"},
+		{"Bearer pk_test_123", true, "pk_test_123"},
+		{"Bearer pk_live_123", false, ""},
+		{"Bearer ak_test_123", false, ""},
+		{"", false, ""},
+		{"Bearer", false, ""},
+		{"Basic", false, ""},
+		{"Bearer ", false, ""},
+		{"Basic ", false, ""},
+		{"Basic 123", false, ""}, // "123" is not a valid key when base64 decoded
+		{"Basic " + encode64("sk_test"), false, ""},
+		{"Basic " + encode64(":sk_test_123"), false, ""},
+		{"Bearer sk_test_123 extra", false, ""},
+		{"Bearer sk_test", false, ""},
+		{"Bearer sk_test_123_extra", false, ""},
+		{"Bearer sk_live_123", false, ""},
+		{"Bearer sk_test_", false, ""},
 	}
 	for _, tc := range testCases {
 		t.Run("Authorization: "+tc.auth, func(t *testing.T) {
-			assert.Equal(t, tc.want, validateAuth(tc.auth))
+			key, ok := validateAuth(tc.auth)
+			assert.Equal(t, tc.want, ok)
+			assert.Equal(t, tc.wantKey, key)
 		})
 	}
 }
@@ -868,6 +1735,7 @@ func TestParseExpansionLevel(t *testing.T) {
 //
 
 type testStubServerOptions struct {
+	stateful           bool
 	strictVersionCheck bool
 }
 
@@ -896,15 +1764,69 @@ func getStubServer(t *testing.T, serverOptions *testStubServerOptions) *StubServ
 		fixtures:           &testFixtures,
 		strictVersionCheck: serverOptions.strictVersionCheck,
 	}
+	if serverOptions.stateful {
+		server.store = NewObjectStore()
+	}
 	err := server.initializeRouter()
 	assert.NoError(t, err)
 	return server
 }
 
+// getFormOperation builds an operation that takes a form-encoded request with
+// the given properties and responds with the schema at the given reference.
+func getFormOperation(properties map[string]*spec.Schema, required []string, responseRef string) *spec.Operation {
+	return &spec.Operation{
+		RequestBody: &spec.RequestBody{
+			Content: map[string]spec.MediaType{
+				"application/x-www-form-urlencoded": {
+					Schema: &spec.Schema{
+						AdditionalPropertiesAllowed: false,
+						Properties:                  properties,
+						Required:                    required,
+						Type:                        spec.TypeObject,
+					},
+				},
+			},
+		},
+		Responses: map[spec.StatusCode]spec.Response{
+			"200": {
+				Content: map[string]spec.MediaType{
+					"application/json": {
+						Schema: &spec.Schema{Ref: responseRef},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getJSONOperation(properties map[string]*spec.Schema, required []string, responseRef string) *spec.Operation {
+	operation := getFormOperation(properties, required, responseRef)
+	operation.RequestBody.Content = map[string]spec.MediaType{
+		"application/json": operation.RequestBody.Content["application/x-www-form-urlencoded"],
+	}
+	return operation
+}
+
+func metadataSchema() *spec.Schema {
+	return &spec.Schema{
+		AdditionalProperties:        &spec.Schema{Type: spec.TypeString},
+		AdditionalPropertiesAllowed: true,
+		Type:                        spec.TypeObject,
+	}
+}
+
 func sendRequest(t *testing.T, method string, url string, params string,
 	headers map[string]string, serverOptions *testStubServerOptions) (*http.Response, []byte) {
 
-	server := getStubServer(t, serverOptions)
+	return sendRequestToServer(t, getStubServer(t, serverOptions),
+		method, url, params, headers)
+}
+
+// sendRequestToServer is like sendRequest, but sends the request to an
+// existing server so that state can be carried between requests.
+func sendRequestToServer(t *testing.T, server *StubServer, method string,
+	url string, params string, headers map[string]string) (*http.Response, []byte) {
 
 	fullURL := fmt.Sprintf("https://stripe.com%s", url)
 	req := httptest.NewRequest(method, fullURL, bytes.NewBufferString(params))
@@ -0,0 +1,383 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/stripe/stripe-mock/generator/datareplacer"
+	"github.com/stripe/stripe-mock/spec"
+)
+
+//
+// Private constants
+//
+
+const (
+	codeResourceMissing = "resource_missing"
+
+	resourceMissing = "No such %s: '%s'"
+)
+
+//
+// Private types
+//
+
+// statefulRequest bundles up everything about a request that's needed to apply
+// stateful behavior to its generated response.
+type statefulRequest struct {
+	expansions     *ExpansionLevel
+	pathParams     *PathParamsMap
+	request        *http.Request
+	requestData    map[string]interface{}
+	responseData   interface{}
+	responseSchema *spec.Schema
+	route          *stubServerRoute
+
+	// stripeAccount is the connected account that the request was made on
+	// behalf of with `Stripe-Account`, if any.
+	stripeAccount string
+
+	// store is the store that the request acts on, which is the platform's
+	// or a connected account's within the request's tenant.
+	store *ObjectStore
+
+	// tenant is the tenant that the request's API key belongs to. See
+	// tenantStore.
+	tenant string
+}
+
+//
+// Private functions
+//
+
+// applyStatefulBehavior takes a response that's been generated for a request
+// and reconciles it with the request's object store. Newly created objects are
+// saved, retrieved objects are loaded, updates are merged into stored objects,
+// and deleted objects are removed. Objects that have a state machine (see
+// stateMachines) are transitioned accordingly.
+//
+// Returns the data that should be sent back to the client, or a status and
+// error if the request should fail.
+func (s *StubServer) applyStatefulBehavior(sr *statefulRequest) (interface{}, int, *ResponseError) {
+	generated, ok := sr.responseData.(map[string]interface{})
+	if !ok {
+		return sr.responseData, http.StatusOK, nil
+	}
+
+	// Lists are served from the store. Nested lists like a Checkout Session's
+	// line items are served if they were recorded when their parent was
+	// created.
+	if sr.request.Method == http.MethodGet && generated["object"] == "list" {
+		if len(sr.route.pathParamNames) == 0 {
+			data, stripeError := s.applyStatefulList(sr, generated)
+			if stripeError != nil {
+				return nil, http.StatusBadRequest, stripeError
+			}
+			return data, http.StatusOK, nil
+		}
+		return s.applyStatefulNestedList(sr, generated), http.StatusOK, nil
+	}
+
+	if sr.request.Method == http.MethodGet && len(sr.route.pathParamNames) == 0 && isV2List(generated) {
+		data, stripeError := s.applyStatefulV2List(sr, generated)
+		if stripeError != nil {
+			return nil, http.StatusBadRequest, stripeError
+		}
+		return data, http.StatusOK, nil
+	}
+
+	if sr.request.Method == http.MethodGet && generated["object"] == "search_result" {
+		data, stripeError := s.applyStatefulSearch(sr, generated)
+		if stripeError != nil {
+			return nil, http.StatusBadRequest, stripeError
+		}
+		return data, http.StatusOK, nil
+	}
+
+	// Requests without a primary ID are only interesting if they created a
+	// new object, which we'll want to remember.
+	if sr.pathParams == nil || sr.pathParams.PrimaryID == nil {
+		if sr.request.Method != http.MethodPost || !isStorableObject(generated) {
+			return sr.responseData, http.StatusOK, nil
+		}
+
+		mergeMetadata(generated, sr.requestData)
+
+		stripeError := s.transitionObject(sr, generated, actionCreate)
+		if stripeError != nil {
+			return nil, http.StatusBadRequest, stripeError
+		}
+
+		// Expandable fields are stored as IDs, just like in the real API.
+		stored := deepCopyMap(generated)
+		collapseExpansions(stored, sr.expansions)
+		sr.store.Put(stored)
+		s.createObjectEvents(sr.store, sr.stripeAccount, nil, stored)
+
+		return generated, http.StatusOK, nil
+	}
+
+	id := *sr.pathParams.PrimaryID
+	stored, ok := sr.store.Get(id)
+	if !ok {
+		// Only objects that can be created through the API are expected to be
+		// found in the store. Anything else (e.g. a country spec) keeps its
+		// stateless behavior.
+		resourceID := s.resolveResourceID(sr.responseSchema)
+		if !s.creatableResources[resourceID] {
+			return sr.responseData, http.StatusOK, nil
+		}
+
+		param := sr.route.pathParamNames[len(sr.route.pathParamNames)-1]
+		return nil, http.StatusNotFound, createResourceMissingError(resourceID, id, param)
+	}
+
+	// Actions like `/capture` on a charge occasionally return an object
+	// that's not the one identified by the path. Leave those alone.
+	if stored["object"] != generated["object"] {
+		return sr.responseData, http.StatusOK, nil
+	}
+
+	switch sr.request.Method {
+	case http.MethodDelete:
+		if sr.store.Delete(id) {
+			s.createObjectEvents(sr.store, sr.stripeAccount, stored, nil)
+		}
+		return sr.responseData, http.StatusOK, nil
+
+	case http.MethodGet:
+		if !isObjectPath(sr.route.path) {
+			return sr.responseData, http.StatusOK, nil
+		}
+		return stored, http.StatusOK, nil
+
+	case http.MethodPost:
+		replacer := datareplacer.DataReplacer{
+			Definitions: s.spec.Components.Schemas,
+			Schema:      sr.responseSchema,
+		}
+
+		action := actionUpdate
+		if !isObjectPath(sr.route.path) {
+			path := string(sr.route.path)
+			action = path[strings.LastIndex(path, "/")+1:]
+		}
+
+		// The object is transitioned atomically so that concurrent actions
+		// (e.g. two captures) can't both pass its state machine's checks.
+		var previous map[string]interface{}
+		updated, ok, err := sr.store.Update(id, func(stored map[string]interface{}) (map[string]interface{}, error) {
+			previous = deepCopyMap(stored)
+			stored = replacer.ReplaceData(sr.requestData, stored)
+			mergeMetadata(stored, sr.requestData)
+
+			stripeError := s.transitionObject(sr, stored, action)
+			if stripeError != nil {
+				return nil, stripeError
+			}
+			return stored, nil
+		})
+		if err != nil {
+			return nil, http.StatusBadRequest, err.(*ResponseError)
+		}
+		if !ok {
+			param := sr.route.pathParamNames[len(sr.route.pathParamNames)-1]
+			return nil, http.StatusNotFound,
+				createResourceMissingError(s.resolveResourceID(sr.responseSchema), id, param)
+		}
+
+		s.createObjectEvents(sr.store, sr.stripeAccount, previous, updated)
+		return updated, http.StatusOK, nil
+	}
+
+	return sr.responseData, http.StatusOK, nil
+}
+
+// applyStatefulNestedList replaces the data of a generated list with a list
+// stored for its parent object, if there is one. The parent is identified by
+// the last ID in the path, and the list by the last segment of the path, so
+// `/v1/checkout/sessions/{session}/line_items` is the `line_items` list of
+// `{session}`.
+func (s *StubServer) applyStatefulNestedList(sr *statefulRequest, generated map[string]interface{}) map[string]interface{} {
+	if sr.pathParams == nil || len(sr.pathParams.SecondaryIDs) < 1 {
+		return generated
+	}
+
+	parentID := sr.pathParams.SecondaryIDs[len(sr.pathParams.SecondaryIDs)-1].ID
+	path := string(sr.route.path)
+	name := path[strings.LastIndex(path, "/")+1:]
+
+	list, ok := sr.store.GetList(parentID, name)
+	if !ok {
+		return generated
+	}
+
+	generated["data"] = list
+	generated["has_more"] = false
+	generated["url"] = sr.request.URL.Path
+	if _, ok := generated["total_count"]; ok {
+		generated["total_count"] = len(list)
+	}
+	return generated
+}
+
+// generateObject generates a new object of the given resource from its
+// fixture as if it had been created through the API, including a new ID.
+// It's used when stripe-mock creates objects on its own, like the
+// PaymentIntent of a paid Checkout Session. Returns false if the resource
+// isn't in the spec.
+func (s *StubServer) generateObject(definition string) (map[string]interface{}, bool) {
+	if _, ok := s.spec.Components.Schemas[definition]; !ok {
+		return nil, false
+	}
+
+	generator := DataGenerator{
+		definitions:            s.spec.Components.Schemas,
+		fixtures:               s.fixtures,
+		populateOptionalFields: s.populateOptionalFields,
+		verbose:                s.verbose,
+	}
+	data, err := generator.Generate(&GenerateParams{
+		RequestMethod: http.MethodPost,
+		Schema:        &spec.Schema{Ref: "#/components/schemas/" + definition},
+	})
+	if err != nil {
+		fmt.Printf("Couldn't generate %s: %v\n", definition, err)
+		return nil, false
+	}
+
+	object, ok := data.(map[string]interface{})
+	return object, ok
+}
+
+// initializeCreatableResources records the resource IDs (e.g. `customer`) of
+// every object that can be created with a `POST` to a path without a primary
+// ID. In stateful mode, requests for these objects 404 unless they've been
+// created first.
+func (s *StubServer) initializeCreatableResources() {
+	s.creatableResources = make(map[string]bool)
+
+	for _, route := range s.routes[http.MethodPost] {
+		if route.hasPrimaryID {
+			continue
+		}
+
+		response, ok := route.operation.Responses["200"]
+		if !ok {
+			continue
+		}
+
+		content, ok := response.Content["application/json"]
+		if !ok || content.Schema == nil {
+			continue
+		}
+
+		resourceID := s.resolveResourceID(content.Schema)
+		if resourceID != "" {
+			s.creatableResources[resourceID] = true
+		}
+	}
+}
+
+// resolveResourceID finds the `x-resourceId` of the object that a response
+// schema represents, dereferencing it as necessary. In the case of an `anyOf`
+// (e.g. a customer or deleted customer), the first non-deleted branch is
+// preferred. A schema made of an `allOf` takes its resource ID from whichever
+// branch has one. Returns an empty string if no resource ID could be found.
+func (s *StubServer) resolveResourceID(schema *spec.Schema) string {
+	if schema == nil {
+		return ""
+	}
+
+	if schema.Ref != "" {
+		var ok bool
+		schema, ok = s.spec.Components.Schemas[definitionFromJSONPointer(schema.Ref)]
+		if !ok {
+			return ""
+		}
+	}
+
+	if schema.XResourceID != "" {
+		return schema.XResourceID
+	}
+
+	for _, allOfSchema := range schema.AllOf {
+		resourceID := s.resolveResourceID(allOfSchema)
+		if resourceID != "" {
+			return resourceID
+		}
+	}
+
+	for _, anyOfSchema := range schema.AnyOf {
+		if anyOfSchema.Ref != "" {
+			dereferenced, ok := s.spec.Components.Schemas[definitionFromJSONPointer(anyOfSchema.Ref)]
+			if ok && isDeletedResource(dereferenced) {
+				continue
+			}
+		}
+
+		resourceID := s.resolveResourceID(anyOfSchema)
+		if resourceID != "" {
+			return resourceID
+		}
+	}
+
+	return ""
+}
+
+// createResourceMissingError creates the error that's returned when a request
+// references an object that doesn't exist.
+func createResourceMissingError(resourceID, id, param string) *ResponseError {
+	stripeError := createStripeError(typeInvalidRequestError,
+		fmt.Sprintf(resourceMissing, resourceID, id))
+	stripeError.ErrorInfo.Code = codeResourceMissing
+	stripeError.ErrorInfo.Param = param
+	return stripeError
+}
+
+// isStorableObject checks whether some generated data looks like an API object
+// that can be saved to a store: it must have both an ID and an object type.
+func isStorableObject(data map[string]interface{}) bool {
+	id, ok := data["id"].(string)
+	if !ok || id == "" {
+		return false
+	}
+
+	_, ok = data["object"].(string)
+	return ok
+}
+
+// mergeMetadata merges any `metadata` sent with a request into an object's
+// metadata. Like the real API, a key set to an empty string is removed.
+//
+// This is handled separately from the rest of the request's data because the
+// data replacer only replaces keys that already exist in a response.
+func mergeMetadata(object map[string]interface{}, requestData map[string]interface{}) {
+	requestMetadata, ok := requestData["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	metadata, ok := object["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = make(map[string]interface{})
+	}
+
+	for key, value := range requestMetadata {
+		if value == "" {
+			delete(metadata, key)
+			continue
+		}
+		metadata[key] = value
+	}
+
+	object["metadata"] = metadata
+}
+
+// isObjectPath checks whether a path addresses an object directly (e.g.
+// `/v1/payment_intents/{intent}`) rather than being an RPC-style action on one
+// (e.g. `/v1/payment_intents/{intent}/confirm`).
+func isObjectPath(path spec.Path) bool {
+	return strings.HasSuffix(string(path), "}")
+}
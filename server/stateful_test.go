@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/spec"
+)
+
+func TestStubServer_Stateful(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500&metadata[order]=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	created := decodeObject(t, body)
+	id := created["id"].(string)
+	assert.NotEqual(t, "pi_123", id)
+
+	// Retrieving the object returns what was stored
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	retrieved := decodeObject(t, body)
+	assert.Equal(t, created, retrieved)
+	assert.Equal(t, 500.0, retrieved["amount"])
+	assert.Equal(t, map[string]interface{}{"order": "123"}, retrieved["metadata"])
+
+	// Updates are merged into the stored object
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents/"+id,
+		"metadata[order]=&metadata[color]=blue", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	retrieved = decodeObject(t, body)
+	assert.Equal(t, 500.0, retrieved["amount"])
+	assert.Equal(t, map[string]interface{}{"color": "blue"}, retrieved["metadata"])
+}
+
+func TestStubServer_StatefulResourceMissing(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/payment_intents/pi_missing",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t, "resource_missing", errorInfo["code"])
+	assert.Equal(t, "intent", errorInfo["param"])
+	assert.Equal(t, "No such payment_intent: 'pi_missing'", errorInfo["message"])
+
+	// Objects that can't be created through the API are still generated
+	resp, _ = sendRequestToServer(t, server, "DELETE", "/v1/customers/cus_123",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMergeMetadata(t *testing.T) {
+	object := map[string]interface{}{
+		"metadata": map[string]interface{}{"a": "1", "b": "2"},
+	}
+	mergeMetadata(object, map[string]interface{}{
+		"metadata": map[string]interface{}{"a": "", "c": "3"},
+	})
+	assert.Equal(t, map[string]interface{}{"b": "2", "c": "3"}, object["metadata"])
+
+	// Objects without metadata get some
+	object = map[string]interface{}{}
+	mergeMetadata(object, map[string]interface{}{
+		"metadata": map[string]interface{}{"a": "1"},
+	})
+	assert.Equal(t, map[string]interface{}{"a": "1"}, object["metadata"])
+}
+
+func TestResolveResourceID(t *testing.T) {
+	server := getStubServer(t, nil)
+
+	assert.Equal(t, "charge",
+		server.resolveResourceID(&spec.Schema{Ref: "#/components/schemas/charge"}))
+	assert.Equal(t, "customer",
+		server.resolveResourceID(&spec.Schema{AnyOf: []*spec.Schema{
+			{Ref: "#/components/schemas/deleted_customer"},
+			{Ref: "#/components/schemas/customer"},
+		}}))
+	assert.Equal(t, "charge",
+		server.resolveResourceID(&spec.Schema{AllOf: []*spec.Schema{
+			{Properties: map[string]*spec.Schema{"id": {Type: "string"}}},
+			{Ref: "#/components/schemas/charge"},
+		}}))
+	assert.Equal(t, "", server.resolveResourceID(&spec.Schema{Type: "object"}))
+}
+
+//
+// Private functions
+//
+
+// testStore gets the store for the tenant of the API key sent by
+// getDefaultHeaders.
+func testStore(server *StubServer) *ObjectStore {
+	return server.tenantStore("sk_test_123")
+}
+
+// testPageURL adds the tenant of the API key sent by getDefaultHeaders to the
+// path of one of stripe-mock's pages.
+func testPageURL(path string) string {
+	return path + "?" + pageTenantParam + "=123"
+}
+
+func decodeObject(t *testing.T, body []byte) map[string]interface{} {
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	return data
+}
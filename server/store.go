@@ -0,0 +1,276 @@
+package server
+
+import (
+	"sort"
+	"sync"
+)
+
+//
+// Public types
+//
+
+// ObjectStore is an in-memory collection of API objects that's used when
+// stripe-mock is running in stateful mode. Objects are keyed by their `id`
+// field and remember the order in which they were first stored so that they
+// can be listed back in a stable order.
+//
+// All objects going in or coming out of the store are deep copied so that
+// callers are free to mutate them without affecting other requests that are
+// being served concurrently.
+type ObjectStore struct {
+	mu      sync.Mutex
+	objects map[string]*storedObject
+
+	// lists holds lists of data that belong to an object but that aren't
+	// part of it, like the line items of a Checkout Session. It's keyed by
+	// the parent object's ID, then the list's name.
+	lists map[string]map[string][]interface{}
+
+	// namespaces holds isolated stores nested under this one, like the
+	// objects of a connected account. They're keyed by the ID of the object
+	// that owns them.
+	namespaces map[string]*ObjectStore
+
+	// seq is a monotonically increasing counter used to order objects by
+	// their insertion time.
+	seq int
+}
+
+// NewObjectStore creates a new, empty ObjectStore.
+func NewObjectStore() *ObjectStore {
+	return &ObjectStore{
+		lists:      make(map[string]map[string][]interface{}),
+		namespaces: make(map[string]*ObjectStore),
+		objects:    make(map[string]*storedObject),
+	}
+}
+
+// Delete removes the object with the given ID from the store. It returns
+// false if there was no such object.
+func (s *ObjectStore) Delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.objects[id]
+	delete(s.objects, id)
+	delete(s.lists, id)
+	delete(s.namespaces, id)
+	return ok
+}
+
+// DeleteNamespace removes the namespace with the given ID (see Namespace) and
+// everything in it. It returns false if there was no such namespace.
+func (s *ObjectStore) DeleteNamespace(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.namespaces[id]
+	delete(s.namespaces, id)
+	return ok
+}
+
+// Get retrieves a copy of the object with the given ID. The second return
+// value is false if there was no such object.
+func (s *ObjectStore) Get(id string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.objects[id]
+	if !ok {
+		return nil, false
+	}
+	return deepCopyMap(stored.data), true
+}
+
+// GetList retrieves a copy of a list belonging to the object with the given
+// ID. The second return value is false if no such list was stored.
+func (s *ObjectStore) GetList(parentID, name string) ([]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, ok := s.lists[parentID][name]
+	if !ok {
+		return nil, false
+	}
+	return deepCopy(list).([]interface{}), true
+}
+
+// List retrieves copies of all stored objects with the given `object` type,
+// like `customer`. They're ordered the way the API's list endpoints order
+// them, with the most recently created object first.
+func (s *ObjectStore) List(objectType string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matches []*storedObject
+	for _, stored := range s.objects {
+		if stored.data["object"] == objectType {
+			matches = append(matches, stored)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].seq > matches[j].seq
+	})
+
+	objects := make([]map[string]interface{}, len(matches))
+	for i, stored := range matches {
+		objects[i] = deepCopyMap(stored.data)
+	}
+	return objects
+}
+
+// Namespace gets the isolated store nested under this one for the object
+// with the given ID, like a connected account, creating it if necessary. Its
+// objects are separate from this store's, and it's removed along with the
+// object that owns it.
+func (s *ObjectStore) Namespace(id string) *ObjectStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	namespace, ok := s.namespaces[id]
+	if !ok {
+		namespace = NewObjectStore()
+		s.namespaces[id] = namespace
+	}
+	return namespace
+}
+
+// Put stores a copy of the given object under the value of its `id` field,
+// replacing any existing object with the same ID. Objects without a string
+// `id` are ignored and false is returned.
+func (s *ObjectStore) Put(object map[string]interface{}) bool {
+	id, ok := object["id"].(string)
+	if !ok || id == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.objects[id]
+	if !ok {
+		s.seq++
+		stored = &storedObject{seq: s.seq}
+		s.objects[id] = stored
+	}
+	stored.data = deepCopyMap(object)
+	stored.revision++
+	return true
+}
+
+// Update atomically replaces the object with the given ID with the result of
+// calling update on a copy of it. It's like a Get followed by a Put, except
+// that if the object was changed by someone else in between (e.g. by a
+// concurrent request), the change is discarded and update is called again
+// with the newer object. This makes sure that a transition like capturing a
+// PaymentIntent only happens once, even if it's requested twice at the same
+// time.
+//
+// update is called without the store being locked so that it can look up
+// other objects, but it may be called several times, so it shouldn't have
+// side effects. If it returns an error, nothing is stored and the error is
+// returned.
+//
+// Returns a copy of the updated object. The second return value is false if
+// there was no such object.
+func (s *ObjectStore) Update(id string, update func(object map[string]interface{}) (map[string]interface{}, error)) (map[string]interface{}, bool, error) {
+	for {
+		s.mu.Lock()
+		stored, ok := s.objects[id]
+		if !ok {
+			s.mu.Unlock()
+			return nil, false, nil
+		}
+		data, revision := deepCopyMap(stored.data), stored.revision
+		s.mu.Unlock()
+
+		updated, err := update(data)
+		if err != nil {
+			return nil, true, err
+		}
+
+		s.mu.Lock()
+		current, ok := s.objects[id]
+		switch {
+		case !ok:
+			s.mu.Unlock()
+			return nil, false, nil
+
+		case current != stored || current.revision != revision:
+			// Changed in the meantime, so try again.
+			s.mu.Unlock()
+			continue
+		}
+
+		current.data = deepCopyMap(updated)
+		current.revision++
+		s.mu.Unlock()
+
+		return updated, true, nil
+	}
+}
+
+// PutList stores a copy of a list belonging to the object with the given ID,
+// like `line_items` for a Checkout Session. These are served from nested list
+// endpoints like `/v1/checkout/sessions/{session}/line_items`.
+func (s *ObjectStore) PutList(parentID, name string, list []interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lists[parentID] == nil {
+		s.lists[parentID] = make(map[string][]interface{})
+	}
+	s.lists[parentID][name] = deepCopy(list).([]interface{})
+}
+
+//
+// Private types
+//
+
+// storedObject is a single object in an ObjectStore along with some
+// bookkeeping information.
+type storedObject struct {
+	data map[string]interface{}
+
+	// revision is incremented every time the object is changed so that
+	// Update can tell whether it was changed concurrently.
+	revision int
+
+	seq int
+}
+
+//
+// Private functions
+//
+
+// deepCopy copies a value decoded from (or destined for) JSON so that the copy
+// shares no maps or slices with the original.
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return deepCopyMap(v)
+
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = deepCopy(item)
+		}
+		return copied
+	}
+
+	return value
+}
+
+// deepCopyMap is deepCopy, but specialized for the common case of a map.
+func deepCopyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+
+	copied := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		copied[key] = deepCopy(value)
+	}
+	return copied
+}
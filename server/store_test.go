@@ -0,0 +1,189 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestObjectStore(t *testing.T) {
+	store := NewObjectStore()
+
+	// Objects without an ID can't be stored
+	assert.False(t, store.Put(map[string]interface{}{"object": "customer"}))
+
+	object := map[string]interface{}{
+		"id":       "cus_123",
+		"metadata": map[string]interface{}{"foo": "bar"},
+		"object":   "customer",
+	}
+	assert.True(t, store.Put(object))
+
+	// Mutating the original doesn't affect the stored copy
+	object["metadata"].(map[string]interface{})["foo"] = "baz"
+
+	stored, ok := store.Get("cus_123")
+	assert.True(t, ok)
+	assert.Equal(t, "bar", stored["metadata"].(map[string]interface{})["foo"])
+
+	// And neither does mutating a retrieved copy
+	stored["metadata"].(map[string]interface{})["foo"] = "baz"
+
+	stored, ok = store.Get("cus_123")
+	assert.True(t, ok)
+	assert.Equal(t, "bar", stored["metadata"].(map[string]interface{})["foo"])
+
+	assert.True(t, store.Delete("cus_123"))
+	assert.False(t, store.Delete("cus_123"))
+
+	_, ok = store.Get("cus_123")
+	assert.False(t, ok)
+}
+
+func TestObjectStore_Update(t *testing.T) {
+	store := NewObjectStore()
+	store.Put(map[string]interface{}{"count": 0, "id": "obj_123"})
+
+	updated, ok, err := store.Update("obj_123", func(object map[string]interface{}) (map[string]interface{}, error) {
+		object["count"] = object["count"].(int) + 1
+		return object, nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, updated["count"])
+
+	// Errors leave the object alone
+	updateErr := errors.New("not allowed")
+	_, ok, err = store.Update("obj_123", func(object map[string]interface{}) (map[string]interface{}, error) {
+		object["count"] = 100
+		return nil, updateErr
+	})
+	assert.Equal(t, updateErr, err)
+	assert.True(t, ok)
+
+	stored, _ := store.Get("obj_123")
+	assert.Equal(t, 1, stored["count"])
+
+	_, ok, err = store.Update("obj_456", func(object map[string]interface{}) (map[string]interface{}, error) {
+		return object, nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// Concurrent updates each see the result of the ones before them.
+func TestObjectStore_UpdateConcurrent(t *testing.T) {
+	store := NewObjectStore()
+	store.Put(map[string]interface{}{"count": 0, "id": "obj_123"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := store.Update("obj_123", func(object map[string]interface{}) (map[string]interface{}, error) {
+				object["count"] = object["count"].(int) + 1
+				return object, nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	stored, _ := store.Get("obj_123")
+	assert.Equal(t, 50, stored["count"])
+}
+
+func TestObjectStore_Lists(t *testing.T) {
+	store := NewObjectStore()
+	store.Put(map[string]interface{}{"id": "cs_123", "object": "checkout.session"})
+
+	_, ok := store.GetList("cs_123", "line_items")
+	assert.False(t, ok)
+
+	list := []interface{}{map[string]interface{}{"id": "li_123"}}
+	store.PutList("cs_123", "line_items", list)
+
+	// Mutating the original doesn't affect the stored copy
+	list[0].(map[string]interface{})["id"] = "li_456"
+
+	stored, ok := store.GetList("cs_123", "line_items")
+	assert.True(t, ok)
+	assert.Equal(t, "li_123", stored[0].(map[string]interface{})["id"])
+
+	// Lists are removed along with their parent
+	store.Delete("cs_123")
+	_, ok = store.GetList("cs_123", "line_items")
+	assert.False(t, ok)
+}
+
+func TestObjectStore_List(t *testing.T) {
+	store := NewObjectStore()
+	store.Put(map[string]interface{}{"id": "cus_1", "object": "customer"})
+	store.Put(map[string]interface{}{"id": "ch_1", "object": "charge"})
+	store.Put(map[string]interface{}{"id": "cus_2", "object": "customer"})
+
+	// Updating an object doesn't change its position
+	store.Put(map[string]interface{}{"id": "cus_1", "object": "customer", "name": "Jenny"})
+
+	customers := store.List("customer")
+	assert.Equal(t, 2, len(customers))
+	assert.Equal(t, "cus_2", customers[0]["id"])
+	assert.Equal(t, "cus_1", customers[1]["id"])
+	assert.Equal(t, "Jenny", customers[1]["name"])
+
+	assert.Equal(t, 0, len(store.List("invoice")))
+}
+
+func TestDeepCopy(t *testing.T) {
+	original := map[string]interface{}{
+		"list":   []interface{}{map[string]interface{}{"a": 1}},
+		"nested": map[string]interface{}{"b": "c"},
+		"scalar": 7,
+	}
+
+	copied := deepCopy(original).(map[string]interface{})
+	assert.Equal(t, original, copied)
+
+	copied["list"].([]interface{})[0].(map[string]interface{})["a"] = 2
+	copied["nested"].(map[string]interface{})["b"] = "d"
+
+	assert.Equal(t, 1, original["list"].([]interface{})[0].(map[string]interface{})["a"])
+	assert.Equal(t, "c", original["nested"].(map[string]interface{})["b"])
+}
+
+func TestObjectStore_Namespace(t *testing.T) {
+	store := NewObjectStore()
+	store.Put(map[string]interface{}{"id": "acct_123", "object": "account"})
+
+	namespace := store.Namespace("acct_123")
+	namespace.Put(map[string]interface{}{"id": "cus_123", "object": "customer"})
+
+	// The same namespace is returned every time
+	_, ok := store.Namespace("acct_123").Get("cus_123")
+	assert.True(t, ok)
+
+	// Its objects are isolated from the parent's and other namespaces'
+	_, ok = store.Get("cus_123")
+	assert.False(t, ok)
+	_, ok = store.Namespace("acct_456").Get("cus_123")
+	assert.False(t, ok)
+
+	// It goes away with its owner
+	store.Delete("acct_123")
+	_, ok = store.Namespace("acct_123").Get("cus_123")
+	assert.False(t, ok)
+}
+
+func TestObjectStore_DeleteNamespace(t *testing.T) {
+	store := NewObjectStore()
+	store.Namespace("tenant:123").Put(map[string]interface{}{"id": "cus_123", "object": "customer"})
+
+	assert.True(t, store.DeleteNamespace("tenant:123"))
+	assert.False(t, store.DeleteNamespace("tenant:123"))
+
+	_, ok := store.Namespace("tenant:123").Get("cus_123")
+	assert.False(t, ok)
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/stripe/stripe-mock/spec"
+)
+
+//
+// Private values
+//
+
+// formatCurrency is the format of three-letter ISO currency codes.
+const formatCurrency = "currency"
+
+// maxSyntheticOptionalDepth is how many objects deep optional properties are
+// populated in synthetic fixtures when that's been enabled. Beyond it, only
+// required properties are, which keeps schemas that refer to each other from
+// generating without end.
+const maxSyntheticOptionalDepth = 3
+
+// maxSyntheticPatternRepeats is the most extra times that a repetition in a
+// pattern is repeated to reach a string's minimum length.
+const maxSyntheticPatternRepeats = 100
+
+// Realistic values for strings in synthetic fixtures whose name or format
+// says what they are.
+const (
+	syntheticCurrency = "usd"
+	syntheticDecimal  = "0"
+	syntheticEmail    = "jenny.rosen@example.com"
+	syntheticURL      = "https://example.com"
+)
+
+// syntheticPatternRunes are the runes preferred when picking one out of a
+// character class in a pattern, so that strings look like words rather than
+// starting at whatever the lowest rune in the class is.
+var syntheticPatternRunes = []rune("abcdefghijklmnopqrstuvwxyz0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_-")
+
+//
+// Private types
+//
+
+// syntheticField describes where a value in a synthetic fixture is found.
+type syntheticField struct {
+	// depth is the number of objects that the value is nested in.
+	depth int
+
+	// name is the name of the property that the value is for. It's empty for
+	// the top-level value and the items of arrays.
+	name string
+
+	// resourceID is the resource whose ID the value is, like `customer`, if
+	// it's known to be one.
+	resourceID string
+}
+
+//
+// Private functions
+//
+
+// expandableResourceID gets the resource that an expandable field can be
+// expanded to, as found in the first reference of its `anyOf`. Returns an
+// empty string if there are none.
+func (g *DataGenerator) expandableResourceID(schema *spec.Schema) string {
+	for _, subSchema := range schema.AnyOf {
+		if subSchema.Ref == "" {
+			continue
+		}
+
+		name := definitionFromJSONPointer(subSchema.Ref)
+		if definition, ok := g.definitions[name]; ok && definition.XResourceID != "" {
+			return definition.XResourceID
+		}
+		return name
+	}
+	return ""
+}
+
+// idPrefix gets the prefix of IDs of a resource, like `cus` for `customer`.
+// It's found in the resource's fixture if there's one, and otherwise made up
+// from its name: the initials of a name of several words (`pm` for
+// `payment_method`) or the start of a single word (`cou` for `coupon`).
+func (g *DataGenerator) idPrefix(resourceID string) string {
+	if g.fixtures != nil {
+		fixture, ok := g.fixtures.Resources[spec.ResourceID(resourceID)].(map[string]interface{})
+		if ok {
+			id, _ := fixture["id"].(string)
+			if i := strings.LastIndex(id, "_"); i > 0 {
+				return id[:i]
+			}
+		}
+	}
+
+	// Namespaced resources like `checkout.session` are named by their last
+	// part.
+	name := resourceID[strings.LastIndex(resourceID, ".")+1:]
+
+	var words []string
+	for _, word := range strings.Split(name, "_") {
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+
+	switch len(words) {
+	case 0:
+		return "id"
+
+	case 1:
+		if len(words[0]) > 3 {
+			return words[0][:3]
+		}
+		return words[0]
+
+	default:
+		var prefix strings.Builder
+		for _, word := range words {
+			prefix.WriteByte(word[0])
+		}
+		return prefix.String()
+	}
+}
+
+// populatesOptionalFieldsAt checks whether optional properties (and nullable
+// values) should be populated for a value found at field.
+func (g *DataGenerator) populatesOptionalFieldsAt(field syntheticField) bool {
+	return g.populateOptionalFields && field.depth < maxSyntheticOptionalDepth
+}
+
+// syntheticString picks a string for a synthetic fixture. A string that
+// looks like what the API would return is picked based on the schema's format
+// and the field's name (e.g. an ID for `customer` or `customer_id`, or an
+// email address for `receipt_email`), as long as it satisfies the schema.
+// Otherwise, it's the shortest string that matches the schema's pattern and
+// length.
+func (g *DataGenerator) syntheticString(schema *spec.Schema, field syntheticField) string {
+	var realistic string
+	switch {
+	case schema.Format == spec.FormatDecimal:
+		realistic = syntheticDecimal
+
+	case schema.Format == spec.FormatEmail || isFieldOfKind(field.name, "email"):
+		realistic = syntheticEmail
+
+	case schema.Format == spec.FormatURI || isFieldOfKind(field.name, "url"):
+		realistic = syntheticURL
+
+	case schema.Format == formatCurrency || isFieldOfKind(field.name, "currency"):
+		realistic = syntheticCurrency
+
+	case field.resourceID != "":
+		realistic = randomID(g.idPrefix(field.resourceID))
+
+	case strings.HasSuffix(field.name, "_id"):
+		realistic = randomID(g.idPrefix(strings.TrimSuffix(field.name, "_id")))
+	}
+
+	if realistic != "" && stringSatisfiesSchema(realistic, schema) {
+		return realistic
+	}
+
+	if schema.Pattern != "" {
+		s, ok := stringMatchingPattern(schema.Pattern, schema.MinLength, schema.MaxLength)
+		if ok {
+			return s
+		}
+	}
+
+	return strings.Repeat("x", schema.MinLength)
+}
+
+// isFieldOfKind checks whether a field is named for a kind of value, either
+// exactly (`email`) or as a suffix (`receipt_email`).
+func isFieldOfKind(name, kind string) bool {
+	return name == kind || strings.HasSuffix(name, "_"+kind)
+}
+
+// stringSatisfiesSchema checks whether a string has the length and matches
+// the pattern that a schema requires.
+func stringSatisfiesSchema(s string, schema *spec.Schema) bool {
+	length := utf8.RuneCountInString(s)
+	if length < schema.MinLength || (schema.MaxLength > 0 && length > schema.MaxLength) {
+		return false
+	}
+
+	if schema.Pattern == "" {
+		return true
+	}
+
+	pattern, err := regexp.Compile(schema.Pattern)
+	return err == nil && pattern.MatchString(s)
+}
+
+// stringMatchingPattern generates the shortest string that matches a regular
+// expression and is at least minLength long (and at most maxLength, unless
+// it's zero), by repeating its first open-ended repetition as many times as
+// needed. Returns false if no such string could be found, like for patterns
+// that Go doesn't support.
+func stringMatchingPattern(pattern string, minLength, maxLength int) (string, bool) {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", false
+	}
+
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	parsed = parsed.Simplify()
+
+	for extra := 0; extra <= maxSyntheticPatternRepeats; extra++ {
+		remaining := extra
+		var s strings.Builder
+		if !writeMatchingString(&s, parsed, &remaining) {
+			return "", false
+		}
+
+		length := utf8.RuneCountInString(s.String())
+		if maxLength > 0 && length > maxLength {
+			return "", false
+		}
+
+		if length >= minLength && compiled.MatchString(s.String()) {
+			return s.String(), true
+		}
+
+		// Repeating more won't make a string longer if nothing could be
+		// repeated.
+		if extra > 0 && remaining == extra {
+			return "", false
+		}
+	}
+
+	return "", false
+}
+
+// writeMatchingString writes a string matching a parsed regular expression,
+// taking its first alternative and repeating everything as few times as
+// possible. extra is a number of extra times to repeat the first open-ended
+// repetition that's found, which is decremented as they're used. Returns
+// false for expressions that can't match anything.
+func writeMatchingString(s *strings.Builder, re *syntax.Regexp, extra *int) bool {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return false
+
+	case syntax.OpLiteral:
+		s.WriteString(string(re.Rune))
+
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return false
+		}
+		s.WriteRune(runeInClass(re.Rune))
+
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		s.WriteRune('a')
+
+	case syntax.OpCapture:
+		return writeMatchingString(s, re.Sub[0], extra)
+
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !writeMatchingString(s, sub, extra) {
+				return false
+			}
+		}
+
+	case syntax.OpAlternate:
+		return writeMatchingString(s, re.Sub[0], extra)
+
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		min, max := re.Min, re.Max
+		switch re.Op {
+		case syntax.OpStar:
+			min, max = 0, -1
+		case syntax.OpPlus:
+			min, max = 1, -1
+		case syntax.OpQuest:
+			min, max = 0, 1
+		}
+
+		count := min
+		if *extra > 0 && (max == -1 || max > min) {
+			more := *extra
+			if max != -1 && more > max-min {
+				more = max - min
+			}
+			count += more
+			*extra -= more
+		}
+
+		for i := 0; i < count; i++ {
+			if !writeMatchingString(s, re.Sub[0], extra) {
+				return false
+			}
+		}
+	}
+
+	// Anything else (anchors, word boundaries, and empty matches) matches
+	// without adding to the string.
+	return true
+}
+
+// runeInClass picks a rune out of a character class, given as the pairs of
+// the first and last runes of its ranges.
+func runeInClass(ranges []rune) rune {
+	for _, r := range syntheticPatternRunes {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			if ranges[i] <= r && r <= ranges[i+1] {
+				return r
+			}
+		}
+	}
+
+	// Skip past control characters if the class allows.
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if ranges[i+1] >= ' ' {
+			if ranges[i] < ' ' {
+				return ' '
+			}
+			return ranges[i]
+		}
+	}
+	return ranges[0]
+}
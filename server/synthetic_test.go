@@ -0,0 +1,126 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/stripe/stripe-mock/spec"
+)
+
+func TestIDPrefix(t *testing.T) {
+	g := DataGenerator{
+		fixtures: &spec.Fixtures{
+			Resources: map[spec.ResourceID]interface{}{
+				"customer": map[string]interface{}{"id": "cus_123"},
+				"coupon":   map[string]interface{}{"id": "25OFF"},
+			},
+		},
+		verbose: verbose,
+	}
+
+	// From the fixture
+	assert.Equal(t, "cus", g.idPrefix("customer"))
+
+	// Made up from the name
+	assert.Equal(t, "cou", g.idPrefix("coupon"))
+	assert.Equal(t, "pm", g.idPrefix("payment_method"))
+	assert.Equal(t, "ses", g.idPrefix("checkout.session"))
+	assert.Equal(t, "tax", g.idPrefix("tax"))
+
+	// Without fixtures
+	g = DataGenerator{verbose: verbose}
+	assert.Equal(t, "cus", g.idPrefix("customer"))
+}
+
+func TestStringMatchingPattern(t *testing.T) {
+	testCases := []struct {
+		pattern   string
+		minLength int
+		maxLength int
+		want      string
+	}{
+		{`^/v1/charges`, 0, 0, "/v1/charges"},
+		{`^/v1/charges/[^/]+/refunds`, 0, 0, "/v1/charges/a/refunds"},
+		{`^[A-Z]{2}$`, 0, 0, "AA"},
+		{`^\d{3,5}-(foo|bar)?$`, 0, 0, "000-"},
+		{`^acct_[a-zA-Z0-9]+$`, 0, 0, "acct_a"},
+		{`^acct_[a-zA-Z0-9]+$`, 10, 0, "acct_aaaaa"},
+		{`^.*$`, 3, 0, "aaa"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.pattern, func(t *testing.T) {
+			s, ok := stringMatchingPattern(testCase.pattern, testCase.minLength, testCase.maxLength)
+			assert.True(t, ok)
+			assert.Equal(t, testCase.want, s)
+			assert.Regexp(t, regexp.MustCompile(testCase.pattern), s)
+		})
+	}
+
+	// Patterns that can't be satisfied
+	_, ok := stringMatchingPattern(`^abc$`, 5, 0)
+	assert.False(t, ok)
+	_, ok = stringMatchingPattern(`^a{5}$`, 0, 3)
+	assert.False(t, ok)
+	_, ok = stringMatchingPattern(`^[^\x00-\x{10FFFF}]$`, 0, 0)
+	assert.False(t, ok)
+
+	// Patterns that Go doesn't support
+	_, ok = stringMatchingPattern(`^(?=a)a$`, 0, 0)
+	assert.False(t, ok)
+}
+
+func TestSyntheticString(t *testing.T) {
+	g := DataGenerator{
+		definitions: map[string]*spec.Schema{
+			"customer": {Type: spec.TypeObject, XResourceID: "customer"},
+		},
+		fixtures: &spec.Fixtures{
+			Resources: map[spec.ResourceID]interface{}{
+				"customer": map[string]interface{}{"id": "cus_123"},
+			},
+		},
+		verbose: verbose,
+	}
+
+	stringSchema := &spec.Schema{Type: spec.TypeString}
+
+	// By name
+	assert.Equal(t, "usd", g.syntheticString(stringSchema, syntheticField{name: "currency"}))
+	assert.Equal(t, "jenny.rosen@example.com",
+		g.syntheticString(stringSchema, syntheticField{name: "receipt_email"}))
+	assert.Equal(t, "https://example.com", g.syntheticString(stringSchema, syntheticField{name: "return_url"}))
+	assert.True(t, strings.HasPrefix(
+		g.syntheticString(stringSchema, syntheticField{name: "customer_id"}), "cus_"))
+	assert.True(t, strings.HasPrefix(
+		g.syntheticString(stringSchema, syntheticField{name: "id", resourceID: "customer"}), "cus_"))
+
+	// By format
+	assert.Equal(t, "0", g.syntheticString(&spec.Schema{
+		Format: spec.FormatDecimal,
+		Type:   spec.TypeString,
+	}, syntheticField{name: "amount"}))
+	assert.Equal(t, "https://example.com", g.syntheticString(&spec.Schema{
+		Format: spec.FormatURI,
+		Type:   spec.TypeString,
+	}, syntheticField{name: "link"}))
+
+	// A realistic value that doesn't satisfy the schema isn't used
+	assert.Equal(t, "/v1/charges", g.syntheticString(&spec.Schema{
+		Pattern: "^/v1/charges",
+		Type:    spec.TypeString,
+	}, syntheticField{name: "url"}))
+	assert.Equal(t, "", g.syntheticString(&spec.Schema{
+		MaxLength: 3,
+		Type:      spec.TypeString,
+	}, syntheticField{name: "customer_id"}))
+
+	// Nothing known about it
+	assert.Equal(t, "", g.syntheticString(stringSchema, syntheticField{name: "description"}))
+	assert.Equal(t, "xx", g.syntheticString(&spec.Schema{
+		MinLength: 2,
+		Type:      spec.TypeString,
+	}, syntheticField{}))
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//
+// Private constants
+//
+
+// tenantPath is the path of the administrative endpoint that acts on the
+// tenant of the API key it's called with. A `DELETE` to it wipes the tenant's
+// objects.
+const tenantPath = pagePathPrefix + "tenant"
+
+// tenantNamespacePrefix prefixes the IDs of tenants' namespaces in the root
+// store so that they can't be confused with namespaces owned by objects.
+const tenantNamespacePrefix = "tenant:"
+
+const (
+	tenantNotStateful = "Tenants are only available when stripe-mock is " +
+		"started with -stateful."
+	tenantUnsupportedMethod = "Unsupported method %s for %s. Send a `DELETE` " +
+		"to wipe the objects of the tenant of the API key used."
+)
+
+//
+// Private functions
+//
+
+// apiKeyTenant gets the tenant of an API key, which is the part after its
+// mode. `sk_test_abc` and `rk_test_abc` both belong to tenant `abc`.
+func apiKeyTenant(key string) string {
+	return key[strings.LastIndex(key, "_")+1:]
+}
+
+// handleTenant serves the administrative endpoint at tenantPath. Like the
+// API, it must be called with an API key.
+func (s *StubServer) handleTenant(w http.ResponseWriter, r *http.Request, start time.Time) {
+	auth := r.Header.Get("Authorization")
+	key, ok := validateAuth(auth)
+	if !ok {
+		message := fmt.Sprintf(invalidAuthorization, auth)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusUnauthorized, stripeError)
+		return
+	}
+
+	if isPublishableKey(key) {
+		stripeError := createStripeError(typeInvalidRequestError, publishableKeyNotAllowed)
+		writeResponse(w, r, start, http.StatusForbidden, stripeError)
+		return
+	}
+
+	if s.store == nil {
+		stripeError := createStripeError(typeInvalidRequestError, tenantNotStateful)
+		writeResponse(w, r, start, http.StatusNotFound, stripeError)
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		stripeError := createStripeError(typeInvalidRequestError,
+			fmt.Sprintf(tenantUnsupportedMethod, r.Method, r.URL.Path))
+		writeResponse(w, r, start, http.StatusMethodNotAllowed, stripeError)
+		return
+	}
+
+	tenant := apiKeyTenant(key)
+	s.store.DeleteNamespace(tenantNamespace(tenant))
+
+	writeResponse(w, r, start, http.StatusOK, map[string]interface{}{
+		"deleted": true,
+		"id":      tenant,
+		"object":  "tenant",
+	})
+}
+
+// tenantNamespace gets the ID of a tenant's namespace in the root store.
+func tenantNamespace(tenant string) string {
+	return tenantNamespacePrefix + tenant
+}
+
+// tenantStore gets the store for the tenant of an API key. Every tenant has
+// its own isolated namespace so that, for example, parallel test workers using
+// different keys never see each other's objects.
+func (s *StubServer) tenantStore(key string) *ObjectStore {
+	return s.store.Namespace(tenantNamespace(apiKeyTenant(key)))
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyTenant(t *testing.T) {
+	assert.Equal(t, "123", apiKeyTenant("sk_test_123"))
+	assert.Equal(t, "123", apiKeyTenant("rk_test_123"))
+	assert.Equal(t, "shard1", apiKeyTenant("sk_test_shard1"))
+}
+
+func TestTenants_Isolation(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	headersA := getTenantHeaders("sk_test_shardA")
+	headersB := getTenantHeaders("sk_test_shardB")
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=500", headersA)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id, "", headersA)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id, "", headersB)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/payment_intents", "", headersB)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, decodeObject(t, body)["data"])
+
+	// A restricted key with the same suffix belongs to the same tenant
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id, "",
+		getTenantHeaders("rk_test_shardA"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// A key belongs to the same tenant whether it's sent through Bearer or Basic
+// auth.
+func TestTenants_AuthSchemes(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents", "amount=500",
+		getTenantHeaders("sk_test_abc"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeObject(t, body)["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id, "",
+		getBasicAuthHeaders("sk_test_abc"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents", "amount=500",
+		getBasicAuthHeaders("sk_test_abc"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id = decodeObject(t, body)["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+id, "",
+		getTenantHeaders("sk_test_abc"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTenants_Pages(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/checkout/sessions",
+		"mode=payment&cancel_url=https://example.com/cancel", getTenantHeaders("sk_test_shardA"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	session := decodeObject(t, body)
+	id := session["id"].(string)
+
+	resp, _ = sendRequestToServer(t, server, "GET",
+		checkoutPath+id+"?"+pageTenantParam+"=shardA", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "GET",
+		checkoutPath+id+"?"+pageTenantParam+"=shardB", "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestTenants_Delete(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	headersA := getTenantHeaders("sk_test_shardA")
+	headersB := getTenantHeaders("sk_test_shardB")
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents", "amount=500", headersA)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	idA := decodeObject(t, body)["id"].(string)
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents", "amount=500", headersB)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	idB := decodeObject(t, body)["id"].(string)
+
+	resp, body = sendRequestToServer(t, server, "DELETE", tenantPath, "", headersA)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	deleted := decodeObject(t, body)
+	assert.Equal(t, true, deleted["deleted"])
+	assert.Equal(t, "shardA", deleted["id"])
+	assert.Equal(t, "tenant", deleted["object"])
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+idA, "", headersA)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Other tenants are left alone
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/payment_intents/"+idB, "", headersB)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTenants_DeleteErrors(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	resp, _ := sendRequestToServer(t, server, "DELETE", tenantPath, "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "GET", tenantPath, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, _ = sendRequest(t, "DELETE", tenantPath, "", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func getTenantHeaders(key string) map[string]string {
+	headers := getDefaultHeaders()
+	headers["Authorization"] = "Bearer " + key
+	return headers
+}
+
+// getBasicAuthHeaders gets headers authenticating with a key through Basic
+// auth the way curl's `-u <key>:` does.
+func getBasicAuthHeaders(key string) map[string]string {
+	headers := getDefaultHeaders()
+	headers["Authorization"] = "Basic " + encode64(key+":")
+	return headers
+}
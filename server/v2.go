@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//
+// Private constants
+//
+
+// v2PathPrefix is the prefix of the paths of the v2 API.
+const v2PathPrefix = "/v2/"
+
+const (
+	// Pages of v2 lists are identified by opaque tokens, which for stripe-mock
+	// are one of these prefixes followed by the ID of an object that the page
+	// comes after or before.
+	v2PageAfter  = "after:"
+	v2PageBefore = "before:"
+
+	invalidV2Page = "The `page` parameter must be a token from a list's " +
+		"`next_page_url` or `previous_page_url`."
+)
+
+//
+// Private types
+//
+
+// v2ResponseError is the shape of the errors returned by the v2 API. Unlike
+// v1 errors, they have a user-facing message but don't refer to the
+// parameter or objects involved.
+type v2ResponseError struct {
+	ErrorInfo struct {
+		Code        string  `json:"code,omitempty"`
+		Message     string  `json:"message"`
+		Type        string  `json:"type"`
+		UserMessage *string `json:"user_message"`
+	} `json:"error"`
+}
+
+//
+// Private functions
+//
+
+// applyStatefulV2List fills a generated v2 list with stored objects of the
+// type that it contains like applyStatefulList does for v1 lists. v2 lists
+// are paginated with a `page` token taken from the list's `next_page_url` or
+// `previous_page_url`.
+//
+// Returns an error suitable for sending back to the client (with a status of
+// 400) if the page token is invalid.
+func (s *StubServer) applyStatefulV2List(sr *statefulRequest, generated map[string]interface{}) (map[string]interface{}, *ResponseError) {
+	objectType := s.listObjectType(sr.responseSchema)
+	if objectType == "" || !s.creatableResources[objectType] {
+		return generated, nil
+	}
+
+	// Pages are turned into the cursors that v1 lists are paginated with.
+	pageParams := map[string]interface{}{"limit": sr.requestData["limit"]}
+	backward := false
+	if page, ok := sr.requestData["page"].(string); ok {
+		cursor, err := base64.RawURLEncoding.DecodeString(page)
+		switch {
+		case err == nil && strings.HasPrefix(string(cursor), v2PageAfter):
+			pageParams["starting_after"] = strings.TrimPrefix(string(cursor), v2PageAfter)
+		case err == nil && strings.HasPrefix(string(cursor), v2PageBefore):
+			pageParams["ending_before"] = strings.TrimPrefix(string(cursor), v2PageBefore)
+			backward = true
+		default:
+			return nil, createStripeError(typeInvalidRequestError, invalidV2Page)
+		}
+	}
+
+	var matches []interface{}
+	for _, object := range sr.store.List(objectType) {
+		if matchesListFilters(object, sr.requestData) || isListCursor(object, pageParams) {
+			matches = append(matches, object)
+		}
+	}
+
+	// A page whose cursor no longer exists is as invalid as a malformed one.
+	data, hasMore, stripeError := paginateList(objectType, matches, pageParams)
+	if stripeError != nil {
+		return nil, createStripeError(typeInvalidRequestError, invalidV2Page)
+	}
+
+	var nextPageURL, previousPageURL interface{}
+	if len(data) > 0 {
+		first := data[0].(map[string]interface{})["id"].(string)
+		last := data[len(data)-1].(map[string]interface{})["id"].(string)
+
+		// A page reached by going backward always has a page after it, and
+		// one reached by going forward always has a page before it.
+		if backward {
+			nextPageURL = v2PageURL(sr, v2PageAfter+last)
+			if hasMore {
+				previousPageURL = v2PageURL(sr, v2PageBefore+first)
+			}
+		} else {
+			if hasMore {
+				nextPageURL = v2PageURL(sr, v2PageAfter+last)
+			}
+			if pageParams["starting_after"] != nil {
+				previousPageURL = v2PageURL(sr, v2PageBefore+first)
+			}
+		}
+	}
+
+	generated["data"] = data
+	generated["next_page_url"] = nextPageURL
+	generated["previous_page_url"] = previousPageURL
+	return generated, nil
+}
+
+// isV2List checks whether generated data is a list in the v2 API's shape.
+func isV2List(data map[string]interface{}) bool {
+	_, hasData := data["data"].([]interface{})
+	_, hasNextPageURL := data["next_page_url"]
+	return hasData && hasNextPageURL && data["object"] == nil
+}
+
+// isV2Path checks whether a request path is part of the v2 API.
+func isV2Path(path string) bool {
+	return strings.HasPrefix(path, v2PathPrefix)
+}
+
+// newV2ResponseError converts an error into the v2 API's shape.
+func newV2ResponseError(stripeError *ResponseError) *v2ResponseError {
+	v2Error := &v2ResponseError{}
+	v2Error.ErrorInfo.Code = stripeError.ErrorInfo.Code
+	v2Error.ErrorInfo.Message = stripeError.ErrorInfo.Message
+	v2Error.ErrorInfo.Type = stripeError.ErrorInfo.Type
+	return v2Error
+}
+
+// v2PageURL builds the URL of a page of a v2 list.
+func v2PageURL(sr *statefulRequest, cursor string) string {
+	query := url.Values{}
+	query.Set("page", base64.RawURLEncoding.EncodeToString([]byte(cursor)))
+	if limit, ok := toInt64(sr.requestData["limit"]); ok {
+		query.Set("limit", strconv.FormatInt(limit, 10))
+	}
+	return sr.request.URL.Path + "?" + query.Encode()
+}
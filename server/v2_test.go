@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestV2List(t *testing.T) {
+	resp, body := sendRequest(t, "GET", "/v2/core/event_destinations", "", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	list := decodeObject(t, body)
+	assert.Nil(t, list["object"])
+	assert.Nil(t, list["next_page_url"])
+	assert.Nil(t, list["previous_page_url"])
+
+	data := list["data"].([]interface{})
+	assert.Equal(t, 1, len(data))
+	assert.Equal(t, "v2.core.event_destination", data[0].(map[string]interface{})["object"])
+}
+
+func TestV2JSONBody(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v2/core/event_destinations",
+		`{"enabled_events": ["v1.billing.meter.error_report_triggered"], "name": "Meters", "type": "webhook_endpoint"}`,
+		getJSONHeaders(), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Meters", decodeObject(t, body)["name"])
+
+	// v2 operations only take JSON
+	resp, _ = sendRequest(t, "POST", "/v2/core/event_destinations",
+		"enabled_events[]=v1.billing.meter.error_report_triggered&name=Meters&type=webhook_endpoint",
+		getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestV2Errors(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v2/core/event_destinations",
+		`{"name": "Meters"}`, getJSONHeaders(), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	errorInfo := decodeObject(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, typeInvalidRequestError, errorInfo["type"])
+	assert.NotEmpty(t, errorInfo["message"])
+	_, ok := errorInfo["user_message"]
+	assert.True(t, ok)
+	_, ok = errorInfo["param"]
+	assert.False(t, ok)
+
+	resp, body = sendRequest(t, "GET", "/v2/core/nonexistent", "", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_, ok = decodeObject(t, body)["error"].(map[string]interface{})["user_message"]
+	assert.True(t, ok)
+
+	// v1 errors keep their shape
+	resp, body = sendRequest(t, "GET", "/v1/nonexistent", "", getDefaultHeaders(), nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_, ok = decodeObject(t, body)["error"].(map[string]interface{})["user_message"]
+	assert.False(t, ok)
+}
+
+func TestV2List_Stateful(t *testing.T) {
+	server := getStubServer(t, &testStubServerOptions{stateful: true})
+
+	var ids []string
+	for _, name := range []string{"First", "Second", "Third"} {
+		resp, body := sendRequestToServer(t, server, "POST", "/v2/core/event_destinations",
+			`{"enabled_events": ["v1.billing.meter.error_report_triggered"], "name": "`+name+`", "type": "webhook_endpoint"}`,
+			getJSONHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// Lists are newest first
+		ids = append([]string{decodeObject(t, body)["id"].(string)}, ids...)
+	}
+
+	listIDs := func(list map[string]interface{}) []string {
+		var listIDs []string
+		for _, object := range list["data"].([]interface{}) {
+			listIDs = append(listIDs, object.(map[string]interface{})["id"].(string))
+		}
+		return listIDs
+	}
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v2/core/event_destinations?limit=2", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	firstPage := decodeObject(t, body)
+	assert.Equal(t, ids[:2], listIDs(firstPage))
+	assert.Nil(t, firstPage["previous_page_url"])
+	assert.NotNil(t, firstPage["next_page_url"])
+
+	resp, body = sendRequestToServer(t, server, "GET", firstPage["next_page_url"].(string), "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	secondPage := decodeObject(t, body)
+	assert.Equal(t, ids[2:], listIDs(secondPage))
+	assert.Nil(t, secondPage["next_page_url"])
+	assert.NotNil(t, secondPage["previous_page_url"])
+
+	resp, body = sendRequestToServer(t, server, "GET", secondPage["previous_page_url"].(string), "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	previousPage := decodeObject(t, body)
+	assert.Equal(t, ids[:2], listIDs(previousPage))
+	assert.Nil(t, previousPage["previous_page_url"])
+	assert.NotNil(t, previousPage["next_page_url"])
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v2/core/event_destinations?page=bogus", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// A page whose cursor doesn't exist
+	page := base64.RawURLEncoding.EncodeToString([]byte(v2PageAfter + "ed_missing"))
+	resp, _ = sendRequestToServer(t, server, "GET", "/v2/core/event_destinations?page="+page, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func getJSONHeaders() map[string]string {
+	headers := getDefaultHeaders()
+	headers["Content-Type"] = "application/json"
+	return headers
+}
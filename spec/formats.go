@@ -0,0 +1,286 @@
+package spec
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+)
+
+//
+// Public values
+//
+
+// A set of constants for the formats of values that are checked by
+// CheckFormats.
+const (
+	FormatDecimal  = "decimal"
+	FormatEmail    = "email"
+	FormatURI      = "uri"
+	FormatUnixTime = "unix-time"
+)
+
+//
+// Public types
+//
+
+// FormatError is an error for a value that doesn't have the format that its
+// schema requires.
+type FormatError struct {
+	// Code is the Stripe error code for the invalid value, if there's one
+	// specific to the format.
+	Code string
+
+	Message string
+
+	// Param is the form-encoded name of the parameter that the value was
+	// found under, like `line_items[0][price]`.
+	Param string
+}
+
+func (e *FormatError) Error() string {
+	return e.Message
+}
+
+//
+// Public functions
+//
+
+// CheckFormats checks the values in data whose schemas have a format that
+// stripe-mock understands (see formatCheckers). Values that don't have the
+// type their schema requires are skipped because that's the validator's job,
+// as are those of schemas marked with `x-stripeBypassValidation`.
+//
+// Returns an error for the first value with an invalid format, or nil if
+// there are none.
+func CheckFormats(schema *Schema, definitions map[string]*Schema, data map[string]interface{}) *FormatError {
+	return checkFormatsInValue(schema, definitions, "", data)
+}
+
+// IsDecimal checks whether a string is a valid decimal, like `12.345`.
+func IsDecimal(s string) bool {
+	return decimalPattern.MatchString(s)
+}
+
+//
+// Private values
+//
+
+// decimalPattern matches arbitrary-precision decimals, which are sent as
+// strings so that they don't lose precision.
+var decimalPattern = regexp.MustCompile(`\A-?(\d+(\.\d*)?|\.\d+)\z`)
+
+// formatCheckers are the formats that are checked by CheckFormats. They're
+// left out of the schemas given to the validator so that invalid values get
+// these errors rather than the validator's.
+var formatCheckers = map[string]formatChecker{
+	FormatDecimal: {
+		check: func(value interface{}) (bool, bool) {
+			s, ok := value.(string)
+			return IsDecimal(s), ok
+		},
+		message: "Invalid decimal: %v",
+	},
+	FormatEmail: {
+		code: "email_invalid",
+		check: func(value interface{}) (bool, bool) {
+			s, ok := value.(string)
+			if !ok {
+				return false, false
+			}
+
+			// Addresses with display names (like `Jenny <jenny@example.com>`)
+			// parse but aren't allowed.
+			address, err := mail.ParseAddress(s)
+			return err == nil && address.Address == s, true
+		},
+		message: "Invalid email address: %v",
+	},
+	FormatURI: {
+		code: "url_invalid",
+		check: func(value interface{}) (bool, bool) {
+			s, ok := value.(string)
+			if !ok {
+				return false, false
+			}
+
+			u, err := url.Parse(s)
+			return err == nil && u.Scheme != "" && (u.Host != "" || u.Opaque != ""), true
+		},
+		message: "Invalid URL: %v",
+	},
+	FormatUnixTime: {
+		check: func(value interface{}) (bool, bool) {
+			var timestamp float64
+			switch v := value.(type) {
+			case float64:
+				timestamp = v
+			case int:
+				timestamp = float64(v)
+			case int64:
+				timestamp = float64(v)
+			default:
+				return false, false
+			}
+			return timestamp >= 0 && timestamp == math.Trunc(timestamp), true
+		},
+		message: "Invalid timestamp: must be an integer Unix timestamp, but got %v",
+	},
+}
+
+//
+// Private types
+//
+
+// formatChecker checks values of a format.
+type formatChecker struct {
+	// check returns whether a value has the format. Its second return value
+	// is false if the value isn't of a type that the format applies to.
+	check func(value interface{}) (bool, bool)
+
+	code    string
+	message string
+}
+
+//
+// Private functions
+//
+
+// checkFormatsInValue is CheckFormats for a single value, which may be a
+// nested map or array. param is the form-encoded name of the parameter that
+// it was found under.
+func checkFormatsInValue(schema *Schema, definitions map[string]*Schema, param string, value interface{}) *FormatError {
+	schema = dereferenceSchema(schema, definitions)
+	if schema == nil || value == nil || schema.XStripeBypassValidation {
+		return nil
+	}
+
+	for _, branch := range schema.AllOf {
+		formatErr := checkFormatsInValue(branch, definitions, param, value)
+		if formatErr != nil {
+			return formatErr
+		}
+	}
+
+	for _, branches := range [][]*Schema{schema.AnyOf, schema.OneOf} {
+		formatErr := checkFormatsInBranches(branches, definitions, param, value)
+		if formatErr != nil {
+			return formatErr
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			subSchema, ok := schema.Properties[key]
+			if !ok {
+				subSchema = schema.AdditionalProperties
+			}
+			if subSchema == nil {
+				continue
+			}
+
+			subParam := key
+			if param != "" {
+				subParam = fmt.Sprintf("%s[%s]", param, key)
+			}
+
+			formatErr := checkFormatsInValue(subSchema, definitions, subParam, v[key])
+			if formatErr != nil {
+				return formatErr
+			}
+		}
+
+	case []interface{}:
+		if schema.Items == nil {
+			return nil
+		}
+
+		for i, item := range v {
+			formatErr := checkFormatsInValue(schema.Items, definitions,
+				fmt.Sprintf("%s[%d]", param, i), item)
+			if formatErr != nil {
+				return formatErr
+			}
+		}
+
+	default:
+		checker, ok := formatCheckers[schema.Format]
+		if !ok {
+			return nil
+		}
+
+		valid, applies := checker.check(value)
+		if applies && !valid {
+			return &FormatError{
+				Code:    checker.code,
+				Message: fmt.Sprintf(checker.message, value),
+				Param:   param,
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkFormatsInBranches checks a value against the branches of an `anyOf` or
+// `oneOf`. A value is fine if it has the right format for any branch that it
+// has the type of, and otherwise gets the error of the first of them.
+func checkFormatsInBranches(branches []*Schema, definitions map[string]*Schema, param string, value interface{}) *FormatError {
+	var firstErr *FormatError
+	for _, branch := range branches {
+		branch = dereferenceSchema(branch, definitions)
+		if branch == nil || !hasTypeOf(branch, value) {
+			continue
+		}
+
+		formatErr := checkFormatsInValue(branch, definitions, param, value)
+		if formatErr == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = formatErr
+		}
+	}
+	return firstErr
+}
+
+// dereferenceSchema gets the schema that a reference points to. Returns nil
+// if it can't be found.
+func dereferenceSchema(schema *Schema, definitions map[string]*Schema) *Schema {
+	if schema == nil || schema.Ref == "" {
+		return schema
+	}
+
+	name, err := componentFromJSONPointer(schema.Ref, "schemas")
+	if err != nil {
+		return nil
+	}
+	return definitions[name]
+}
+
+// hasTypeOf checks whether a value is of a schema's type. Schemas without a
+// type take any value.
+func hasTypeOf(schema *Schema, value interface{}) bool {
+	switch value.(type) {
+	case bool:
+		return schema.Type == "" || schema.Type == TypeBoolean
+	case float64, int, int64:
+		return schema.Type == "" || schema.Type == TypeInteger || schema.Type == TypeNumber
+	case map[string]interface{}:
+		return schema.Type == "" || schema.Type == TypeObject
+	case string:
+		return schema.Type == "" || schema.Type == TypeString
+	case []interface{}:
+		return schema.Type == "" || schema.Type == TypeArray
+	}
+	return false
+}
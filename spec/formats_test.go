@@ -0,0 +1,130 @@
+package spec
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCheckFormats(t *testing.T) {
+	testCases := []struct {
+		format string
+		valid  []interface{}
+		errors []interface{}
+	}{
+		{FormatDecimal, []interface{}{"12", "-12.345", "0.000000000000000001", ".5"},
+			[]interface{}{"", "1.2.3", "1e5", "abc"}},
+		{FormatEmail, []interface{}{"jenny@example.com", "jenny.rosen+test@example.co.uk"},
+			[]interface{}{"jenny", "Jenny <jenny@example.com>", "@example.com"}},
+		{FormatURI, []interface{}{"https://example.com/success", "mailto:jenny@example.com"},
+			[]interface{}{"example.com", "/success", "https://"}},
+		{FormatUnixTime, []interface{}{0, 1700000000, int64(1700000000), 1700000000.0},
+			[]interface{}{-1, 1700000000.5}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.format, func(t *testing.T) {
+			schema := &Schema{Properties: map[string]*Schema{
+				"value": {Format: testCase.format},
+			}}
+
+			for _, value := range testCase.valid {
+				assert.Nil(t, CheckFormats(schema, nil, map[string]interface{}{"value": value}), value)
+			}
+			for _, value := range testCase.errors {
+				formatErr := CheckFormats(schema, nil, map[string]interface{}{"value": value})
+				assert.NotNil(t, formatErr, value)
+				assert.Equal(t, "value", formatErr.Param)
+			}
+		})
+	}
+}
+
+func TestCheckFormats_Nested(t *testing.T) {
+	definitions := map[string]*Schema{
+		"price_data": {
+			Properties: map[string]*Schema{
+				"unit_amount_decimal": {Format: FormatDecimal, Type: TypeString},
+			},
+			Type: TypeObject,
+		},
+	}
+	schema := &Schema{Properties: map[string]*Schema{
+		"line_items": {
+			Items: &Schema{
+				Properties: map[string]*Schema{
+					"price_data": {Ref: "#/components/schemas/price_data"},
+				},
+				Type: TypeObject,
+			},
+			Type: TypeArray,
+		},
+		"metadata": {
+			AdditionalProperties: &Schema{Format: FormatEmail, Type: TypeString},
+			Type:                 TypeObject,
+		},
+	}}
+
+	formatErr := CheckFormats(schema, definitions, map[string]interface{}{
+		"line_items": []interface{}{
+			map[string]interface{}{"price_data": map[string]interface{}{"unit_amount_decimal": "1.5"}},
+			map[string]interface{}{"price_data": map[string]interface{}{"unit_amount_decimal": "abc"}},
+		},
+	})
+	assert.Equal(t, &FormatError{
+		Message: "Invalid decimal: abc",
+		Param:   "line_items[1][price_data][unit_amount_decimal]",
+	}, formatErr)
+
+	formatErr = CheckFormats(schema, definitions, map[string]interface{}{
+		"metadata": map[string]interface{}{"contact": "jenny"},
+	})
+	assert.Equal(t, "metadata[contact]", formatErr.Param)
+	assert.Equal(t, "email_invalid", formatErr.Code)
+}
+
+func TestCheckFormats_Branches(t *testing.T) {
+	// Like `created` parameters, which take a timestamp or a range of them
+	schema := &Schema{Properties: map[string]*Schema{
+		"created": {
+			AnyOf: []*Schema{
+				{Format: FormatUnixTime, Type: TypeInteger},
+				{
+					Properties: map[string]*Schema{
+						"gte": {Format: FormatUnixTime, Type: TypeInteger},
+					},
+					Type: TypeObject,
+				},
+				{Enum: []interface{}{"now"}, Type: TypeString},
+			},
+		},
+	}}
+
+	assert.Nil(t, CheckFormats(schema, nil, map[string]interface{}{"created": 1700000000}))
+	assert.Nil(t, CheckFormats(schema, nil, map[string]interface{}{"created": "now"}))
+	assert.Nil(t, CheckFormats(schema, nil, map[string]interface{}{
+		"created": map[string]interface{}{"gte": 1700000000},
+	}))
+
+	formatErr := CheckFormats(schema, nil, map[string]interface{}{"created": -1})
+	assert.Equal(t, "created", formatErr.Param)
+
+	formatErr = CheckFormats(schema, nil, map[string]interface{}{
+		"created": map[string]interface{}{"gte": -1},
+	})
+	assert.Equal(t, "created[gte]", formatErr.Param)
+}
+
+func TestCheckFormats_BypassValidation(t *testing.T) {
+	schema := &Schema{Properties: map[string]*Schema{
+		"email": {Format: FormatEmail, Type: TypeString, XStripeBypassValidation: true},
+	}}
+	assert.Nil(t, CheckFormats(schema, nil, map[string]interface{}{"email": "jenny"}))
+}
+
+func TestValidator_FormatsLeftToCheckFormats(t *testing.T) {
+	// The validator is lenient about URLs, so they're left to CheckFormats
+	schema := Schema{Format: FormatURI, Type: "string"}
+	v, err := GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate("example.com"))
+}
@@ -0,0 +1,192 @@
+package spec
+
+import (
+	"fmt"
+	"strings"
+)
+
+//
+// Private values
+//
+
+// schemaAnnotationFields are fields that describe a schema without
+// constraining it. They're allowed next to a `$ref` without changing what the
+// reference means.
+var schemaAnnotationFields = map[string]bool{
+	"$ref":        true,
+	"deprecated":  true,
+	"description": true,
+	"examples":    true,
+	"title":       true,
+}
+
+//
+// Private functions
+//
+
+// normalizeSchemaFields converts the parts of an OpenAPI 3.1 schema (which is
+// a full JSON Schema 2020-12 schema) that differ from OpenAPI 3.0 into their
+// 3.0 equivalents, so that the rest of stripe-mock only has to understand
+// one. Schemas that are already in 3.0's form are left alone.
+//
+//   - A `type` that's a list including `"null"` becomes `nullable`, and a
+//     list of several other types becomes an `anyOf` of each.
+//   - `{"type": "null"}` branches of an `anyOf` or `oneOf` become `nullable`.
+//   - Numeric `exclusiveMinimum` and `exclusiveMaximum` become a `minimum` or
+//     `maximum` with a boolean flag.
+//   - A `$ref` with constraints next to it becomes an `allOf` of the
+//     reference and the constraints. Only `nullable` next to it becomes the
+//     `anyOf` with a single branch that Stripe's specs use for references
+//     that may be null.
+//
+// Returns true if anything was changed.
+func normalizeSchemaFields(rawFields map[string]interface{}) (bool, error) {
+	changed := false
+
+	if rawType, ok := rawFields["type"]; ok {
+		typeChanged, err := normalizeType(rawFields, rawType)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || typeChanged
+	}
+
+	for _, bound := range []string{"Maximum", "Minimum"} {
+		exclusiveField := "exclusive" + bound
+		if value, ok := rawFields[exclusiveField].(float64); ok {
+			rawFields[strings.ToLower(bound)] = value
+			rawFields[exclusiveField] = true
+			changed = true
+		}
+	}
+
+	for _, field := range []string{"anyOf", "oneOf"} {
+		branches, ok := rawFields[field].([]interface{})
+		if !ok {
+			continue
+		}
+
+		var nonNullBranches []interface{}
+		for _, branch := range branches {
+			if isNullSchema(branch) {
+				continue
+			}
+			nonNullBranches = append(nonNullBranches, branch)
+		}
+
+		if len(nonNullBranches) != len(branches) {
+			rawFields[field] = nonNullBranches
+			rawFields["nullable"] = true
+			changed = true
+		}
+	}
+
+	if ref, ok := rawFields["$ref"]; ok {
+		var constraints []string
+		for field := range rawFields {
+			if !schemaAnnotationFields[field] && !strings.HasPrefix(field, "x-") {
+				constraints = append(constraints, field)
+			}
+		}
+
+		switch {
+		case len(constraints) == 0:
+
+		case len(constraints) == 1 && constraints[0] == "nullable":
+			delete(rawFields, "$ref")
+			rawFields["anyOf"] = []interface{}{map[string]interface{}{"$ref": ref}}
+			changed = true
+
+		default:
+			siblings := make(map[string]interface{})
+			for _, field := range constraints {
+				siblings[field] = rawFields[field]
+				delete(rawFields, field)
+			}
+			delete(rawFields, "$ref")
+			rawFields["allOf"] = []interface{}{
+				map[string]interface{}{"$ref": ref},
+				siblings,
+			}
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// normalizeType converts a schema's `type` when it's a list of types or
+// `"null"`.
+func normalizeType(rawFields map[string]interface{}, rawType interface{}) (bool, error) {
+	var types []interface{}
+	switch rawType := rawType.(type) {
+	case string:
+		if rawType != "null" {
+			return false, nil
+		}
+		types = []interface{}{rawType}
+	case []interface{}:
+		types = rawType
+	default:
+		return false, fmt.Errorf("unsupported type in JSON schema: %v", rawType)
+	}
+
+	var nonNullTypes []interface{}
+	for _, typ := range types {
+		if typ == "null" {
+			rawFields["nullable"] = true
+			continue
+		}
+		nonNullTypes = append(nonNullTypes, typ)
+	}
+
+	switch len(nonNullTypes) {
+	case 0:
+		// Only null is allowed.
+		delete(rawFields, "type")
+		rawFields["enum"] = []interface{}{nil}
+
+	case 1:
+		rawFields["type"] = nonNullTypes[0]
+
+	default:
+		if _, ok := rawFields["anyOf"]; ok {
+			return false, fmt.Errorf(
+				"unsupported combination of anyOf and a list of types in JSON schema: %v",
+				rawType)
+		}
+
+		delete(rawFields, "type")
+		branches := make([]interface{}, len(nonNullTypes))
+		for i, typ := range nonNullTypes {
+			branches[i] = map[string]interface{}{"type": typ}
+		}
+		rawFields["anyOf"] = branches
+	}
+
+	return true, nil
+}
+
+// isNullSchema checks whether a raw schema only allows null.
+func isNullSchema(rawSchema interface{}) bool {
+	rawFields, ok := rawSchema.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	for field, value := range rawFields {
+		switch {
+		case field == "type":
+			types, ok := value.([]interface{})
+			if value != "null" && (!ok || len(types) != 1 || types[0] != "null") {
+				return false
+			}
+		case schemaAnnotationFields[field] && field != "$ref":
+		default:
+			return false
+		}
+	}
+
+	_, ok = rawFields["type"]
+	return ok
+}
@@ -0,0 +1,117 @@
+package spec
+
+import (
+	"encoding/json"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestUnmarshal_TypeList(t *testing.T) {
+	var schema Schema
+	err := json.Unmarshal([]byte(`{"type": ["string", "null"]}`), &schema)
+	assert.NoError(t, err)
+	assert.Equal(t, "string", schema.Type)
+	assert.True(t, schema.Nullable)
+
+	schema = Schema{}
+	err = json.Unmarshal([]byte(`{"type": ["integer", "string"]}`), &schema)
+	assert.NoError(t, err)
+	assert.Equal(t, "", schema.Type)
+	assert.False(t, schema.Nullable)
+	assert.Equal(t, 2, len(schema.AnyOf))
+	assert.Equal(t, "integer", schema.AnyOf[0].Type)
+	assert.Equal(t, "string", schema.AnyOf[1].Type)
+
+	schema = Schema{}
+	err = json.Unmarshal([]byte(`{"type": "null"}`), &schema)
+	assert.NoError(t, err)
+	assert.True(t, schema.Nullable)
+	assert.Equal(t, []interface{}{nil}, schema.Enum)
+
+	schema = Schema{}
+	err = json.Unmarshal([]byte(`{"type": 123}`), &schema)
+	assert.Error(t, err)
+}
+
+func TestUnmarshal_NullBranch(t *testing.T) {
+	var schema Schema
+	err := json.Unmarshal([]byte(`{
+		"anyOf": [
+			{"$ref": "#/components/schemas/customer"},
+			{"type": "null"}
+		]
+	}`), &schema)
+	assert.NoError(t, err)
+	assert.True(t, schema.Nullable)
+	assert.Equal(t, 1, len(schema.AnyOf))
+	assert.Equal(t, "#/components/schemas/customer", schema.AnyOf[0].Ref)
+
+	schema = Schema{}
+	err = json.Unmarshal([]byte(`{
+		"oneOf": [
+			{"type": "string"},
+			{"type": "integer"},
+			{"description": "Not set.", "type": ["null"]}
+		]
+	}`), &schema)
+	assert.NoError(t, err)
+	assert.True(t, schema.Nullable)
+	assert.Equal(t, 2, len(schema.OneOf))
+}
+
+func TestUnmarshal_ExclusiveBounds(t *testing.T) {
+	var schema Schema
+	err := json.Unmarshal([]byte(`{"exclusiveMaximum": 10, "exclusiveMinimum": 0, "type": "integer"}`), &schema)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, *schema.Minimum)
+	assert.True(t, schema.ExclusiveMinimum)
+	assert.Equal(t, 10.0, *schema.Maximum)
+	assert.True(t, schema.ExclusiveMaximum)
+
+	// OpenAPI 3.0's boolean form still works
+	schema = Schema{}
+	err = json.Unmarshal([]byte(`{"exclusiveMinimum": true, "minimum": 0, "type": "integer"}`), &schema)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, *schema.Minimum)
+	assert.True(t, schema.ExclusiveMinimum)
+}
+
+func TestUnmarshal_RefSiblings(t *testing.T) {
+	// Annotations don't change the reference
+	var schema Schema
+	err := json.Unmarshal([]byte(`{
+		"$ref": "#/components/schemas/customer",
+		"description": "The customer.",
+		"x-stripeBypassValidation": true
+	}`), &schema)
+	assert.NoError(t, err)
+	assert.Equal(t, "#/components/schemas/customer", schema.Ref)
+
+	schema = Schema{}
+	err = json.Unmarshal([]byte(`{"$ref": "#/components/schemas/customer", "nullable": true}`), &schema)
+	assert.NoError(t, err)
+	assert.Equal(t, "", schema.Ref)
+	assert.True(t, schema.Nullable)
+	assert.Equal(t, 1, len(schema.AnyOf))
+	assert.Equal(t, "#/components/schemas/customer", schema.AnyOf[0].Ref)
+
+	schema = Schema{}
+	err = json.Unmarshal([]byte(`{
+		"$ref": "#/components/schemas/address",
+		"description": "The shipping address.",
+		"required": ["line1"]
+	}`), &schema)
+	assert.NoError(t, err)
+	assert.Equal(t, "", schema.Ref)
+	assert.Equal(t, 2, len(schema.AllOf))
+	assert.Equal(t, "#/components/schemas/address", schema.AllOf[0].Ref)
+	assert.Equal(t, []string{"line1"}, schema.AllOf[1].Required)
+}
+
+func TestUnmarshal_Examples(t *testing.T) {
+	var schema Schema
+	err := json.Unmarshal([]byte(`{"examples": ["cus_123"], "type": "string"}`), &schema)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"cus_123"}, schema.Examples)
+}
@@ -0,0 +1,102 @@
+package spec
+
+import (
+	"fmt"
+	"strings"
+)
+
+//
+// Public functions
+//
+
+// ResolveReferences replaces the parameters, request bodies, and responses of
+// the specification's operations that are references (like
+// `#/components/parameters/expand`) with the components that they refer to,
+// so that operations can be used without looking anything up. References to
+// schemas are left as they are.
+//
+// Returns an error if a reference doesn't point into the components or
+// refers to one that doesn't exist.
+func (s *Spec) ResolveReferences() error {
+	for path, verbs := range s.Paths {
+		for verb, operation := range verbs {
+			err := s.resolveOperationReferences(operation)
+			if err != nil {
+				return fmt.Errorf("error in %s %s: %v", strings.ToUpper(string(verb)), path, err)
+			}
+		}
+	}
+	return nil
+}
+
+//
+// Private constants
+//
+
+// maxReferenceDepth is how many references can be followed to get to a
+// component, which keeps a cycle of references from looping forever.
+const maxReferenceDepth = 10
+
+//
+// Private functions
+//
+
+// componentFromJSONPointer extracts the name of a component from a JSON
+// pointer into a section of the components, so "#/components/parameters/expand"
+// would become just "expand" for the "parameters" section.
+func componentFromJSONPointer(pointer, section string) (string, error) {
+	prefix := "#/components/" + section + "/"
+	if !strings.HasPrefix(pointer, prefix) || strings.Contains(pointer[len(prefix):], "/") {
+		return "", fmt.Errorf("expected '%s...' but got '%v'", prefix, pointer)
+	}
+	return pointer[len(prefix):], nil
+}
+
+func (s *Spec) resolveOperationReferences(operation *Operation) error {
+	for i, parameter := range operation.Parameters {
+		for depth := 0; parameter.Ref != ""; depth++ {
+			name, err := componentFromJSONPointer(parameter.Ref, "parameters")
+			if err != nil {
+				return err
+			}
+
+			component, ok := s.Components.Parameters[name]
+			if !ok || depth == maxReferenceDepth {
+				return fmt.Errorf("couldn't dereference: %v", parameter.Ref)
+			}
+			parameter = component
+		}
+		operation.Parameters[i] = parameter
+	}
+
+	for depth := 0; operation.RequestBody != nil && operation.RequestBody.Ref != ""; depth++ {
+		name, err := componentFromJSONPointer(operation.RequestBody.Ref, "requestBodies")
+		if err != nil {
+			return err
+		}
+
+		component, ok := s.Components.RequestBodies[name]
+		if !ok || depth == maxReferenceDepth {
+			return fmt.Errorf("couldn't dereference: %v", operation.RequestBody.Ref)
+		}
+		operation.RequestBody = component
+	}
+
+	for status, response := range operation.Responses {
+		for depth := 0; response.Ref != ""; depth++ {
+			name, err := componentFromJSONPointer(response.Ref, "responses")
+			if err != nil {
+				return err
+			}
+
+			component, ok := s.Components.Responses[name]
+			if !ok || depth == maxReferenceDepth {
+				return fmt.Errorf("couldn't dereference: %v", response.Ref)
+			}
+			response = component
+		}
+		operation.Responses[status] = response
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package spec
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestResolveReferences(t *testing.T) {
+	expand := &Parameter{In: ParameterQuery, Name: "expand"}
+	body := &RequestBody{Required: true}
+	response := Response{Description: "A charge."}
+
+	spec := &Spec{
+		Components: Components{
+			Parameters: map[string]*Parameter{
+				"expand":       expand,
+				"expand_alias": {Ref: "#/components/parameters/expand"},
+			},
+			RequestBodies: map[string]*RequestBody{"charge": body},
+			Responses:     map[string]Response{"charge": response},
+		},
+		Paths: map[Path]map[HTTPVerb]*Operation{
+			"/v1/charges": {
+				"post": {
+					Parameters:  []*Parameter{{Ref: "#/components/parameters/expand_alias"}},
+					RequestBody: &RequestBody{Ref: "#/components/requestBodies/charge"},
+					Responses: map[StatusCode]Response{
+						"200": {Ref: "#/components/responses/charge"},
+					},
+				},
+			},
+		},
+	}
+
+	assert.NoError(t, spec.ResolveReferences())
+
+	operation := spec.Paths["/v1/charges"]["post"]
+	assert.Equal(t, expand, operation.Parameters[0])
+	assert.Equal(t, body, operation.RequestBody)
+	assert.Equal(t, response, operation.Responses["200"])
+}
+
+func TestResolveReferences_Invalid(t *testing.T) {
+	for _, ref := range []string{
+		"#/components/parameters/missing",
+		"#/components/schemas/charge",
+		"#/components/parameters/expand/schema",
+	} {
+		spec := &Spec{
+			Components: Components{
+				Parameters: map[string]*Parameter{"expand": {Name: "expand"}},
+			},
+			Paths: map[Path]map[HTTPVerb]*Operation{
+				"/v1/charges": {
+					"get": {Parameters: []*Parameter{{Ref: ref}}},
+				},
+			},
+		}
+		assert.Error(t, spec.ResolveReferences(), ref)
+	}
+
+	// A cycle of references
+	spec := &Spec{
+		Components: Components{
+			Parameters: map[string]*Parameter{
+				"a": {Ref: "#/components/parameters/b"},
+				"b": {Ref: "#/components/parameters/a"},
+			},
+		},
+		Paths: map[Path]map[HTTPVerb]*Operation{
+			"/v1/charges": {
+				"get": {Parameters: []*Parameter{{Ref: "#/components/parameters/a"}}},
+			},
+		},
+	}
+	assert.Error(t, spec.ResolveReferences())
+}
@@ -32,7 +32,22 @@ const (
 // Components is a struct for the components section of an OpenAPI
 // specification.
 type Components struct {
-	Schemas map[string]*Schema `json:"schemas"`
+	Parameters    map[string]*Parameter   `json:"parameters,omitempty"`
+	RequestBodies map[string]*RequestBody `json:"requestBodies,omitempty"`
+	Responses     map[string]Response     `json:"responses,omitempty"`
+	Schemas       map[string]*Schema      `json:"schemas"`
+}
+
+// Discriminator is a struct for the `discriminator` of a schema with `oneOf`
+// or `anyOf`, which names a property whose value identifies the branch that an
+// object belongs to.
+type Discriminator struct {
+	// Mapping maps values of the property to references to the branches that
+	// they identify. Values that aren't in it identify the branch whose
+	// component name is the same as the value.
+	Mapping map[string]string `json:"mapping,omitempty"`
+
+	PropertyName string `json:"propertyName"`
 }
 
 // ExpansionResources is a struct for possible expansions in a resource.
@@ -63,13 +78,25 @@ type Info struct {
 var supportedSchemaFields = []string{
 	"$ref",
 	"additionalProperties",
+	"allOf",
 	"anyOf",
+	"const",
 	"description",
+	"discriminator",
 	"enum",
+	"examples",
+	"exclusiveMaximum",
+	"exclusiveMinimum",
 	"format",
 	"items",
+	"maxItems",
 	"maxLength",
+	"maximum",
+	"minItems",
+	"minLength",
+	"minimum",
 	"nullable",
+	"oneOf",
 	"pattern",
 	"properties",
 	"required",
@@ -78,6 +105,7 @@ var supportedSchemaFields = []string{
 	"x-expandableFields",
 	"x-expansionResources",
 	"x-resourceId",
+	"x-stripeBypassValidation",
 
 	// This is currently being used to store additional metadata for our SDKs. It's
 	// passed through our Spec and should be ignored
@@ -89,11 +117,6 @@ var supportedSchemaFields = []string{
 	// This isn't used in our SDK, but is additional metadata unnecessary for
 	// stripe-mock.
 	"deprecated",
-
-	// This is currently a hint for the server-side so I haven't included it in
-	// Schema yet. If we do start validating responses that come out of
-	// stripe-mock, we may need to observe this as well.
-	"x-stripeBypassValidation",
 }
 
 // Schema is a struct representing a JSON schema.
@@ -103,17 +126,32 @@ type Schema struct {
 	// JSON schema that describes the expected format of any additional properties.
 	AdditionalProperties        *Schema `json:"-"`
 	AdditionalPropertiesAllowed bool
+	AllOf                       []*Schema          `json:"allOf,omitempty"`
 	AnyOf                       []*Schema          `json:"anyOf,omitempty"`
+	Discriminator               *Discriminator     `json:"discriminator,omitempty"`
 	Enum                        []interface{}      `json:"enum,omitempty"`
+	Examples                    []interface{}      `json:"examples,omitempty"`
+	ExclusiveMaximum            bool               `json:"exclusiveMaximum,omitempty"`
+	ExclusiveMinimum            bool               `json:"exclusiveMinimum,omitempty"`
 	Format                      string             `json:"format,omitempty"`
 	Items                       *Schema            `json:"items,omitempty"`
+	MaxItems                    *int               `json:"maxItems,omitempty"`
 	MaxLength                   int                `json:"maxLength,omitempty"`
+	Maximum                     *float64           `json:"maximum,omitempty"`
+	MinItems                    int                `json:"minItems,omitempty"`
+	MinLength                   int                `json:"minLength,omitempty"`
+	Minimum                     *float64           `json:"minimum,omitempty"`
 	Nullable                    bool               `json:"nullable,omitempty"`
+	OneOf                       []*Schema          `json:"oneOf,omitempty"`
 	Pattern                     string             `json:"pattern,omitempty"`
 	Properties                  map[string]*Schema `json:"properties,omitempty"`
 	Required                    []string           `json:"required,omitempty"`
 	Type                        string             `json:"type,omitempty"`
 
+	// Const is the only value that the schema allows. A `const` of null can't
+	// be told apart from no `const` at all, but `nullable` covers that case.
+	Const interface{} `json:"const,omitempty"`
+
 	// Ref is populated if this JSON Schema is actually a JSON reference, and
 	// it defines the location of the actual schema definition.
 	Ref string `json:"$ref,omitempty"`
@@ -121,6 +159,12 @@ type Schema struct {
 	XExpandableFields   *[]string           `json:"x-expandableFields,omitempty"`
 	XExpansionResources *ExpansionResources `json:"x-expansionResources,omitempty"`
 	XResourceID         string              `json:"x-resourceId,omitempty"`
+
+	// XStripeBypassValidation marks a schema whose values the Stripe API
+	// accepts even when they don't meet its constraints, like an enum that
+	// new values may be added to before the spec knows about them. Only the
+	// schema's type is validated.
+	XStripeBypassValidation bool `json:"x-stripeBypassValidation,omitempty"`
 }
 
 func (s *Schema) String() string {
@@ -133,6 +177,9 @@ func (s *Schema) String() string {
 
 // UnmarshalJSON is a custom JSON unmarshaling implementation for Schema that
 // provides better error messages instead of silently ignoring fields.
+//
+// Schemas from OpenAPI 3.1 specifications are converted to their OpenAPI 3.0
+// equivalents (see normalizeSchemaFields).
 func (s *Schema) UnmarshalJSON(data []byte) error {
 	var rawFields map[string]interface{}
 	err := json.Unmarshal(data, &rawFields)
@@ -140,6 +187,17 @@ func (s *Schema) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	changed, err := normalizeSchemaFields(rawFields)
+	if err != nil {
+		return err
+	}
+	if changed {
+		data, err = json.Marshal(rawFields)
+		if err != nil {
+			return err
+		}
+	}
+
 	additionalPropertiesValue := rawFields["additionalProperties"]
 
 	for _, supportedField := range supportedSchemaFields {
@@ -213,6 +271,10 @@ type Parameter struct {
 	Name        string  `json:"name"`
 	Required    bool    `json:"required"`
 	Schema      *Schema `json:"schema"`
+
+	// Ref is populated if this parameter is a reference to one in the
+	// specification's components. See Spec.ResolveReferences.
+	Ref string `json:"$ref,omitempty"`
 }
 
 // Path is a type for an HTTP path in an OpenAPI specification.
@@ -223,6 +285,10 @@ type Path string
 type RequestBody struct {
 	Content  map[string]MediaType `json:"content"`
 	Required bool                 `json:"required"`
+
+	// Ref is populated if this request body is a reference to one in the
+	// specification's components. See Spec.ResolveReferences.
+	Ref string `json:"$ref,omitempty"`
 }
 
 // Response is a struct representing the response of an HTTP operation in an
@@ -230,6 +296,10 @@ type RequestBody struct {
 type Response struct {
 	Description string               `json:"description"`
 	Content     map[string]MediaType `json:"content"`
+
+	// Ref is populated if this response is a reference to one in the
+	// specification's components. See Spec.ResolveReferences.
+	Ref string `json:"$ref,omitempty"`
 }
 
 // ResourceID is a type for the ID of a response resource in an OpenAPI
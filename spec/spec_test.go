@@ -61,9 +61,52 @@ func TestUnmarshal_ObjectWithAdditionalPropertiesDefault(t *testing.T) {
 	assert.Nil(t, schema.AdditionalProperties)
 }
 
+func TestUnmarshal_Keywords(t *testing.T) {
+	data := []byte(`{
+		"allOf": [{"$ref": "#/components/schemas/base"}],
+		"discriminator": {
+			"mapping": {"card": "#/components/schemas/card"},
+			"propertyName": "type"
+		},
+		"oneOf": [
+			{"const": "card", "type": "string"},
+			{"maxItems": 5, "minItems": 1, "type": "array"},
+			{"maxLength": 10, "minLength": 2, "type": "string"},
+			{"exclusiveMinimum": true, "maximum": 100, "minimum": 0, "type": "integer"}
+		]
+	}`)
+	var schema Schema
+	err := json.Unmarshal(data, &schema)
+	assert.NoError(t, err)
+	assert.Equal(t, "#/components/schemas/base", schema.AllOf[0].Ref)
+	assert.Equal(t, "type", schema.Discriminator.PropertyName)
+	assert.Equal(t, "#/components/schemas/card", schema.Discriminator.Mapping["card"])
+	assert.Equal(t, "card", schema.OneOf[0].Const)
+	assert.Equal(t, 5, *schema.OneOf[1].MaxItems)
+	assert.Equal(t, 1, schema.OneOf[1].MinItems)
+	assert.Equal(t, 2, schema.OneOf[2].MinLength)
+	assert.Equal(t, 0.0, *schema.OneOf[3].Minimum)
+	assert.Equal(t, 100.0, *schema.OneOf[3].Maximum)
+	assert.True(t, schema.OneOf[3].ExclusiveMinimum)
+	assert.False(t, schema.OneOf[3].ExclusiveMaximum)
+}
+
+func TestUnmarshal_BypassValidation(t *testing.T) {
+	data := []byte(`{
+		"enum": ["auto", "en"],
+		"type": "string",
+		"x-stripeBypassValidation": true
+	}`)
+	var schema Schema
+	err := json.Unmarshal(data, &schema)
+	assert.NoError(t, err)
+	assert.True(t, schema.XStripeBypassValidation)
+	assert.Equal(t, []interface{}{"auto", "en"}, schema.Enum)
+}
+
 func TestUnmarshal_UnsupportedField(t *testing.T) {
-	// We don't support 'const'
-	data := []byte(`{const: "hello"}`)
+	// We don't support 'not'
+	data := []byte(`{"not": {"type": "string"}}`)
 	var schema Schema
 	err := json.Unmarshal(data, &schema)
 	assert.Error(t, err)
@@ -61,8 +61,14 @@ func GetComponentsForValidation(components *Components) *ComponentsForValidation
 // like "string".
 //
 // This converter only handles the options that are supported by the spec.Schema
-// type, and it must be updated when new options are supported.
+// type, and it must be updated when new options are supported. A
+// `discriminator` isn't converted because JSON Schema has no equivalent, but
+// the `oneOf` or `anyOf` that it goes with is validated all the same.
 func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
+	if oai.XStripeBypassValidation {
+		oai = relaxSchema(oai)
+	}
+
 	jss := make(map[string]interface{})
 	if !oai.AdditionalPropertiesAllowed {
 		jss["additionalProperties"] = false
@@ -72,6 +78,13 @@ func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
 		}
 	}
 
+	if len(oai.AllOf) != 0 {
+		var jssAllOf = make([]interface{}, len(oai.AllOf))
+		for index, oaiSubschema := range oai.AllOf {
+			jssAllOf[index] = getJSONSchemaForOpenAPI3Schema(oaiSubschema)
+		}
+		jss["allOf"] = jssAllOf
+	}
 	if len(oai.AnyOf) != 0 {
 		var jssAnyOf = make([]interface{}, len(oai.AnyOf))
 		for index, oaiSubschema := range oai.AnyOf {
@@ -82,6 +95,11 @@ func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
 		}
 		jss["anyOf"] = jssAnyOf
 	}
+	if oai.Const != nil {
+		// The validator only understands draft 4 of JSON Schema, which
+		// doesn't have `const`, so it's expressed as an enum of one value.
+		jss["enum"] = []interface{}{oai.Const}
+	}
 	if len(oai.Enum) != 0 {
 		var jssEnum = make([]interface{}, len(oai.Enum))
 		for index, oaiValue := range oai.Enum {
@@ -89,17 +107,53 @@ func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
 		}
 		jss["enum"] = jssEnum
 	}
-	if oai.Format != "" {
-		// Note that the major format that will be seen here, unix-time, will
-		// not be supported by the validator we're using -- we should probably
-		// see if we can support that properly.
+	if _, ok := formatCheckers[oai.Format]; oai.Format != "" && !ok {
+		// Formats like unix-time and decimal aren't supported by the
+		// validator we're using, and its checks of others are too lenient,
+		// so the formats in formatCheckers are checked by CheckFormats
+		// instead.
 		jss["format"] = oai.Format
 	}
 	if oai.Items != nil {
 		jss["items"] = getJSONSchemaForOpenAPI3Schema(oai.Items)
 	}
+	// The validator reads lengths and counts as the float64s that
+	// encoding/json produces and ignores them if they're anything else.
+	if oai.MaxItems != nil {
+		jss["maxItems"] = float64(*oai.MaxItems)
+	}
 	if oai.MaxLength != 0 {
-		jss["maxLength"] = oai.MaxLength
+		jss["maxLength"] = float64(oai.MaxLength)
+	}
+	if oai.Maximum != nil {
+		jss["maximum"] = *oai.Maximum
+		if oai.ExclusiveMaximum {
+			jss["exclusiveMaximum"] = true
+		}
+	}
+	if oai.MinItems != 0 {
+		jss["minItems"] = float64(oai.MinItems)
+	}
+	if oai.MinLength != 0 {
+		jss["minLength"] = float64(oai.MinLength)
+	}
+	if oai.Minimum != nil {
+		jss["minimum"] = *oai.Minimum
+		if oai.ExclusiveMinimum {
+			jss["exclusiveMinimum"] = true
+		}
+	}
+	if len(oai.OneOf) != 0 {
+		var jssOneOf = make([]interface{}, len(oai.OneOf))
+		for index, oaiSubschema := range oai.OneOf {
+			jssOneOf[index] = getJSONSchemaForOpenAPI3Schema(oaiSubschema)
+		}
+		if oai.Nullable {
+			// Unlike with anyOf, the null branch can't match anything else
+			// or values of the other branches would match two branches.
+			jssOneOf = append(jssOneOf, map[string]interface{}{"type": "null"})
+		}
+		jss["oneOf"] = jssOneOf
 	}
 	if oai.Pattern != "" {
 		jss["pattern"] = oai.Pattern
@@ -130,3 +184,23 @@ func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
 	}
 	return jss
 }
+
+// relaxSchema gets a copy of a schema marked with `x-stripeBypassValidation`
+// that's validated the way the Stripe API validates it, which is only by type.
+func relaxSchema(oai *Schema) *Schema {
+	relaxed := *oai
+	relaxed.Const = nil
+	relaxed.Enum = nil
+	relaxed.ExclusiveMaximum = false
+	relaxed.ExclusiveMinimum = false
+	relaxed.Format = ""
+	relaxed.MaxItems = nil
+	relaxed.MaxLength = 0
+	relaxed.Maximum = nil
+	relaxed.MinItems = 0
+	relaxed.MinLength = 0
+	relaxed.Minimum = nil
+	relaxed.Pattern = ""
+	relaxed.XStripeBypassValidation = false
+	return &relaxed
+}
@@ -47,3 +47,117 @@ func TestValidator_Reference(t *testing.T) {
 	assert.NoError(t, v.Validate("hello"))
 	assert.Error(t, v.Validate(123))
 }
+
+func TestValidator_AllOf(t *testing.T) {
+	schema := Schema{
+		AdditionalPropertiesAllowed: true,
+		AllOf: []*Schema{
+			{Type: "object", Properties: map[string]*Schema{"a": {Type: "string"}}, Required: []string{"a"},
+				AdditionalPropertiesAllowed: true},
+			{Type: "object", Properties: map[string]*Schema{"b": {Type: "integer"}}, Required: []string{"b"},
+				AdditionalPropertiesAllowed: true},
+		},
+	}
+	v, err := GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate(map[string]interface{}{"a": "hello", "b": 1}))
+	assert.Error(t, v.Validate(map[string]interface{}{"a": "hello"}))
+}
+
+func TestValidator_OneOf(t *testing.T) {
+	schema := Schema{
+		AdditionalPropertiesAllowed: true,
+		Nullable:                    true,
+		OneOf: []*Schema{
+			{Type: "string"},
+			{Type: "integer"},
+		},
+	}
+	v, err := GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate("hello"))
+	assert.NoError(t, v.Validate(123))
+	assert.NoError(t, v.Validate(nil))
+	assert.Error(t, v.Validate(true))
+}
+
+func TestValidator_Const(t *testing.T) {
+	schema := Schema{
+		Const: "card",
+		Type:  "string",
+	}
+	v, err := GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate("card"))
+	assert.Error(t, v.Validate("bank_account"))
+}
+
+func TestValidator_Bounds(t *testing.T) {
+	minimum := 1.0
+	maximum := 10.0
+	schema := Schema{
+		ExclusiveMaximum: true,
+		Maximum:          &maximum,
+		Minimum:          &minimum,
+		Type:             "integer",
+	}
+	v, err := GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate(1))
+	assert.NoError(t, v.Validate(9))
+	assert.Error(t, v.Validate(0))
+	assert.Error(t, v.Validate(10))
+
+	schema = Schema{
+		MaxLength: 3,
+		MinLength: 2,
+		Type:      "string",
+	}
+	v, err = GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate("ab"))
+	assert.Error(t, v.Validate("a"))
+	assert.Error(t, v.Validate("abcd"))
+
+	maxItems := 2
+	schema = Schema{
+		Items:    &Schema{Type: "string"},
+		MaxItems: &maxItems,
+		MinItems: 1,
+		Type:     "array",
+	}
+	v, err = GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate([]interface{}{"a"}))
+	assert.Error(t, v.Validate([]interface{}{}))
+	assert.Error(t, v.Validate([]interface{}{"a", "b", "c"}))
+}
+
+func TestValidator_BypassValidation(t *testing.T) {
+	schema := Schema{
+		AdditionalPropertiesAllowed: true,
+		Properties: map[string]*Schema{
+			"locale": {
+				Enum:                    []interface{}{"auto", "en"},
+				MaxLength:               5,
+				Type:                    "string",
+				XStripeBypassValidation: true,
+			},
+			"mode": {
+				Enum: []interface{}{"payment", "setup"},
+				Type: "string",
+			},
+		},
+		Type: "object",
+	}
+	v, err := GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate(map[string]interface{}{"locale": "zh-Hant-TW"}))
+	assert.Error(t, v.Validate(map[string]interface{}{"mode": "subscription"}))
+
+	// The type is still checked
+	assert.Error(t, v.Validate(map[string]interface{}{"locale": 123}))
+
+	// The schema itself isn't changed
+	assert.Equal(t, []interface{}{"auto", "en"}, schema.Properties["locale"].Enum)
+}